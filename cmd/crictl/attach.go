@@ -18,8 +18,11 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"net/url"
+	"os"
 
+	dockerterm "github.com/docker/docker/pkg/term"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -32,7 +35,7 @@ var runtimeAttachCommand = &cli.Command{
 	Usage:                  "Attach to a running container",
 	ArgsUsage:              "CONTAINER-ID",
 	UseShortOptionHandling: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "tty",
 			Aliases: []string{"t"},
@@ -43,7 +46,27 @@ var runtimeAttachCommand = &cli.Command{
 			Aliases: []string{"i"},
 			Usage:   "Keep STDIN open",
 		},
-	},
+		&cli.BoolFlag{
+			Name:  "no-stdin",
+			Usage: "Do not attach STDIN, even with --stdin. Combined with --tty, the output of the TTY is shown",
+		},
+		&cli.StringFlag{
+			Name:  "detach-keys",
+			Value: "ctrl-p,ctrl-q",
+			Usage: "Key sequence leaving the container without stopping it, in the format ctrl-<value> or a single character. An empty sequence disables detaching",
+		},
+		&cli.BoolFlag{
+			Name:  "logs",
+			Usage: "Print the recent log lines of the container before attaching",
+		},
+		&cli.Int64Flag{
+			Name:  "tail",
+			Value: 10,
+			Usage: "Number of log lines printed with --logs, -1 prints the whole log",
+		},
+		recordFlag(),
+		transportFlag(),
+	}, streamingTLSFlags()...),
 	Action: func(context *cli.Context) error {
 		id := context.Args().First()
 		if id == "" {
@@ -57,9 +80,22 @@ var runtimeAttachCommand = &cli.Command{
 		defer closeConnection(context, conn)
 
 		var opts = attachOptions{
-			id:    id,
-			tty:   context.Bool("tty"),
-			stdin: context.Bool("stdin"),
+			id:        id,
+			tty:       context.Bool("tty"),
+			stdin:     context.Bool("stdin") && !context.Bool("no-stdin"),
+			transport: context.String("transport"),
+			record:    context.String("record"),
+		}
+		if opts.tlsConfig, err = streamingTLSConfig(context); err != nil {
+			return err
+		}
+		if opts.detachKeys, err = dockerterm.ToBytes(context.String("detach-keys")); err != nil {
+			return errors.Wrap(err, "parse --detach-keys")
+		}
+		if context.Bool("logs") {
+			if err := printRecentLogs(context.Context, runtimeClient, id, context.Int64("tail"), os.Stdout, os.Stderr); err != nil {
+				return errors.Wrap(err, "print logs")
+			}
 		}
 		err = Attach(runtimeClient, opts)
 		if err != nil {
@@ -83,26 +119,38 @@ func Attach(client pb.RuntimeServiceClient, opts attachOptions) error {
 		Stdout:      true,
 		Stderr:      !opts.tty,
 	}
-	logrus.Debugf("AttachRequest: %v", request)
-	r, err := client.Attach(context.Background(), request)
-	logrus.Debugf("AttachResponse: %v", r)
-	if err != nil {
-		return err
+	newURL := func() (*url.URL, error) {
+		logrus.Debugf("AttachRequest: %v", request)
+		r, err := client.Attach(context.Background(), request)
+		logrus.Debugf("AttachResponse: %v", r)
+		if err != nil {
+			return nil, err
+		}
+		URL, err := parseStreamURL(r.Url)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("Attach URL: %v", URL)
+		return URL, nil
+	}
+	var recorder *sessionRecorder
+	if opts.record != "" {
+		var err error
+		if recorder, err = createSessionRecorder(opts.record, opts.id, nil); err != nil {
+			return err
+		}
+		defer recorder.close()
 	}
-	attachURL := r.Url
+	return stream(opts.transport, opts.tlsConfig, opts.stdin, opts.tty, opts.detachKeys, recorder, newURL)
+}
 
-	URL, err := url.Parse(attachURL)
+// printRecentLogs prints the last lines of the log of a container, -1 prints
+// the whole log.
+func printRecentLogs(ctx context.Context, client pb.RuntimeServiceClient, id string, tail int64, stdout, stderr io.Writer) error {
+	logPath, err := containerLogPath(ctx, client, id)
 	if err != nil {
 		return err
 	}
-
-	if URL.Host == "" {
-		URL.Host = kubeletURLHost
-	}
-	if URL.Scheme == "" {
-		URL.Scheme = kubeletURLSchema
-	}
-
-	logrus.Debugf("Attach URL: %v", URL)
-	return stream(opts.stdin, opts.tty, URL)
+	target := &logTarget{id: id, logPath: logPath}
+	return streamLogs(ctx, client, []*logTarget{target}, nil, logStreamOptions{tail: tail}, stdout, stderr)
 }
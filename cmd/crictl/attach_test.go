@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dockerterm "github.com/docker/docker/pkg/term"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestDetachReader(t *testing.T) {
+	keys, err := dockerterm.ToBytes("ctrl-p,ctrl-q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	detached := make(chan struct{})
+	r := &detachReader{r: dockerterm.NewEscapeProxy(strings.NewReader("ls\n\x10x\x10\x11rm\n"), keys), detached: detached}
+	reads := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := r.Read(buf)
+			if err != nil {
+				close(reads)
+				return
+			}
+			reads <- append([]byte(nil), buf[:n]...)
+		}
+	}()
+
+	select {
+	case <-detached:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the reader to detach")
+	}
+	var input []byte
+	for len(reads) > 0 {
+		input = append(input, <-reads...)
+	}
+	// A partial sequence is passed through, the input after the sequence
+	// is not.
+	if string(input) != "ls\n\x10x" {
+		t.Errorf("unexpected input %q", input)
+	}
+}
+
+func TestPrintRecentLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "0.log")
+	writeLog(t, logPath, "000000001 stdout one", "000000002 stderr two", "000000003 stdout three")
+	client := &fakeRuntimeClient{
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c1": {Status: &pb.ContainerStatus{State: pb.ContainerState_CONTAINER_RUNNING, LogPath: logPath}},
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := printRecentLogs(context.Background(), client, "c1", 2, &stdout, &stderr); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "three\n" || stderr.String() != "two\n" {
+		t.Errorf("unexpected output %q and %q", stdout.String(), stderr.String())
+	}
+	if err := printRecentLogs(context.Background(), client, "c2", 2, &stdout, &stderr); err == nil {
+		t.Error("expected an error for a container without status")
+	}
+}
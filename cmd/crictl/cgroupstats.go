@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/cgroups"
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+const (
+	// statsSourceCRI uses the stats of the ListContainerStats call only.
+	statsSourceCRI = "cri"
+	// statsSourceCgroup additionally reads the stats from the cgroup
+	// filesystem.
+	statsSourceCgroup = "cgroup"
+)
+
+// containerStatusInfo is the part of the verbose container status info,
+// as returned by containerd and CRI-O, which locates the cgroup.
+type containerStatusInfo struct {
+	Pid         int `json:"pid"`
+	RuntimeSpec struct {
+		Linux struct {
+			CgroupsPath string `json:"cgroupsPath"`
+		} `json:"linux"`
+	} `json:"runtimeSpec"`
+}
+
+// containerCgroupPath returns the path of the cgroup of a container relative
+// to the cgroup mount point. It is taken from the runtime spec in the verbose
+// container status, or from the /proc/<pid>/cgroup file of its process.
+func containerCgroupPath(ctx context.Context, client pb.RuntimeServiceClient, id string) (string, error) {
+	request := &pb.ContainerStatusRequest{ContainerId: id, Verbose: true}
+	logrus.Debugf("ContainerStatusRequest: %v", request)
+	r, err := client.ContainerStatus(ctx, request)
+	logrus.Debugf("ContainerStatusResponse: %v", r)
+	if err != nil {
+		return "", err
+	}
+	var info containerStatusInfo
+	if data, ok := r.GetInfo()["info"]; ok {
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			return "", errors.Wrap(err, "parse container status info")
+		}
+	}
+	if cgroupsPath := info.RuntimeSpec.Linux.CgroupsPath; cgroupsPath != "" {
+		return cgroups.ExpandPath(cgroupsPath)
+	}
+	if info.Pid > 0 {
+		f, err := os.Open(filepath.Join("/proc", strconv.Itoa(info.Pid), "cgroup"))
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		return cgroups.ParseProcCgroup(f)
+	}
+	return "", errors.New("the container status contains neither a runtime spec nor a pid")
+}
+
+// readCgroupStats reads the cgroup stats of the containers below root.
+// Containers whose cgroup cannot be read, e.g. because they exited in the
+// meantime, are logged and left out.
+func readCgroupStats(ctx context.Context, client pb.RuntimeServiceClient, root string, stats []*pb.ContainerStats) map[string]*cgroups.Stats {
+	res := make(map[string]*cgroups.Stats)
+	for _, s := range stats {
+		id := s.GetAttributes().GetId()
+		cgroupPath, err := containerCgroupPath(ctx, client, id)
+		if err != nil {
+			logrus.Warnf("Unable to find the cgroup of container %s: %v", id, err)
+			continue
+		}
+		cg, err := cgroups.Read(root, cgroupPath)
+		if err != nil {
+			logrus.Warnf("Unable to read the cgroup stats of container %s: %v", id, err)
+			continue
+		}
+		res[id] = cg
+	}
+	return res
+}
+
+// mergeCgroupStats adds the cgroup stats to the JSON of the CRI stats as
+// their "cgroup" field.
+func mergeCgroupStats(s *pb.ContainerStats, cg *cgroups.Stats) (json.RawMessage, error) {
+	data, err := output.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	if cg == nil {
+		return data, nil
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if fields["cgroup"], err = json.Marshal(cg); err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
+
+// cgroupStatsHeader are the columns of the cgroup stats table.
+var cgroupStatsHeader = []string{columnContainer, columnCPU, columnMemory, columnRSS, columnCache,
+	columnPids, columnThrottled, columnBlockIO, columnPressure, columnDisk, columnInodes}
+
+// cgroupStatsRow formats the CRI and cgroup stats of a container as a table
+// row. Values whose controller is not available are shown as "-".
+func cgroupStatsRow(row statsRow, cg *cgroups.Stats) []string {
+	s := row.stats
+	rss, cache, pids, throttled, blockIO, pressure := "-", "-", "-", "-", "-", "-"
+	if cg != nil {
+		if m := cg.Memory; m != nil {
+			rss, cache = units.HumanSize(float64(m.RSSBytes)), units.HumanSize(float64(m.CacheBytes))
+		}
+		if cg.Pids != nil {
+			pids = strconv.FormatUint(cg.Pids.Current, 10)
+		}
+		if c := cg.CPU; c != nil {
+			throttled = time.Duration(c.ThrottledNanoSeconds).Round(time.Millisecond).String()
+		}
+		if io := cg.IO; io != nil {
+			blockIO = units.HumanSize(float64(io.ReadBytes)) + " / " + units.HumanSize(float64(io.WriteBytes))
+		}
+		if p := cg.Pressure; p != nil {
+			pressure = fmt.Sprintf("%s/%s/%s", pressureAvg10(p.CPU), pressureAvg10(p.Memory), pressureAvg10(p.IO))
+		}
+	}
+	return []string{
+		getTruncatedID(s.GetAttributes().GetId(), ""),
+		fmt.Sprintf("%.2f", row.cpuPerc),
+		units.HumanSize(float64(s.GetMemory().GetWorkingSetBytes().GetValue())),
+		rss,
+		cache,
+		pids,
+		throttled,
+		blockIO,
+		pressure,
+		units.HumanSize(float64(s.GetWritableLayer().GetUsedBytes().GetValue())),
+		fmt.Sprintf("%d", s.GetWritableLayer().GetInodesUsed().GetValue()),
+	}
+}
+
+// pressureAvg10 returns the share of time in which some tasks stalled during
+// the last 10 seconds.
+func pressureAvg10(p *cgroups.Pressure) string {
+	if p == nil || p.Some == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", p.Some.Avg10)
+}
+
+// printCgroupStats prints the CRI stats merged with the cgroup stats in a
+// structured output format.
+func printCgroupStats(ctx context.Context, client pb.RuntimeServiceClient, stats []*pb.ContainerStats, printer *output.Printer, opts statsOptions) error {
+	cgroupStats := readCgroupStats(ctx, client, opts.cgroupRoot, stats)
+	list := struct {
+		Stats []json.RawMessage `json:"stats"`
+	}{Stats: []json.RawMessage{}}
+	items := make([]output.Item, 0, len(stats))
+	for _, s := range stats {
+		id := s.GetAttributes().GetId()
+		data, err := mergeCgroupStats(s, cgroupStats[id])
+		if err != nil {
+			return err
+		}
+		list.Stats = append(list.Stats, data)
+		items = append(items, output.Item{Name: id, Object: data})
+	}
+	return printer.PrintList(os.Stdout, list, items)
+}
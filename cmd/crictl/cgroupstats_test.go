@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestReadCgroupStats(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir := filepath.Join(root, "kubepods.slice", "kubepods-pod1.slice", "cri-containerd-c1.scope")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"../../../cgroup.controllers": "memory pids\n",
+		"memory.stat":                 "anon 2048\nfile 1024\n",
+		"memory.current":              "4096\n",
+		"memory.max":                  "max\n",
+		"pids.current":                "7\n",
+		"pids.max":                    "max\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeRuntimeClient{
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c1": {Info: map[string]string{"info": `{"pid":0,"runtimeSpec":{"linux":{"cgroupsPath":"kubepods-pod1.slice:cri-containerd:c1"}}}`}},
+			"c2": {Info: map[string]string{"info": `{"pid":0}`}},
+		},
+	}
+	stats := []*pb.ContainerStats{containerStats("c1", 10, 4000, 100), containerStats("c2", 20, 200, 100)}
+	cgroupStats := readCgroupStats(context.Background(), client, root, stats)
+	if len(cgroupStats) != 1 || cgroupStats["c1"] == nil {
+		t.Fatalf("expected the cgroup stats of c1 only, but got %v", cgroupStats)
+	}
+
+	row := cgroupStatsRow(statsRow{stats: stats[0], cpuPerc: 1}, cgroupStats["c1"])
+	expected := []string{"c1", "1.00", "4kB", "2.048kB", "1.024kB", "7", "-", "-", "-", "100B", "1"}
+	if !reflect.DeepEqual(row, expected) {
+		t.Errorf("expected row %q, but got %q", expected, row)
+	}
+
+	data, err := mergeCgroupStats(stats[0], cgroupStats["c1"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged struct {
+		Attributes struct{ ID string }
+		Cgroup     struct {
+			Version int
+			Memory  struct{ RSSBytes uint64 }
+		}
+	}
+	if err := json.Unmarshal(data, &merged); err != nil {
+		t.Fatal(err)
+	}
+	if merged.Attributes.ID != "c1" || merged.Cgroup.Version != 2 || merged.Cgroup.Memory.RSSBytes != 2048 {
+		t.Errorf("unexpected merged stats %s", data)
+	}
+}
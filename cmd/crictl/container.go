@@ -20,8 +20,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-units"
@@ -32,6 +34,9 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 type containerByCreated []*pb.Container
@@ -42,6 +47,18 @@ func (a containerByCreated) Less(i, j int) bool {
 	return a[i].CreatedAt > a[j].CreatedAt
 }
 
+// restartCountAnnotation is the annotation the kubelet records the restart
+// count of a container in.
+const restartCountAnnotation = "io.kubernetes.container.restartCount"
+
+// containerSortKeys are the well-known --sort-by keys of containers.
+var containerSortKeys = output.SortKeys{
+	"created":   ".createdAt",
+	"name":      ".metadata.name",
+	"state":     ".state",
+	"namespace": `.labels.io\.kubernetes\.pod\.namespace`,
+}
+
 type createOptions struct {
 	// podID of the container
 	podID string
@@ -384,7 +401,8 @@ var containerStatusCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|go-template|table",
+			Usage:   output.Usage,
+			Value:   output.FormatJSON,
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -393,7 +411,7 @@ var containerStatusCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "template",
-			Usage: "The template string is only used when output is go-template; The Template format is golang template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -406,14 +424,7 @@ var containerStatusCommand = &cli.Command{
 		}
 		defer closeConnection(context, runtimeConn)
 
-		for i := 0; i < context.NArg(); i++ {
-			containerID := context.Args().Get(i)
-			err := ContainerStatus(runtimeClient, containerID, context.String("output"), context.String("template"), context.Bool("quiet"))
-			if err != nil {
-				return errors.Wrapf(err, "getting the status of the container %q", containerID)
-			}
-		}
-		return nil
+		return ContainerStatus(runtimeClient, context.Args().Slice(), context.String("output"), context.String("template"), context.Bool("quiet"))
 	},
 }
 
@@ -421,7 +432,7 @@ var listContainersCommand = &cli.Command{
 	Name:                   "ps",
 	Usage:                  "List containers",
 	UseShortOptionHandling: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Aliases: []string{"v"},
@@ -456,7 +467,7 @@ var listContainersCommand = &cli.Command{
 		},
 		&cli.StringSliceFlag{
 			Name:  "label",
-			Usage: "Filter by key=value label",
+			Usage: "Filter by label selector, e.g. key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key",
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -466,8 +477,12 @@ var listContainersCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|table",
-			Value:   "table",
+			Usage:   output.Usage,
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 		&cli.BoolFlag{
 			Name:    "all",
@@ -488,7 +503,12 @@ var listContainersCommand = &cli.Command{
 			Name:  "no-trunc",
 			Usage: "Show output without truncating the ID",
 		},
-	},
+		&cli.BoolFlag{
+			Name:    "watch",
+			Aliases: []string{"w"},
+			Usage:   "Watch containers and highlight the changes",
+		},
+	}, sortFlags(containerSortKeys)...),
 	Action: func(context *cli.Context) error {
 		runtimeClient, runtimeConn, err := getRuntimeClient(context)
 		if err != nil {
@@ -509,14 +529,18 @@ var listContainersCommand = &cli.Command{
 			verbose:    context.Bool("verbose"),
 			quiet:      context.Bool("quiet"),
 			output:     context.String("output"),
+			template:   context.String("template"),
 			all:        context.Bool("all"),
 			nameRegexp: context.String("name"),
 			latest:     context.Bool("latest"),
 			last:       context.Int("last"),
 			noTrunc:    context.Bool("no-trunc"),
 			image:      context.String("image"),
+			sortBy:     context.String("sort-by"),
+			reverse:    context.Bool("reverse"),
+			watch:      context.Bool("watch"),
 		}
-		opts.labels, err = parseLabelStringSlice(context.StringSlice("label"))
+		opts.labels, opts.labelSelector, err = parseLabelStringSlice(context.StringSlice("label"))
 		if err != nil {
 			return err
 		}
@@ -812,41 +836,53 @@ func marshalContainerStatus(cs *pb.ContainerStatus) (string, error) {
 	return marshalMapInOrder(jsonMap, *cs)
 }
 
-// ContainerStatus sends a ContainerStatusRequest to the server, and parses
-// the returned ContainerStatusResponse.
-func ContainerStatus(client pb.RuntimeServiceClient, ID, output string, tmplStr string, quiet bool) error {
+// ContainerStatus sends a ContainerStatusRequest to the server for each of
+// the IDs, and parses the returned ContainerStatusResponses.
+func ContainerStatus(client pb.RuntimeServiceClient, IDs []string, format, tmplStr string, quiet bool) error {
 	verbose := !(quiet)
-	if output == "" { // default to json output
-		output = "json"
-	}
-	if ID == "" {
-		return fmt.Errorf("ID cannot be empty")
-	}
-	request := &pb.ContainerStatusRequest{
-		ContainerId: ID,
-		Verbose:     verbose,
-	}
-	logrus.Debugf("ContainerStatusRequest: %v", request)
-	r, err := client.ContainerStatus(context.Background(), request)
-	logrus.Debugf("ContainerStatusResponse: %v", r)
+	printer, err := output.NewPrinter(format, tmplStr)
 	if err != nil {
 		return err
 	}
 
-	status, err := marshalContainerStatus(r.Status)
-	if err != nil {
-		return err
+	var items []output.Item
+	for _, ID := range IDs {
+		if ID == "" {
+			return fmt.Errorf("ID cannot be empty")
+		}
+		request := &pb.ContainerStatusRequest{
+			ContainerId: ID,
+			Verbose:     verbose,
+		}
+		logrus.Debugf("ContainerStatusRequest: %v", request)
+		r, err := client.ContainerStatus(context.Background(), request)
+		logrus.Debugf("ContainerStatusResponse: %v", r)
+		if err != nil {
+			return errors.Wrapf(err, "getting the status of the container %q", ID)
+		}
+
+		if !printer.Structured() {
+			outputContainerStatusTable(r, verbose)
+			continue
+		}
+		status, err := marshalContainerStatus(r.Status)
+		if err != nil {
+			return err
+		}
+		info, err := marshalStatusInfo(status, r.Info)
+		if err != nil {
+			return err
+		}
+		items = append(items, output.Item{Name: r.Status.Id, Object: info})
 	}
 
-	switch output {
-	case "json", "yaml", "go-template":
-		return outputStatusInfo(status, r.Info, output, tmplStr)
-	case "table": // table output is after this switch block
-	default:
-		return fmt.Errorf("output option cannot be %s", output)
+	if !printer.Structured() {
+		return nil
 	}
+	return printer.PrintItems(os.Stdout, items)
+}
 
-	// output in table format
+func outputContainerStatusTable(r *pb.ContainerStatusResponse, verbose bool) {
 	fmt.Printf("ID: %s\n", r.Status.Id)
 	if r.Status.Metadata != nil {
 		if r.Status.Metadata.Name != "" {
@@ -885,13 +921,19 @@ func ContainerStatus(client pb.RuntimeServiceClient, ID, output string, tmplStr
 	if verbose {
 		fmt.Printf("Info: %v\n", r.GetInfo())
 	}
-
-	return nil
 }
 
 // ListContainers sends a ListContainerRequest to the server, and parses
 // the returned ListContainerResponse.
 func ListContainers(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageServiceClient, opts listOptions) error {
+	printer, err := output.NewPrinter(opts.output, opts.template)
+	if err != nil {
+		return err
+	}
+	sorter, err := output.NewSorter(opts.sortBy, opts.reverse, containerSortKeys)
+	if err != nil {
+		return err
+	}
 	filter := &pb.ContainerFilter{}
 	if opts.id != "" {
 		filter.Id = opts.id
@@ -933,59 +975,71 @@ func ListContainers(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageS
 	request := &pb.ListContainersRequest{
 		Filter: filter,
 	}
-	logrus.Debugf("ListContainerRequest: %v", request)
-	r, err := runtimeClient.ListContainers(context.Background(), request)
-	logrus.Debugf("ListContainerResponse: %v", r)
+
+	if opts.watch {
+		if opts.quiet || opts.verbose {
+			return errors.New("watch mode cannot be used with --quiet or --verbose")
+		}
+		return watchObjects(printer, func() ([]string, []watchItem, error) {
+			r, err := listContainers(runtimeClient, imageClient, request, sorter, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			wide := printer.Format() == output.FormatWide
+			var wideInfos map[string]*containerWideInfo
+			if wide {
+				wideInfos = getContainersWideInfo(runtimeClient, r.Containers)
+			}
+			items := make([]watchItem, 0, len(r.Containers))
+			for _, c := range r.Containers {
+				items = append(items, watchItem{
+					id:     c.Id,
+					state:  convertContainerState(c.State),
+					row:    containerTableRow(c, opts, wideInfos[c.Id]),
+					object: c,
+				})
+			}
+			return containerTableHeader(wide), items, nil
+		})
+	}
+
+	r, err := listContainers(runtimeClient, imageClient, request, sorter, opts)
 	if err != nil {
 		return err
 	}
-	r.Containers = getContainersList(r.GetContainers(), opts)
 
-	switch opts.output {
-	case "json":
-		return outputProtobufObjAsJSON(r)
-	case "yaml":
-		return outputProtobufObjAsYAML(r)
-	case "table":
-	// continue; output will be generated after the switch block ends.
-	default:
-		return fmt.Errorf("unsupported output format %q", opts.output)
+	if printer.Structured() {
+		items := make([]output.Item, 0, len(r.Containers))
+		for _, c := range r.Containers {
+			items = append(items, output.Item{Name: c.Id, Object: c})
+		}
+		return printer.PrintList(os.Stdout, r, items)
+	}
+
+	wide := printer.Format() == output.FormatWide && !opts.verbose && !opts.quiet
+	var wideInfos map[string]*containerWideInfo
+	if wide {
+		wideInfos = getContainersWideInfo(runtimeClient, r.Containers)
 	}
 
 	display := newTableDisplay(20, 1, 3, ' ', 0)
 	if !opts.verbose && !opts.quiet {
-		display.AddRow([]string{columnContainer, columnImage, columnCreated, columnState, columnName, columnAttempt, columnPodID})
+		display.AddRow(containerTableHeader(wide))
 	}
 	for _, c := range r.Containers {
-		if match, err := matchesImage(imageClient, opts.image, c.GetImage().GetImage()); err != nil {
-			return errors.Wrap(err, "check image match")
-		} else if !match {
-			continue
-		}
 		if opts.quiet {
 			fmt.Printf("%s\n", c.Id)
 			continue
 		}
 
-		createdAt := time.Unix(0, c.CreatedAt)
-		ctm := units.HumanDuration(time.Now().UTC().Sub(createdAt)) + " ago"
 		if !opts.verbose {
-			id := c.Id
-			image := c.Image.Image
-			if !opts.noTrunc {
-				id = getTruncatedID(id, "")
-
-				// Now c.Image.Image is imageID in kubelet.
-				if digest, err := godigest.Parse(image); err == nil {
-					image = getTruncatedID(digest.String(), string(digest.Algorithm())+":")
-				}
-			}
-			PodID := getTruncatedID(c.PodSandboxId, "")
-			display.AddRow([]string{id, image, ctm, convertContainerState(c.State), c.Metadata.Name,
-				fmt.Sprintf("%d", c.Metadata.Attempt), PodID})
+			display.AddRow(containerTableRow(c, opts, wideInfos[c.Id]))
 			continue
 		}
 
+		createdAt := time.Unix(0, c.CreatedAt)
+		ctm := units.HumanDuration(time.Now().UTC().Sub(createdAt)) + " ago"
+
 		fmt.Printf("ID: %s\n", c.Id)
 		fmt.Printf("PodID: %s\n", c.PodSandboxId)
 		if c.Metadata != nil {
@@ -1018,6 +1072,139 @@ func ListContainers(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageS
 	return nil
 }
 
+// listContainers sends a ListContainersRequest to the server and returns
+// the containers matching opts in the requested order.
+func listContainers(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageServiceClient, request *pb.ListContainersRequest, sorter *output.Sorter, opts listOptions) (*pb.ListContainersResponse, error) {
+	logrus.Debugf("ListContainerRequest: %v", request)
+	r, err := runtimeClient.ListContainers(context.Background(), request)
+	logrus.Debugf("ListContainerResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	r.Containers = getContainersList(r.GetContainers(), opts)
+	if opts.image != "" {
+		filtered := []*pb.Container{}
+		for _, c := range r.Containers {
+			if match, err := matchesImage(imageClient, opts.image, c.GetImage().GetImage()); err != nil {
+				return nil, errors.Wrap(err, "check image match")
+			} else if match {
+				filtered = append(filtered, c)
+			}
+		}
+		r.Containers = filtered
+	}
+	if err := sorter.Sort(r.Containers); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func containerTableHeader(wide bool) []string {
+	header := []string{columnContainer, columnImage, columnCreated, columnState, columnName, columnAttempt, columnPodID}
+	if wide {
+		header = append(header, columnPod, columnNamespace, columnExitCode, columnRestarts)
+	}
+	return header
+}
+
+// containerTableRow returns the table row of a container. The wide output
+// columns are added if info is not nil.
+func containerTableRow(c *pb.Container, opts listOptions, info *containerWideInfo) []string {
+	createdAt := time.Unix(0, c.CreatedAt)
+	ctm := units.HumanDuration(time.Now().UTC().Sub(createdAt)) + " ago"
+	id := c.Id
+	image := c.Image.Image
+	if !opts.noTrunc {
+		id = getTruncatedID(id, "")
+
+		// Now c.Image.Image is imageID in kubelet.
+		if digest, err := godigest.Parse(image); err == nil {
+			image = getTruncatedID(digest.String(), string(digest.Algorithm())+":")
+		}
+	}
+	PodID := getTruncatedID(c.PodSandboxId, "")
+	row := []string{id, image, ctm, convertContainerState(c.State), c.Metadata.Name,
+		fmt.Sprintf("%d", c.Metadata.Attempt), PodID}
+	if info != nil {
+		row = append(row, info.podName, info.podNamespace, info.exitCode, info.restarts)
+	}
+	return row
+}
+
+// containerWideInfo holds the details shown by `crictl ps -o wide` which
+// are not part of the listed containers.
+type containerWideInfo struct {
+	podName      string
+	podNamespace string
+	exitCode     string
+	restarts     string
+}
+
+// getContainersWideInfo collects the wide output details of containers. Pod
+// names and namespaces are taken from the Kubernetes labels and fall back to
+// the pod sandbox metadata. Exit codes are requested concurrently, at most
+// wideInfoParallelism at a time, for exited containers only.
+func getContainersWideInfo(client pb.RuntimeServiceClient, containers []*pb.Container) map[string]*containerWideInfo {
+	infos := make(map[string]*containerWideInfo, len(containers))
+	var (
+		sandboxes map[string]*pb.PodSandboxMetadata
+		wg        sync.WaitGroup
+		sem       = make(chan struct{}, wideInfoParallelism)
+	)
+	for _, c := range containers {
+		info := &containerWideInfo{
+			podName:      c.Labels[kubetypes.KubernetesPodNameLabel],
+			podNamespace: c.Labels[kubetypes.KubernetesPodNamespaceLabel],
+			exitCode:     "-",
+			restarts:     fmt.Sprintf("%d", c.GetMetadata().GetAttempt()),
+		}
+		if restarts, ok := c.Annotations[restartCountAnnotation]; ok {
+			info.restarts = restarts
+		}
+		if info.podName == "" {
+			if sandboxes == nil {
+				sandboxes = getSandboxesMetadata(client)
+			}
+			info.podName = sandboxes[c.PodSandboxId].GetName()
+			info.podNamespace = sandboxes[c.PodSandboxId].GetNamespace()
+		}
+		infos[c.Id] = info
+
+		if c.State != pb.ContainerState_CONTAINER_EXITED {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, info *containerWideInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r, err := client.ContainerStatus(context.Background(), &pb.ContainerStatusRequest{ContainerId: id})
+			if err != nil {
+				logrus.Warnf("getting the status of the container %q: %v", id, err)
+				return
+			}
+			info.exitCode = fmt.Sprintf("%d", r.GetStatus().GetExitCode())
+		}(c.Id, info)
+	}
+	wg.Wait()
+	return infos
+}
+
+// getSandboxesMetadata returns the metadata of all pod sandboxes by their ID.
+func getSandboxesMetadata(client pb.RuntimeServiceClient) map[string]*pb.PodSandboxMetadata {
+	sandboxes := make(map[string]*pb.PodSandboxMetadata)
+	r, err := client.ListPodSandbox(context.Background(), &pb.ListPodSandboxRequest{})
+	if err != nil {
+		logrus.Warnf("listing pod sandboxes: %v", err)
+		return sandboxes
+	}
+	for _, pod := range r.GetItems() {
+		sandboxes[pod.Id] = pod.Metadata
+	}
+	return sandboxes
+}
+
 func convertContainerState(state pb.ContainerState) string {
 	switch state {
 	case pb.ContainerState_CONTAINER_CREATED:
@@ -1037,8 +1224,9 @@ func convertContainerState(state pb.ContainerState) string {
 func getContainersList(containersList []*pb.Container, opts listOptions) []*pb.Container {
 	filtered := []*pb.Container{}
 	for _, c := range containersList {
-		// Filter by pod name/namespace regular expressions.
-		if matchesRegex(opts.nameRegexp, c.Metadata.Name) {
+		// Filter by pod name/namespace regular expressions and set based label selectors.
+		if matchesRegex(opts.nameRegexp, c.Metadata.Name) &&
+			matchesLabels(opts.labelSelector, c.Labels) {
 			filtered = append(filtered, c)
 		}
 	}
@@ -0,0 +1,360 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+// Columns of the df tables.
+const (
+	columnType            = "TYPE"
+	columnTotal           = "TOTAL"
+	columnActive          = "ACTIVE"
+	columnUsed            = "USED"
+	columnLogs            = "LOGS"
+	columnReclaimable     = "RECLAIMABLE"
+	columnImageFilesystem = "IMAGE FILESYSTEM"
+)
+
+var diskUsageCommand = &cli.Command{
+	Name:                   "df",
+	Usage:                  "Display the disk usage of images, containers and logs",
+	UseShortOptionHandling: true,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Show the disk usage of every image and container",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output format, One of: json|yaml|go-template|jsonpath|table",
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		printer, err := output.NewPrinter(context.String("output"), context.String("template"))
+		if err != nil {
+			return err
+		}
+		// The usage is a single object without items to list by name or
+		// in columns.
+		switch printer.Format() {
+		case output.FormatJSON, output.FormatYAML, output.FormatGoTemplate, output.FormatJSONPath, output.FormatTable:
+		default:
+			return errors.Errorf("unsupported output format %q", printer.Format())
+		}
+
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		imageClient, imageConn, err := getImageClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, imageConn)
+
+		usage, err := getDiskUsage(runtimeClient, imageClient)
+		if err != nil {
+			return errors.Wrap(err, "get disk usage")
+		}
+		verbose := context.Bool("verbose")
+		if printer.Structured() {
+			if !verbose {
+				usage.ImageDetails, usage.ContainerDetails = nil, nil
+			}
+			return printer.PrintList(os.Stdout, usage, nil)
+		}
+		displayDiskUsage(usage, verbose)
+		return nil
+	},
+}
+
+// diskUsage is the disk usage of the node as reported by the runtime.
+type diskUsage struct {
+	ImageFilesystems []*pb.FilesystemUsage `json:"imageFilesystems"`
+	Images           diskUsageSummary      `json:"images"`
+	Containers       diskUsageSummary      `json:"containers"`
+	Logs             diskUsageSummary      `json:"logs"`
+	ImageDetails     []*imageDiskUsage     `json:"imageDetails,omitempty"`
+	ContainerDetails []*containerDiskUsage `json:"containerDetails,omitempty"`
+}
+
+// diskUsageSummary sums up the disk usage of a type of item. Active items
+// are images used by containers and running containers along with their
+// logs. Unused images and exited containers along with their logs are
+// reclaimable, whereas containers which have not run yet are neither.
+type diskUsageSummary struct {
+	Total            int    `json:"total"`
+	Active           int    `json:"active"`
+	SizeBytes        uint64 `json:"sizeBytes"`
+	ReclaimableBytes uint64 `json:"reclaimableBytes"`
+}
+
+func (s *diskUsageSummary) add(size uint64, active, reclaimable bool) {
+	s.Total++
+	s.SizeBytes += size
+	if active {
+		s.Active++
+	}
+	if reclaimable {
+		s.ReclaimableBytes += size
+	}
+}
+
+// imageDiskUsage is the disk usage of an image. The sizes of layers shared
+// between images are counted for every image.
+type imageDiskUsage struct {
+	ID          string   `json:"id"`
+	RepoTags    []string `json:"repoTags"`
+	SizeBytes   uint64   `json:"sizeBytes"`
+	Containers  int      `json:"containers"`
+	Reclaimable bool     `json:"reclaimable"`
+}
+
+// containerDiskUsage is the disk usage of the writable layer and the log
+// files, including the rotated ones, of a container.
+type containerDiskUsage struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	PodSandboxID       string `json:"podSandboxId"`
+	State              string `json:"state"`
+	WritableLayerBytes uint64 `json:"writableLayerBytes"`
+	LogPath            string `json:"logPath"`
+	LogBytes           uint64 `json:"logBytes"`
+	Reclaimable        bool   `json:"reclaimable"`
+}
+
+// getDiskUsage collects the disk usage of the image filesystems, images,
+// container writable layers and container logs.
+func getDiskUsage(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageServiceClient) (*diskUsage, error) {
+	ctx := context.Background()
+	fsInfo, err := ImageFsInfo(imageClient)
+	if err != nil {
+		return nil, err
+	}
+	images, err := ListImages(imageClient, "")
+	if err != nil {
+		return nil, err
+	}
+	containers, err := runtimeClient.ListContainers(ctx, &pb.ListContainersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	stats, err := runtimeClient.ListContainerStats(ctx, &pb.ListContainerStatsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	writableLayers := make(map[string]uint64)
+	for _, s := range stats.GetStats() {
+		writableLayers[s.GetAttributes().GetId()] = s.GetWritableLayer().GetUsedBytes().GetValue()
+	}
+
+	usage := &diskUsage{ImageFilesystems: fsInfo.GetImageFilesystems()}
+	sort.Sort(containerByCreated(containers.Containers))
+	imageUsers := make(map[string]int)
+	for _, c := range containers.Containers {
+		for _, image := range images.Images {
+			if imageMatches(image, c.ImageRef) || imageMatches(image, c.GetImage().GetImage()) {
+				imageUsers[image.Id]++
+				break
+			}
+		}
+
+		running := c.State == pb.ContainerState_CONTAINER_RUNNING
+		exited := c.State == pb.ContainerState_CONTAINER_EXITED
+		d := &containerDiskUsage{
+			ID:                 c.Id,
+			Name:               c.GetMetadata().GetName(),
+			PodSandboxID:       c.PodSandboxId,
+			State:              convertContainerState(c.State),
+			WritableLayerBytes: writableLayers[c.Id],
+			Reclaimable:        exited,
+		}
+		if d.LogPath, d.LogBytes, err = containerLogSize(ctx, runtimeClient, c.Id); err != nil {
+			logrus.Warnf("Unable to get the log size of container %s: %v", c.Id, err)
+		}
+		usage.Containers.add(d.WritableLayerBytes, running, exited)
+		if d.LogPath != "" {
+			usage.Logs.add(d.LogBytes, running, exited)
+		}
+		usage.ContainerDetails = append(usage.ContainerDetails, d)
+	}
+
+	sort.Sort(imageByRef(images.Images))
+	for _, image := range images.Images {
+		d := &imageDiskUsage{
+			ID:          image.Id,
+			RepoTags:    image.RepoTags,
+			SizeBytes:   image.GetSize_(),
+			Containers:  imageUsers[image.Id],
+			Reclaimable: imageUsers[image.Id] == 0,
+		}
+		usage.Images.add(d.SizeBytes, !d.Reclaimable, d.Reclaimable)
+		usage.ImageDetails = append(usage.ImageDetails, d)
+	}
+	return usage, nil
+}
+
+// imageMatches returns whether ref, the image of a container, refers to
+// image by its ID, a tag or a digest.
+func imageMatches(image *pb.Image, ref string) bool {
+	if ref == "" {
+		return false
+	}
+	if ref == image.Id {
+		return true
+	}
+	for _, r := range append(image.RepoTags, image.RepoDigests...) {
+		if r == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// containerLogSize returns the log path of a container and the size of its
+// log file and its rotated siblings. An empty log path is returned if the
+// container has no log.
+func containerLogSize(ctx context.Context, client pb.RuntimeServiceClient, id string) (string, uint64, error) {
+	request := &pb.ContainerStatusRequest{ContainerId: id}
+	logrus.Debugf("ContainerStatusRequest: %v", request)
+	r, err := client.ContainerStatus(ctx, request)
+	logrus.Debugf("ContainerStatusResponse: %v", r)
+	if err != nil {
+		return "", 0, err
+	}
+	logPath := r.GetStatus().GetLogPath()
+	if logPath == "" {
+		return "", 0, nil
+	}
+	files, err := filepath.Glob(logPath + ".*")
+	if err != nil {
+		return logPath, 0, err
+	}
+	var size uint64
+	for _, file := range append([]string{logPath}, files...) {
+		fi, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return logPath, size, err
+		}
+		size += uint64(fi.Size())
+	}
+	return logPath, size, nil
+}
+
+func displayDiskUsage(usage *diskUsage, verbose bool) {
+	display := newTableDisplay(20, 1, 3, ' ', 0)
+	display.AddRow([]string{columnType, columnTotal, columnActive, columnSize, columnReclaimable})
+	for _, s := range []struct {
+		name    string
+		summary diskUsageSummary
+	}{
+		{"Images", usage.Images},
+		{"Containers", usage.Containers},
+		{"Logs", usage.Logs},
+	} {
+		display.AddRow([]string{
+			s.name,
+			fmt.Sprintf("%d", s.summary.Total),
+			fmt.Sprintf("%d", s.summary.Active),
+			units.HumanSize(float64(s.summary.SizeBytes)),
+			reclaimable(s.summary.ReclaimableBytes, s.summary.SizeBytes),
+		})
+	}
+	display.Flush()
+
+	fmt.Println()
+	display.AddRow([]string{columnImageFilesystem, columnUsed, columnInodes})
+	for _, fs := range usage.ImageFilesystems {
+		inodes := "-"
+		if fs.GetInodesUsed() != nil {
+			inodes = fmt.Sprintf("%d", fs.GetInodesUsed().GetValue())
+		}
+		display.AddRow([]string{fs.GetFsId().GetMountpoint(), units.HumanSize(float64(fs.GetUsedBytes().GetValue())), inodes})
+	}
+	display.Flush()
+
+	if !verbose {
+		return
+	}
+
+	fmt.Println()
+	display.AddRow([]string{columnImageID, columnImage, columnSize, columnContainers, columnReclaimable})
+	for _, image := range usage.ImageDetails {
+		name := "<none>"
+		if len(image.RepoTags) > 0 {
+			name = image.RepoTags[0]
+		}
+		display.AddRow([]string{
+			getTruncatedID(image.ID, "sha256:"),
+			name,
+			units.HumanSize(float64(image.SizeBytes)),
+			fmt.Sprintf("%d", image.Containers),
+			fmt.Sprintf("%t", image.Reclaimable),
+		})
+	}
+	display.Flush()
+
+	fmt.Println()
+	display.AddRow([]string{columnContainer, columnName, columnState, columnDisk, columnLogs, columnReclaimable})
+	for _, c := range usage.ContainerDetails {
+		display.AddRow([]string{
+			getTruncatedID(c.ID, ""),
+			c.Name,
+			c.State,
+			units.HumanSize(float64(c.WritableLayerBytes)),
+			units.HumanSize(float64(c.LogBytes)),
+			fmt.Sprintf("%t", c.Reclaimable),
+		})
+	}
+	display.Flush()
+}
+
+// reclaimable formats the reclaimable bytes along with their share of the
+// total size.
+func reclaimable(reclaimable, size uint64) string {
+	percent := 0
+	if size > 0 {
+		percent = int(reclaimable * 100 / size)
+	}
+	return fmt.Sprintf("%s (%d%%)", units.HumanSize(float64(reclaimable)), percent)
+}
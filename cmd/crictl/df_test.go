@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestGetDiskUsage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "df")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := func(name string) string { return filepath.Join(dir, name, "0.log") }
+	for file, size := range map[string]int{
+		logPath("c1"):                         100,
+		logPath("c1") + ".20210301-120000.gz": 10,
+		logPath("c2"):                         50,
+	} {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runtimeClient := &fakeRuntimeClient{
+		containers: []*pb.Container{
+			{Id: "c1", CreatedAt: 2, ImageRef: "sha256:nginx", State: pb.ContainerState_CONTAINER_RUNNING},
+			{Id: "c2", CreatedAt: 1, Image: &pb.ImageSpec{Image: "docker.io/library/busybox:latest"}, State: pb.ContainerState_CONTAINER_EXITED},
+			// Containers which have not run yet are not reclaimable.
+			{Id: "c3", CreatedAt: 3, ImageRef: "sha256:nginx", State: pb.ContainerState_CONTAINER_CREATED},
+		},
+		stats: []*pb.ContainerStats{containerStats("c1", 0, 0, 1000), containerStats("c2", 0, 0, 500), containerStats("c3", 0, 0, 200)},
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c1": {Status: &pb.ContainerStatus{LogPath: logPath("c1")}},
+			"c2": {Status: &pb.ContainerStatus{LogPath: logPath("c2")}},
+			"c3": {Status: &pb.ContainerStatus{}},
+		},
+	}
+	imageClient := &fakeImageClient{
+		images: []*pb.Image{
+			{Id: "sha256:nginx", RepoTags: []string{"docker.io/library/nginx:latest"}, Size_: 10000},
+			{Id: "sha256:busybox", RepoTags: []string{"docker.io/library/busybox:latest"}, Size_: 2000},
+			{Id: "sha256:redis", RepoTags: []string{"docker.io/library/redis:latest"}, Size_: 5000},
+		},
+		filesystems: []*pb.FilesystemUsage{{FsId: &pb.FilesystemIdentifier{Mountpoint: "/var/lib/images"}, UsedBytes: &pb.UInt64Value{Value: 15000}}},
+	}
+
+	usage, err := getDiskUsage(runtimeClient, imageClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		summary  diskUsageSummary
+		expected diskUsageSummary
+	}{
+		{"images", usage.Images, diskUsageSummary{Total: 3, Active: 2, SizeBytes: 17000, ReclaimableBytes: 5000}},
+		{"containers", usage.Containers, diskUsageSummary{Total: 3, Active: 1, SizeBytes: 1700, ReclaimableBytes: 500}},
+		{"logs", usage.Logs, diskUsageSummary{Total: 2, Active: 1, SizeBytes: 160, ReclaimableBytes: 50}},
+	} {
+		if tc.summary != tc.expected {
+			t.Errorf("expected %s summary %+v, but got %+v", tc.name, tc.expected, tc.summary)
+		}
+	}
+	if len(usage.ContainerDetails) != 3 || usage.ContainerDetails[0].ID != "c3" || usage.ContainerDetails[0].Reclaimable ||
+		usage.ContainerDetails[1].ID != "c1" || usage.ContainerDetails[1].LogBytes != 110 {
+		t.Errorf("unexpected container details %+v", usage.ContainerDetails)
+	}
+	if got := reclaimable(usage.Images.ReclaimableBytes, usage.Images.SizeBytes); got != "5kB (29%)" {
+		t.Errorf("expected %q, but got %q", "5kB (29%)", got)
+	}
+}
@@ -41,6 +41,19 @@ const (
 	columnInodes     = "INODES"
 	columnDisk       = "DISK"
 	columnCPU        = "CPU %"
+	columnPod        = "POD"
+	columnExitCode   = "EXIT CODE"
+	columnRestarts   = "RESTARTS"
+	columnIP         = "IP"
+	columnOtherIPs   = "ADDITIONAL IPS"
+	columnHostNet    = "HOST NETWORK"
+	columnContainers = "CONTAINERS"
+	columnRSS        = "RSS"
+	columnCache      = "CACHE"
+	columnPids       = "PIDS"
+	columnThrottled  = "THROTTLED"
+	columnBlockIO    = "BLOCK I/O"
+	columnPressure   = "PSI CPU/MEM/IO"
 )
 
 // display use to output something on screen with table format.
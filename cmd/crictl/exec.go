@@ -17,32 +17,46 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
+	"os"
+	"time"
 
 	dockerterm "github.com/docker/docker/pkg/term"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/net/context"
-	restclient "k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	remoteclient "k8s.io/client-go/tools/remotecommand"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 	"k8s.io/kubectl/pkg/util/term"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/common"
+	"github.com/kubernetes-sigs/cri-tools/pkg/wsstream"
 )
 
 const (
 	// TODO: make this configurable in kubelet.
 	kubeletURLSchema = "http"
 	kubeletURLHost   = "http://127.0.0.1:10250"
+
+	transportAuto      = "auto"
+	transportWebSocket = "websocket"
+	transportSPDY      = "spdy"
 )
 
 var runtimeExecCommand = &cli.Command{
 	Name:                   "exec",
 	Usage:                  "Run a command in a running container",
-	ArgsUsage:              "CONTAINER-ID COMMAND [ARG...]",
+	ArgsUsage:              "CONTAINER-ID COMMAND [ARG...] | --pod POD | --label KEY=VALUE COMMAND [ARG...]",
 	UseShortOptionHandling: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "sync",
 			Aliases: []string{"s"},
@@ -61,10 +75,37 @@ var runtimeExecCommand = &cli.Command{
 		&cli.BoolFlag{
 			Name:    "interactive",
 			Aliases: []string{"i"},
-			Usage:   "Keep STDIN open",
+			Usage:   "Keep STDIN open. Synchronous runs read STDIN up front and pass it to the command with sh -c",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "pod",
+			Usage: "Run the command synchronously in all running containers of the pod with the given ID or name",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Run the command synchronously in all running containers which match the label, e.g. app=nginx",
+		},
+		&cli.IntFlag{
+			Name:  "parallel",
+			Value: 10,
+			Usage: "Maximum number of containers to run the command in at the same time with --pod or --label",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output format of synchronous runs, One of: text|json. json prints the stdout, stderr, exit code and duration in seconds and implies --sync",
+		},
+		recordFlag(),
+		transportFlag(),
+	}, streamingTLSFlags()...),
 	Action: func(context *cli.Context) error {
+		if context.IsSet("record") && (context.Bool("sync") || context.String("output") == "json" ||
+			context.IsSet("pod") || context.IsSet("label")) {
+			return fmt.Errorf("--record cannot be combined with --sync, --output json, --pod or --label")
+		}
+		if context.IsSet("pod") || context.IsSet("label") {
+			return runExecSyncAll(context)
+		}
 		if context.Args().Len() < 2 {
 			return cli.ShowSubcommandHelp(context)
 		}
@@ -76,13 +117,42 @@ var runtimeExecCommand = &cli.Command{
 		defer closeConnection(context, conn)
 
 		var opts = execOptions{
-			id:      context.Args().First(),
-			timeout: context.Int64("timeout"),
-			tty:     context.Bool("tty"),
-			stdin:   context.Bool("interactive"),
-			cmd:     context.Args().Slice()[1:],
+			id:        context.Args().First(),
+			timeout:   context.Int64("timeout"),
+			tty:       context.Bool("tty"),
+			stdin:     context.Bool("interactive"),
+			cmd:       context.Args().Slice()[1:],
+			transport: context.String("transport"),
+			record:    context.String("record"),
+		}
+		if opts.tlsConfig, err = streamingTLSConfig(context); err != nil {
+			return err
+		}
+		outputFormat := context.String("output")
+		if outputFormat != "" && outputFormat != "text" && outputFormat != "json" {
+			return fmt.Errorf("unsupported output format %q, must be one of: text|json", outputFormat)
 		}
-		if context.Bool("sync") {
+		if context.Bool("sync") || outputFormat == "json" {
+			if opts.stdin {
+				if opts.cmd, err = stdinCommand(os.Stdin, opts.cmd); err != nil {
+					return err
+				}
+			}
+			if outputFormat == "json" {
+				result, err := execSync(context.Context, runtimeClient, opts)
+				if err != nil {
+					return errors.Wrap(err, "execing command in container synchronously")
+				}
+				data, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					return errors.Wrap(err, "marshal result")
+				}
+				fmt.Println(string(data))
+				if result.ExitCode != 0 {
+					return cli.NewExitError("non-zero exit code", int(result.ExitCode))
+				}
+				return nil
+			}
 			exitCode, err := ExecSync(runtimeClient, opts)
 			if err != nil {
 				return errors.Wrap(err, "execing command in container synchronously")
@@ -100,28 +170,74 @@ var runtimeExecCommand = &cli.Command{
 	},
 }
 
-// ExecSync sends an ExecSyncRequest to the server, and parses
-// the returned ExecSyncResponse. The function returns the corresponding exit
-// code beside an general error.
+// ExecSync sends an ExecSyncRequest to the server, and writes the returned
+// stdout and stderr unmodified to the stdout and stderr of crictl. The
+// function returns the corresponding exit code beside an general error.
 func ExecSync(client pb.RuntimeServiceClient, opts execOptions) (int, error) {
+	r, err := execSync(context.Background(), client, opts)
+	if err != nil {
+		return 1, err
+	}
+	if _, err := os.Stdout.Write(r.stdout); err != nil {
+		return 1, err
+	}
+	if _, err := os.Stderr.Write(r.stderr); err != nil {
+		return 1, err
+	}
+	return int(r.ExitCode), nil
+}
+
+// execSync runs the command of opts synchronously and returns its result.
+func execSync(ctx context.Context, client pb.RuntimeServiceClient, opts execOptions) (*execResult, error) {
 	request := &pb.ExecSyncRequest{
 		ContainerId: opts.id,
 		Cmd:         opts.cmd,
 		Timeout:     opts.timeout,
 	}
 	logrus.Debugf("ExecSyncRequest: %v", request)
-	r, err := client.ExecSync(context.Background(), request)
+	start := time.Now()
+	r, err := client.ExecSync(ctx, request)
 	logrus.Debugf("ExecSyncResponse: %v", r)
 	if err != nil {
-		return 1, err
+		return nil, err
 	}
-	fmt.Println(string(r.Stdout))
-	fmt.Println(string(r.Stderr))
-	return int(r.ExitCode), nil
+	result := &execResult{
+		ExitCode: r.ExitCode,
+		Duration: time.Since(start).Seconds(),
+	}
+	result.setOutput(r.Stdout, r.Stderr)
+	return result, nil
+}
+
+// stdinCommand reads the input of a synchronous command from in, as
+// ExecSync has no stdin, and returns a command which passes it to cmd as a
+// here-document of sh -c. The container needs a shell for this.
+func stdinCommand(in io.Reader, cmd []string) ([]string, error) {
+	input, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, errors.Wrap(err, "read stdin")
+	}
+	if bytes.IndexByte(input, 0) >= 0 {
+		return nil, fmt.Errorf("stdin of a synchronous command cannot contain NUL bytes")
+	}
+	if len(input) > 0 && input[len(input)-1] != '\n' {
+		input = append(input, '\n')
+	}
+	// The delimiter must not appear as a line of the input.
+	delimiter := "CRICTL_EOF"
+	for bytes.Contains(append([]byte{'\n'}, input...), []byte("\n"+delimiter+"\n")) {
+		delimiter += "_"
+	}
+	// The quoted delimiter passes the input without any expansion.
+	script := fmt.Sprintf("\"$@\" <<'%s'\n%s%s\n", delimiter, input, delimiter)
+	return append([]string{"sh", "-c", script, "sh"}, cmd...), nil
 }
 
 // Exec sends an ExecRequest to server, and parses the returned ExecResponse
 func Exec(client pb.RuntimeServiceClient, opts execOptions) error {
+	if opts.tty && !opts.stdin {
+		return fmt.Errorf("tty=true must be specified with interactive=true")
+	}
 	request := &pb.ExecRequest{
 		ContainerId: opts.id,
 		Cmd:         opts.cmd,
@@ -131,17 +247,37 @@ func Exec(client pb.RuntimeServiceClient, opts execOptions) error {
 		Stderr:      !opts.tty,
 	}
 
-	logrus.Debugf("ExecRequest: %v", request)
-	r, err := client.Exec(context.Background(), request)
-	logrus.Debugf("ExecResponse: %v", r)
-	if err != nil {
-		return err
+	newURL := func() (*url.URL, error) {
+		logrus.Debugf("ExecRequest: %v", request)
+		r, err := client.Exec(context.Background(), request)
+		logrus.Debugf("ExecResponse: %v", r)
+		if err != nil {
+			return nil, err
+		}
+		URL, err := parseStreamURL(r.Url)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("Exec URL: %v", URL)
+		return URL, nil
+	}
+	var recorder *sessionRecorder
+	if opts.record != "" {
+		var err error
+		if recorder, err = createSessionRecorder(opts.record, opts.id, opts.cmd); err != nil {
+			return err
+		}
+		defer recorder.close()
 	}
-	execURL := r.Url
+	return stream(opts.transport, opts.tlsConfig, opts.stdin, opts.tty, nil, recorder, newURL)
+}
 
-	URL, err := url.Parse(execURL)
+// parseStreamURL parses the URL of a streaming request, which defaults to
+// the kubelet.
+func parseStreamURL(streamURL string) (*url.URL, error) {
+	URL, err := url.Parse(streamURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if URL.Host == "" {
@@ -151,13 +287,22 @@ func Exec(client pb.RuntimeServiceClient, opts execOptions) error {
 	if URL.Scheme == "" {
 		URL.Scheme = kubeletURLSchema
 	}
-
-	logrus.Debugf("Exec URL: %v", URL)
-	return stream(opts.stdin, opts.tty, URL)
+	return URL, nil
 }
 
-func stream(in, tty bool, url *url.URL) error {
-	executor, err := remoteclient.NewSPDYExecutor(&restclient.Config{TLSClientConfig: restclient.TLSClientConfig{Insecure: true}}, "POST", url)
+// stream streams the standard streams of an exec or attach request. newURL
+// sends the request and returns its URL. The auto transport tries the v5
+// WebSocket protocol first and falls back to SPDY if the streaming server
+// does not upgrade the connection, which needs a new request as the URL can
+// only be used once. If detachKeys are set, typing them leaves the stream
+// without closing the stdin of the container. The session is recorded if
+// recorder is set.
+func stream(transport string, tlsConfig *tls.Config, in, tty bool, detachKeys []byte, recorder *sessionRecorder, newURL func() (*url.URL, error)) error {
+	URL, err := newURL()
+	if err != nil {
+		return err
+	}
+	executor, err := newExecutor(transport, URL, tlsConfig)
 	if err != nil {
 		return err
 	}
@@ -168,24 +313,154 @@ func stream(in, tty bool, url *url.URL) error {
 		Stderr: stderr,
 		Tty:    tty,
 	}
+	var detached chan struct{}
 	if in {
 		streamOptions.Stdin = stdin
-	}
-	logrus.Debugf("StreamOptions: %v", streamOptions)
-	if !tty {
-		return executor.Stream(streamOptions)
-	}
-	if !in {
-		return fmt.Errorf("tty=true must be specified with interactive=true")
+		if len(detachKeys) > 0 {
+			detached = make(chan struct{})
+			streamOptions.Stdin = &detachReader{r: dockerterm.NewEscapeProxy(stdin, detachKeys), detached: detached}
+		}
 	}
 	t := term.TTY{
 		In:  stdin,
 		Out: stdout,
 		Raw: true,
 	}
+	if recorder != nil {
+		var size *remoteclient.TerminalSize
+		if tty {
+			size = t.GetSize()
+		}
+		if err := recorder.begin(size); err != nil {
+			return errors.Wrap(err, "record session")
+		}
+		streamOptions.Stdout = recorder.output(stdout)
+		streamOptions.Stderr = recorder.output(stderr)
+	}
+	logrus.Debugf("StreamOptions: %v", streamOptions)
+	run := func() error {
+		err := executor.Stream(streamOptions)
+		if transport != transportAuto || !wsstream.IsUpgradeFailure(err) {
+			return err
+		}
+		logrus.Debugf("Falling back to SPDY: %v", err)
+		if URL, err = newURL(); err != nil {
+			return err
+		}
+		if executor, err = newExecutor(transportSPDY, URL, tlsConfig); err != nil {
+			return err
+		}
+		return executor.Stream(streamOptions)
+	}
+	if detached != nil {
+		streamRun := run
+		run = func() error {
+			errCh := make(chan error, 1)
+			go func() { errCh <- streamRun() }()
+			select {
+			case err := <-errCh:
+				return err
+			case <-detached:
+				logrus.Debugf("Detached from the stream")
+				return nil
+			}
+		}
+	}
+	if !tty || !in {
+		return run()
+	}
 	if !t.IsTerminalIn() {
 		return fmt.Errorf("input is not a terminal")
 	}
-	streamOptions.TerminalSizeQueue = t.MonitorSize(t.GetSize())
-	return t.Safe(func() error { return executor.Stream(streamOptions) })
+	sizeQueue := t.MonitorSize(t.GetSize())
+	if recorder != nil {
+		sizeQueue = recorder.sizeQueue(sizeQueue)
+	}
+	streamOptions.TerminalSizeQueue = sizeQueue
+	return t.Safe(run)
+}
+
+// detachReader reads stdin through an escape proxy. Once the detach keys are
+// read, it closes detached and blocks, so that the stream is left without
+// closing the stdin of the container.
+type detachReader struct {
+	r        io.Reader
+	detached chan struct{}
+}
+
+func (d *detachReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if _, ok := err.(dockerterm.EscapeError); ok {
+		if n > 0 {
+			// The escape proxy fails the next read again.
+			return n, nil
+		}
+		close(d.detached)
+		select {}
+	}
+	return n, err
+}
+
+// newExecutor returns the executor of the URL for the transport.
+func newExecutor(transport string, URL *url.URL, tlsConfig *tls.Config) (remoteclient.Executor, error) {
+	switch transport {
+	case transportSPDY:
+		upgrader := spdy.NewRoundTripper(tlsConfig, true, false)
+		return remoteclient.NewSPDYExecutorForTransports(upgrader, upgrader, "POST", URL)
+	case transportWebSocket:
+		return wsstream.NewExecutor(tlsConfig, URL), nil
+	case transportAuto:
+		// Unlike v4, v5 signals the end of stdin like SPDY does.
+		return wsstream.NewExecutor(tlsConfig, URL, wsstream.V5ChannelProtocol), nil
+	default:
+		return nil, fmt.Errorf("unsupported transport %q, must be one of: auto|websocket|spdy", transport)
+	}
+}
+
+// transportFlag returns the --transport flag of the streaming commands.
+func transportFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "transport",
+		Value: transportAuto,
+		Usage: "Streaming transport, One of: auto|websocket|spdy. auto tries websocket first for exec and attach and spdy first for port-forward, and falls back to the other one",
+	}
+}
+
+// streamingTLSFlags returns the flags of the TLS connections to the streaming
+// server.
+func streamingTLSFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "streaming-ca",
+			Usage: "CA bundle verifying the streaming server of the runtime, defaults to the system roots",
+		},
+		&cli.StringFlag{
+			Name:  "streaming-cert",
+			Usage: "Client certificate for the streaming server",
+		},
+		&cli.StringFlag{
+			Name:  "streaming-key",
+			Usage: "Key of the client certificate for the streaming server",
+		},
+		&cli.StringSliceFlag{
+			Name:  "streaming-server-name",
+			Usage: "Name the certificate of the streaming server has to be valid for, instead of the host of its URL. Can be repeated to allow several names",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-skip-tls-verify",
+			Usage: "Do not verify the certificate of the streaming server, which makes the streams vulnerable to interception",
+		},
+	}
+}
+
+// streamingTLSConfig returns the TLS config of the streaming TLS flags.
+func streamingTLSConfig(context *cli.Context) (*tls.Config, error) {
+	opts := common.StreamingTLSOptions{
+		CAFile:             context.String("streaming-ca"),
+		CertFile:           context.String("streaming-cert"),
+		KeyFile:            context.String("streaming-key"),
+		ServerNames:        context.StringSlice("streaming-server-name"),
+		InsecureSkipVerify: context.Bool("insecure-skip-tls-verify"),
+	}
+	return opts.TLSConfig()
 }
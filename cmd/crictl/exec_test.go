@@ -0,0 +1,121 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestExecSyncResult(t *testing.T) {
+	client := &fakeRuntimeClient{
+		execs: map[string]*pb.ExecSyncResponse{
+			"c1": {Stdout: []byte("\x00\x01binary"), Stderr: []byte("no newline"), ExitCode: 3},
+		},
+	}
+	result, err := execSync(context.Background(), client, execOptions{id: "c1", cmd: []string{"cat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "\x00\x01binary" || result.Stderr != "no newline" || result.ExitCode != 3 || result.Duration <= 0 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), `{"stdout":"\u0000\u0001binary","stderr":"no newline","exitCode":3,"duration":`) {
+		t.Errorf("unexpected JSON %s", data)
+	}
+}
+
+func TestExecSyncResultInvalidUTF8(t *testing.T) {
+	stdout := []byte{0x1f, 0x8b, 0x08, 0xff, 0xfe}
+	client := &fakeRuntimeClient{
+		execs: map[string]*pb.ExecSyncResponse{
+			"c1": {Stdout: stdout, Stderr: []byte("text")},
+		},
+	}
+	result, err := execSync(context.Background(), client, execOptions{id: "c1", cmd: []string{"gzip"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.stdout, stdout) {
+		t.Errorf("expected the raw output %q, but got %q", stdout, result.stdout)
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded execResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Encoding != "base64" {
+		t.Fatalf("expected base64 encoded output, but got %s", data)
+	}
+	decodedStdout, err := base64.StdEncoding.DecodeString(decoded.Stdout)
+	if err != nil || !bytes.Equal(decodedStdout, stdout) {
+		t.Errorf("expected stdout %q, but got %q (%v)", stdout, decodedStdout, err)
+	}
+	decodedStderr, err := base64.StdEncoding.DecodeString(decoded.Stderr)
+	if err != nil || string(decodedStderr) != "text" {
+		t.Errorf("expected stderr %q, but got %q (%v)", "text", decodedStderr, err)
+	}
+}
+
+func TestStdinCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh is not available")
+	}
+	for _, input := range []string{
+		"",
+		"single line\n",
+		"$HOME `date` 'quoted' \"double\"\n\\\nCRICTL_EOF\nno trailing newline",
+	} {
+		cmd, err := stdinCommand(strings.NewReader(input), []string{"cat", "-"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd[0] != "sh" || cmd[1] != "-c" || cmd[len(cmd)-2] != "cat" {
+			t.Errorf("unexpected command %q", cmd)
+		}
+		out, err := exec.Command(cmd[0], cmd[1:]...).Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := input
+		if input != "" && !strings.HasSuffix(input, "\n") {
+			expected += "\n"
+		}
+		if string(out) != expected {
+			t.Errorf("expected %q, but got %q", expected, out)
+		}
+	}
+
+	if _, err := stdinCommand(strings.NewReader("a\x00b"), []string{"cat"}); err == nil {
+		t.Error("expected an error for NUL bytes")
+	}
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"k8s.io/apimachinery/pkg/labels"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// execTarget is a container the command of exec --pod or --label runs in.
+type execTarget struct {
+	id      string
+	podName string
+	name    string
+}
+
+// execResult is the result of running a command synchronously.
+type execResult struct {
+	// ID, Pod and Container are set for exec --pod and --label.
+	ID        string `json:"id,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	// Encoding is base64 if Stdout and Stderr are base64 encoded, because
+	// the output is not valid UTF-8.
+	Encoding string `json:"encoding,omitempty"`
+	ExitCode int32  `json:"exitCode"`
+	// Duration of the command in seconds.
+	Duration float64 `json:"duration"`
+	// Error is set if the command could not be run.
+	Error string `json:"error,omitempty"`
+
+	// stdout and stderr are the output as written by the command.
+	stdout []byte
+	stderr []byte
+}
+
+// setOutput sets the output of the command. JSON strings cannot hold output
+// which is not valid UTF-8, so such output is base64 encoded.
+func (r *execResult) setOutput(stdout, stderr []byte) {
+	r.stdout, r.stderr = stdout, stderr
+	if utf8.Valid(stdout) && utf8.Valid(stderr) {
+		r.Stdout, r.Stderr = string(stdout), string(stderr)
+		return
+	}
+	r.Stdout = base64.StdEncoding.EncodeToString(stdout)
+	r.Stderr = base64.StdEncoding.EncodeToString(stderr)
+	r.Encoding = "base64"
+}
+
+func (r *execResult) failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// runExecSyncAll runs the command of exec --pod or --label synchronously in
+// all matching containers and prints the results.
+func runExecSyncAll(ctx *cli.Context) error {
+	if ctx.Args().Len() < 1 {
+		return cli.ShowSubcommandHelp(ctx)
+	}
+	if ctx.Bool("tty") {
+		return fmt.Errorf("--tty cannot be combined with --pod or --label")
+	}
+	outputFormat := ctx.String("output")
+	if outputFormat != "" && outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unsupported output format %q, must be one of: text|json", outputFormat)
+	}
+	parallel := ctx.Int("parallel")
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	labelMap, selector, err := parseLabelStringSlice(ctx.StringSlice("label"))
+	if err != nil {
+		return err
+	}
+	cmd := ctx.Args().Slice()
+	if ctx.Bool("interactive") {
+		if cmd, err = stdinCommand(os.Stdin, cmd); err != nil {
+			return err
+		}
+	}
+
+	runtimeClient, conn, err := getRuntimeClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn)
+
+	execCtx, cancelFn := interruptibleContext(ctx.Context)
+	defer cancelFn()
+	targets, err := selectExecTargets(execCtx, runtimeClient, ctx.String("pod"), labelMap, selector)
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no running containers match")
+	}
+
+	var onResult func(*execResult)
+	if outputFormat != "json" {
+		onResult = func(r *execResult) { writeExecResult(os.Stdout, os.Stderr, r) }
+	}
+	results := execSyncAll(execCtx, runtimeClient, targets, cmd, ctx.Int64("timeout"), parallel, onResult)
+	if outputFormat == "json" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshal results")
+		}
+		fmt.Println(string(data))
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.failed() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("command failed in %d of %d containers", failed, len(results)), 1)
+	}
+	return nil
+}
+
+// selectExecTargets returns the running containers of the pod with the given
+// ID, ID prefix or name, which match the labels, ordered by pod and container
+// name.
+func selectExecTargets(ctx context.Context, client pb.RuntimeServiceClient, pod string, labelMap map[string]string, selector labels.Selector) ([]*execTarget, error) {
+	pods, err := selectPodSandboxes(ctx, client, pod)
+	if err != nil {
+		return nil, err
+	}
+	request := &pb.ListContainersRequest{
+		Filter: &pb.ContainerFilter{
+			State:         &pb.ContainerStateValue{State: pb.ContainerState_CONTAINER_RUNNING},
+			LabelSelector: labelMap,
+		},
+	}
+	logrus.Debugf("ListContainerRequest: %v", request)
+	r, err := client.ListContainers(ctx, request)
+	logrus.Debugf("ListContainerResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	var targets []*execTarget
+	for _, c := range r.GetContainers() {
+		if pods[c.PodSandboxId] == nil || c.State != pb.ContainerState_CONTAINER_RUNNING ||
+			!matchesLabels(selector, c.Labels) {
+			continue
+		}
+		targets = append(targets, &execTarget{
+			id:      c.Id,
+			podName: pods[c.PodSandboxId].GetMetadata().GetName(),
+			name:    c.GetMetadata().GetName(),
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].podName != targets[j].podName {
+			return targets[i].podName < targets[j].podName
+		}
+		return targets[i].name < targets[j].name
+	})
+	return targets, nil
+}
+
+// execSyncAll runs the command in the targets, at most parallel at a time.
+// onResult, if set, is called with every result once it is available, one
+// at a time. The results are returned in the order of the targets.
+func execSyncAll(ctx context.Context, client pb.RuntimeServiceClient, targets []*execTarget, cmd []string, timeout int64, parallel int, onResult func(*execResult)) []*execResult {
+	results := make([]*execResult, len(targets))
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, parallel)
+	)
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *execTarget) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, err := execSync(ctx, client, execOptions{id: t.id, cmd: cmd, timeout: timeout})
+			if err != nil {
+				result = &execResult{Error: err.Error()}
+			}
+			result.ID, result.Pod, result.Container = t.id, t.podName, t.name
+			results[i] = result
+			if onResult != nil {
+				mu.Lock()
+				onResult(result)
+				mu.Unlock()
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// writeExecResult writes the output of the command in a container, every line
+// prefixed with the pod and container name, followed by the exit code or
+// error if the command failed.
+func writeExecResult(stdout, stderr io.Writer, r *execResult) {
+	prefix := fmt.Sprintf("[%s/%s] ", r.Pod, r.Container)
+	writePrefixedLines(stdout, prefix, r.stdout)
+	writePrefixedLines(stderr, prefix, r.stderr)
+	switch {
+	case r.Error != "":
+		fmt.Fprintf(stderr, "%serror: %s\n", prefix, r.Error)
+	case r.ExitCode != 0:
+		fmt.Fprintf(stderr, "%sexit code %d\n", prefix, r.ExitCode)
+	}
+}
+
+// writePrefixedLines writes the lines of out, each prefixed with prefix.
+func writePrefixedLines(w io.Writer, prefix string, out []byte) {
+	if len(out) == 0 {
+		return
+	}
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(out, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		buf.WriteString(prefix)
+		buf.Write(line)
+		if line[len(line)-1] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	w.Write(buf.Bytes())
+}
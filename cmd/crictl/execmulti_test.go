@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestExecSyncAll(t *testing.T) {
+	running := pb.ContainerState_CONTAINER_RUNNING
+	client := &fakeRuntimeClient{
+		sandboxes: []*pb.PodSandbox{
+			{Id: "p1", Metadata: &pb.PodSandboxMetadata{Name: "web"}},
+			{Id: "p2", Metadata: &pb.PodSandboxMetadata{Name: "db"}},
+		},
+		containers: []*pb.Container{
+			{Id: "c1", PodSandboxId: "p1", State: running, Metadata: &pb.ContainerMetadata{Name: "nginx"}, Labels: map[string]string{"app": "x"}},
+			{Id: "c2", PodSandboxId: "p1", State: running, Metadata: &pb.ContainerMetadata{Name: "sidecar"}, Labels: map[string]string{"app": "x"}},
+			{Id: "c3", PodSandboxId: "p2", State: running, Metadata: &pb.ContainerMetadata{Name: "postgres"}, Labels: map[string]string{"app": "x"}},
+			{Id: "c4", PodSandboxId: "p2", State: pb.ContainerState_CONTAINER_EXITED, Metadata: &pb.ContainerMetadata{Name: "init"}, Labels: map[string]string{"app": "x"}},
+		},
+		execs: map[string]*pb.ExecSyncResponse{
+			"c1": {Stdout: []byte("ok\n")},
+			"c3": {Stdout: []byte("a\nb"), Stderr: []byte("warning\n"), ExitCode: 2},
+		},
+		delay: 10 * time.Millisecond,
+	}
+
+	targets, err := selectExecTargets(context.Background(), client, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 3 || targets[0].id != "c3" || targets[1].id != "c1" || targets[2].id != "c2" {
+		t.Fatalf("expected the running containers c3, c1 and c2, but got %+v", targets)
+	}
+
+	results := execSyncAll(context.Background(), client, targets, []string{"true"}, 0, 2, nil)
+	if client.maxInFlight > 2 {
+		t.Errorf("expected at most 2 concurrent execs, but got %d", client.maxInFlight)
+	}
+	if r := results[0]; r.ID != "c3" || r.ExitCode != 2 || r.Stdout != "a\nb" || !r.failed() {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r := results[1]; r.ID != "c1" || r.ExitCode != 0 || r.Stdout != "ok\n" || r.failed() {
+		t.Errorf("unexpected result %+v", r)
+	}
+	if r := results[2]; r.ID != "c2" || r.Error == "" || !r.failed() {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	var stdout, stderr bytes.Buffer
+	writeExecResult(&stdout, &stderr, results[0])
+	if expected := "[db/postgres] a\n[db/postgres] b\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, stdout.String())
+	}
+	if expected := "[db/postgres] warning\n[db/postgres] exit code 2\n"; stderr.String() != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, stderr.String())
+	}
+}
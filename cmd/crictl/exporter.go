@@ -0,0 +1,250 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/metrics"
+)
+
+var exporterCommand = &cli.Command{
+	Name:  "exporter",
+	Usage: "Serve container, pod and runtime metrics in the Prometheus format",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "listen",
+			Value: ":9101",
+			Usage: "Address to serve the metrics on",
+		},
+		&cli.StringFlag{
+			Name:  "metrics-path",
+			Value: "/metrics",
+			Usage: "HTTP path to serve the metrics on",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 15 * time.Second,
+			Usage: "Interval in which the metrics are collected from the runtime",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Duration("interval") <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		imageClient, imageConn, err := getImageClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, imageConn)
+
+		e := &exporter{runtimeClient: runtimeClient, imageClient: imageClient}
+		if err := e.serve(context.String("listen"), context.String("metrics-path"), context.Duration("interval")); err != nil {
+			return errors.Wrap(err, "serve metrics")
+		}
+		return nil
+	},
+}
+
+// exporter periodically collects metrics from the runtime and serves the
+// latest collection over HTTP.
+type exporter struct {
+	runtimeClient pb.RuntimeServiceClient
+	imageClient   pb.ImageServiceClient
+
+	mu      sync.RWMutex
+	metrics []byte
+}
+
+// serve collects the metrics every interval and serves them on addr until
+// the user hits CtrlC.
+func (e *exporter) serve(addr, path string, interval time.Duration) error {
+	e.refresh()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			e.refresh()
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle(path, e)
+	server := &http.Server{Addr: addr, Handler: mux}
+	errCh := make(chan error, 1)
+	go func() {
+		logrus.Infof("Serving metrics on %s%s", addr, path)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-SetupInterruptSignalHandler():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(e.metrics)
+}
+
+// refresh collects the metrics and replaces the served ones.
+func (e *exporter) refresh() {
+	var buf bytes.Buffer
+	if err := metrics.Write(&buf, e.collect(context.Background())); err != nil {
+		logrus.Errorf("Writing metrics: %v", err)
+		return
+	}
+	e.mu.Lock()
+	e.metrics = buf.Bytes()
+	e.mu.Unlock()
+}
+
+// collect gathers the metrics from the runtime. Failed calls are logged and
+// reported by the cri_up metric, while the metrics of the successful ones
+// are still returned.
+func (e *exporter) collect(ctx context.Context) []*metrics.Family {
+	var (
+		up             = metrics.NewFamily("cri_up", "Whether all calls to the runtime succeeded during the last collection.", metrics.Gauge)
+		condition      = metrics.NewFamily("cri_runtime_condition", "Whether the runtime condition is met.", metrics.Gauge)
+		podState       = metrics.NewFamily("cri_pod_state", "State of the pod sandbox.", metrics.Gauge)
+		podCreated     = metrics.NewFamily("cri_pod_created_timestamp_seconds", "Creation time of the pod sandbox since the Unix epoch.", metrics.Gauge)
+		containerState = metrics.NewFamily("cri_container_state", "State of the container.", metrics.Gauge)
+		attempt        = metrics.NewFamily("cri_container_attempt", "Attempt number of the container.", metrics.Gauge)
+		cpu            = metrics.NewFamily("cri_container_cpu_usage_seconds_total", "Cumulative CPU time consumed by the container.", metrics.Counter)
+		memory         = metrics.NewFamily("cri_container_memory_working_set_bytes", "Current working set of the container.", metrics.Gauge)
+		diskBytes      = metrics.NewFamily("cri_container_writable_layer_bytes", "Bytes used by the writable layer of the container.", metrics.Gauge)
+		diskInodes     = metrics.NewFamily("cri_container_writable_layer_inodes", "Inodes used by the writable layer of the container.", metrics.Gauge)
+		imageFsBytes   = metrics.NewFamily("cri_imagefs_used_bytes", "Bytes used by images on the image filesystem.", metrics.Gauge)
+		imageFsInodes  = metrics.NewFamily("cri_imagefs_inodes_used", "Inodes used by images on the image filesystem.", metrics.Gauge)
+	)
+	families := []*metrics.Family{up, condition, podState, podCreated, containerState, attempt, cpu, memory, diskBytes, diskInodes, imageFsBytes, imageFsInodes}
+
+	failed := false
+	logError := func(call string, err error) {
+		logrus.Errorf("Collecting metrics: %s: %v", call, err)
+		failed = true
+	}
+
+	if r, err := e.runtimeClient.Status(ctx, &pb.StatusRequest{}); err != nil {
+		logError("Status", err)
+	} else {
+		for _, c := range r.GetStatus().GetConditions() {
+			condition.Add(boolValue(c.Status), "condition", c.Type)
+		}
+	}
+
+	pods := make(map[string]*pb.PodSandboxMetadata)
+	if r, err := e.runtimeClient.ListPodSandbox(ctx, &pb.ListPodSandboxRequest{}); err != nil {
+		logError("ListPodSandbox", err)
+	} else {
+		for _, pod := range r.Items {
+			pods[pod.Id] = pod.Metadata
+			labels := []string{"pod_id", pod.Id, "pod", pod.Metadata.GetName(), "namespace", pod.Metadata.GetNamespace()}
+			podState.Add(1, append(labels, "state", convertPodState(pod.State))...)
+			podCreated.Add(float64(pod.CreatedAt)/float64(time.Second), labels...)
+		}
+	}
+
+	// containerLabels are the labels of the samples of a container.
+	containerLabels := make(map[string][]string)
+	labelsOf := func(id, name, podID, image string, labels map[string]string) []string {
+		pod, namespace := pods[podID].GetName(), pods[podID].GetNamespace()
+		if pod == "" {
+			pod = labels[kubetypes.KubernetesPodNameLabel]
+			namespace = labels[kubetypes.KubernetesPodNamespaceLabel]
+		}
+		return []string{"id", id, "container", name, "pod", pod, "namespace", namespace, "image", image}
+	}
+	if r, err := e.runtimeClient.ListContainers(ctx, &pb.ListContainersRequest{}); err != nil {
+		logError("ListContainers", err)
+	} else {
+		for _, c := range r.Containers {
+			labels := labelsOf(c.Id, c.Metadata.GetName(), c.PodSandboxId, c.GetImage().GetImage(), c.Labels)
+			containerLabels[c.Id] = labels
+			containerState.Add(1, append(labels, "state", convertContainerState(c.State))...)
+			attempt.Add(float64(c.Metadata.GetAttempt()), labels...)
+		}
+	}
+
+	if r, err := e.runtimeClient.ListContainerStats(ctx, &pb.ListContainerStatsRequest{}); err != nil {
+		logError("ListContainerStats", err)
+	} else {
+		for _, s := range r.Stats {
+			attrs := s.GetAttributes()
+			labels, ok := containerLabels[attrs.GetId()]
+			if !ok {
+				labels = labelsOf(attrs.GetId(), attrs.GetMetadata().GetName(), "", "", attrs.GetLabels())
+			}
+			if s.GetCpu().GetUsageCoreNanoSeconds() != nil {
+				cpu.Add(float64(s.GetCpu().GetUsageCoreNanoSeconds().GetValue())/float64(time.Second), labels...)
+			}
+			if s.GetMemory().GetWorkingSetBytes() != nil {
+				memory.Add(float64(s.GetMemory().GetWorkingSetBytes().GetValue()), labels...)
+			}
+			if s.GetWritableLayer().GetUsedBytes() != nil {
+				diskBytes.Add(float64(s.GetWritableLayer().GetUsedBytes().GetValue()), labels...)
+			}
+			if s.GetWritableLayer().GetInodesUsed() != nil {
+				diskInodes.Add(float64(s.GetWritableLayer().GetInodesUsed().GetValue()), labels...)
+			}
+		}
+	}
+
+	if r, err := e.imageClient.ImageFsInfo(ctx, &pb.ImageFsInfoRequest{}); err != nil {
+		logError("ImageFsInfo", err)
+	} else {
+		for _, fs := range r.ImageFilesystems {
+			mountpoint := fs.GetFsId().GetMountpoint()
+			imageFsBytes.Add(float64(fs.GetUsedBytes().GetValue()), "mountpoint", mountpoint)
+			if fs.GetInodesUsed() != nil {
+				imageFsInodes.Add(float64(fs.GetInodesUsed().GetValue()), "mountpoint", mountpoint)
+			}
+		}
+	}
+
+	up.Add(boolValue(!failed))
+	return families
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/metrics"
+)
+
+// fakeImageClient serves the listed images and image filesystems, or fails
+// with err. Other calls panic.
+type fakeImageClient struct {
+	pb.ImageServiceClient
+	images      []*pb.Image
+	filesystems []*pb.FilesystemUsage
+	err         error
+}
+
+func (f *fakeImageClient) ListImages(ctx context.Context, in *pb.ListImagesRequest, opts ...grpc.CallOption) (*pb.ListImagesResponse, error) {
+	return &pb.ListImagesResponse{Images: f.images}, f.err
+}
+
+func (f *fakeImageClient) ImageFsInfo(ctx context.Context, in *pb.ImageFsInfoRequest, opts ...grpc.CallOption) (*pb.ImageFsInfoResponse, error) {
+	return &pb.ImageFsInfoResponse{ImageFilesystems: f.filesystems}, f.err
+}
+
+func TestExporter(t *testing.T) {
+	runtimeClient := &fakeRuntimeClient{
+		containers: []*pb.Container{{
+			Id:           "c1",
+			PodSandboxId: "p1",
+			Metadata:     &pb.ContainerMetadata{Name: "nginx", Attempt: 2},
+			Image:        &pb.ImageSpec{Image: "docker.io/library/nginx:latest"},
+			State:        pb.ContainerState_CONTAINER_RUNNING,
+		}},
+		sandboxes: []*pb.PodSandbox{{
+			Id:        "p1",
+			Metadata:  &pb.PodSandboxMetadata{Name: "web", Namespace: "default"},
+			State:     pb.PodSandboxState_SANDBOX_READY,
+			CreatedAt: 1500000000000000000,
+		}},
+		stats: []*pb.ContainerStats{containerStats("c1", 2500000000, 1024, 4096)},
+		conditions: []*pb.RuntimeCondition{
+			{Type: "RuntimeReady", Status: true},
+			{Type: "NetworkReady", Status: false},
+		},
+	}
+	imageClient := &fakeImageClient{err: fmt.Errorf("unavailable")}
+
+	e := &exporter{runtimeClient: runtimeClient, imageClient: imageClient}
+	e.refresh()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metrics.ContentType {
+		t.Errorf("expected content type %q, but got %q", metrics.ContentType, ct)
+	}
+	body, _ := ioutil.ReadAll(rec.Body)
+	got := string(body)
+
+	labels := `id="c1",container="nginx",pod="web",namespace="default",image="docker.io/library/nginx:latest"`
+	for _, expected := range []string{
+		"cri_up 0\n",
+		`cri_runtime_condition{condition="RuntimeReady"} 1` + "\n",
+		`cri_runtime_condition{condition="NetworkReady"} 0` + "\n",
+		`cri_pod_state{pod_id="p1",pod="web",namespace="default",state="Ready"} 1` + "\n",
+		`cri_pod_created_timestamp_seconds{pod_id="p1",pod="web",namespace="default"} 1.5e+09` + "\n",
+		`cri_container_state{` + labels + `,state="Running"} 1` + "\n",
+		`cri_container_attempt{` + labels + `} 2` + "\n",
+		"# TYPE cri_container_cpu_usage_seconds_total counter\n",
+		`cri_container_cpu_usage_seconds_total{` + labels + `} 2.5` + "\n",
+		`cri_container_memory_working_set_bytes{` + labels + `} 1024` + "\n",
+		`cri_container_writable_layer_bytes{` + labels + `} 4096` + "\n",
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected metrics to contain %q, but got:\n%s", expected, got)
+		}
+	}
+	if strings.Contains(got, "cri_imagefs") {
+		t.Errorf("expected no image filesystem metrics, but got:\n%s", got)
+	}
+}
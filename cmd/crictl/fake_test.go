@@ -0,0 +1,114 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// fakeRuntimeClient serves the listed containers, pod sandboxes and their
+// statuses, container stats and statuses, runtime conditions, exec results
+// and port-forward URLs, and records reopened container logs and the highest number of
+// concurrent execs and status calls. These take delay, so tests can observe
+// their concurrency. Other calls panic.
+type fakeRuntimeClient struct {
+	pb.RuntimeServiceClient
+	containers []*pb.Container
+	sandboxes  []*pb.PodSandbox
+	podStatus  map[string]*pb.PodSandboxStatusResponse
+	stats      []*pb.ContainerStats
+	statuses   map[string]*pb.ContainerStatusResponse
+	conditions []*pb.RuntimeCondition
+	reopened   []string
+	reopenErr  error
+	execs      map[string]*pb.ExecSyncResponse
+	forwardURL string
+	delay      time.Duration
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+// call records a concurrent call which takes delay.
+func (f *fakeRuntimeClient) call() {
+	f.mu.Lock()
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+	time.Sleep(f.delay)
+	f.mu.Lock()
+	f.inFlight--
+	f.mu.Unlock()
+}
+
+func (f *fakeRuntimeClient) ListContainers(ctx context.Context, in *pb.ListContainersRequest, opts ...grpc.CallOption) (*pb.ListContainersResponse, error) {
+	return &pb.ListContainersResponse{Containers: f.containers}, nil
+}
+
+func (f *fakeRuntimeClient) ListPodSandbox(ctx context.Context, in *pb.ListPodSandboxRequest, opts ...grpc.CallOption) (*pb.ListPodSandboxResponse, error) {
+	return &pb.ListPodSandboxResponse{Items: f.sandboxes}, nil
+}
+
+func (f *fakeRuntimeClient) PodSandboxStatus(ctx context.Context, in *pb.PodSandboxStatusRequest, opts ...grpc.CallOption) (*pb.PodSandboxStatusResponse, error) {
+	f.call()
+	if r, ok := f.podStatus[in.PodSandboxId]; ok {
+		return r, nil
+	}
+	return nil, fmt.Errorf("pod %q not found", in.PodSandboxId)
+}
+
+func (f *fakeRuntimeClient) ListContainerStats(ctx context.Context, in *pb.ListContainerStatsRequest, opts ...grpc.CallOption) (*pb.ListContainerStatsResponse, error) {
+	return &pb.ListContainerStatsResponse{Stats: f.stats}, nil
+}
+
+func (f *fakeRuntimeClient) ContainerStatus(ctx context.Context, in *pb.ContainerStatusRequest, opts ...grpc.CallOption) (*pb.ContainerStatusResponse, error) {
+	f.call()
+	if r, ok := f.statuses[in.ContainerId]; ok {
+		return r, nil
+	}
+	return nil, fmt.Errorf("container %q not found", in.ContainerId)
+}
+
+func (f *fakeRuntimeClient) Status(ctx context.Context, in *pb.StatusRequest, opts ...grpc.CallOption) (*pb.StatusResponse, error) {
+	return &pb.StatusResponse{Status: &pb.RuntimeStatus{Conditions: f.conditions}}, nil
+}
+
+func (f *fakeRuntimeClient) ReopenContainerLog(ctx context.Context, in *pb.ReopenContainerLogRequest, opts ...grpc.CallOption) (*pb.ReopenContainerLogResponse, error) {
+	f.reopened = append(f.reopened, in.ContainerId)
+	return &pb.ReopenContainerLogResponse{}, f.reopenErr
+}
+
+func (f *fakeRuntimeClient) ExecSync(ctx context.Context, in *pb.ExecSyncRequest, opts ...grpc.CallOption) (*pb.ExecSyncResponse, error) {
+	f.call()
+	if r, ok := f.execs[in.ContainerId]; ok {
+		return r, nil
+	}
+	return nil, fmt.Errorf("container %q not found", in.ContainerId)
+}
+
+func (f *fakeRuntimeClient) PortForward(ctx context.Context, in *pb.PortForwardRequest, opts ...grpc.CallOption) (*pb.PortForwardResponse, error) {
+	return &pb.PortForwardResponse{Url: f.forwardURL}, nil
+}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"strings"
 
@@ -27,6 +28,8 @@ import (
 	"github.com/urfave/cli/v2"
 	"golang.org/x/net/context"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 type imageByRef []*pb.Image
@@ -43,6 +46,12 @@ func (a imageByRef) Less(i, j int) bool {
 	return a[i].Id < a[j].Id
 }
 
+// imageSortKeys are the well-known --sort-by keys of images.
+var imageSortKeys = output.SortKeys{
+	"name": ".repoTags[0]",
+	"size": ".size",
+}
+
 var pullImageCommand = &cli.Command{
 	Name:                   "pull",
 	Usage:                  "Pull an image from a registry",
@@ -105,7 +114,7 @@ var listImageCommand = &cli.Command{
 	Usage:                  "List images",
 	ArgsUsage:              "[REPOSITORY[:TAG]]",
 	UseShortOptionHandling: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "verbose",
 			Aliases: []string{"v"},
@@ -119,7 +128,12 @@ var listImageCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|table",
+			Usage:   output.Usage,
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 		&cli.BoolFlag{
 			Name:  "digests",
@@ -129,8 +143,17 @@ var listImageCommand = &cli.Command{
 			Name:  "no-trunc",
 			Usage: "Show output without truncating the ID",
 		},
-	},
+	}, sortFlags(imageSortKeys)...),
 	Action: func(context *cli.Context) error {
+		printer, err := output.NewPrinter(context.String("output"), context.String("template"))
+		if err != nil {
+			return err
+		}
+		sorter, err := output.NewSorter(context.String("sort-by"), context.Bool("reverse"), imageSortKeys)
+		if err != nil {
+			return err
+		}
+
 		imageClient, conn, err := getImageClient(context)
 		if err != nil {
 			return err
@@ -142,12 +165,16 @@ var listImageCommand = &cli.Command{
 			return errors.Wrap(err, "listing images")
 		}
 		sort.Sort(imageByRef(r.Images))
+		if err := sorter.Sort(r.Images); err != nil {
+			return err
+		}
 
-		switch context.String("output") {
-		case "json":
-			return outputProtobufObjAsJSON(r)
-		case "yaml":
-			return outputProtobufObjAsYAML(r)
+		if printer.Structured() {
+			items := make([]output.Item, 0, len(r.Images))
+			for _, image := range r.Images {
+				items = append(items, output.Item{Name: image.Id, Object: image})
+			}
+			return printer.PrintList(os.Stdout, r, items)
 		}
 
 		// output in table format by default.
@@ -218,7 +245,8 @@ var imageStatusCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|go-template|table",
+			Usage:   output.Usage,
+			Value:   output.FormatJSON,
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -227,7 +255,7 @@ var imageStatusCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "template",
-			Usage: "The template string is only used when output is go-template; The Template format is golang template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -241,11 +269,11 @@ var imageStatusCommand = &cli.Command{
 		defer closeConnection(context, conn)
 
 		verbose := !(context.Bool("quiet"))
-		output := context.String("output")
-		if output == "" { // default to json output
-			output = "json"
+		printer, err := output.NewPrinter(context.String("output"), context.String("template"))
+		if err != nil {
+			return err
 		}
-		tmplStr := context.String("template")
+		var items []output.Item
 		for i := 0; i < context.NArg(); i++ {
 			id := context.Args().Get(i)
 
@@ -258,19 +286,17 @@ var imageStatusCommand = &cli.Command{
 				return fmt.Errorf("no such image %q present", id)
 			}
 
-			status, err := protobufObjectToJSON(r.Image)
-			if err != nil {
-				return errors.Wrapf(err, "marshal status to json for %q", id)
-			}
-			switch output {
-			case "json", "yaml", "go-template":
-				if err := outputStatusInfo(status, r.Info, output, tmplStr); err != nil {
-					return errors.Wrapf(err, "output status for %q", id)
+			if printer.Structured() {
+				status, err := protobufObjectToJSON(r.Image)
+				if err != nil {
+					return errors.Wrapf(err, "marshal status to json for %q", id)
 				}
+				info, err := marshalStatusInfo(status, r.Info)
+				if err != nil {
+					return errors.Wrapf(err, "marshal status info for %q", id)
+				}
+				items = append(items, output.Item{Name: image.Id, Object: info})
 				continue
-			case "table": // table output is after this switch block
-			default:
-				return fmt.Errorf("output option cannot be %s", output)
 			}
 
 			// otherwise output in table format
@@ -288,7 +314,10 @@ var imageStatusCommand = &cli.Command{
 			}
 		}
 
-		return nil
+		if !printer.Structured() {
+			return nil
+		}
+		return printer.PrintItems(os.Stdout, items)
 	},
 }
 
@@ -422,11 +451,12 @@ var imageFsInfoCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|go-template|table",
+			Usage:   output.Usage,
+			Value:   output.FormatJSON,
 		},
 		&cli.StringFlag{
 			Name:  "template",
-			Usage: "The template string is only used when output is go-template; The Template format is golang template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -436,34 +466,33 @@ var imageFsInfoCommand = &cli.Command{
 		}
 		defer closeConnection(context, conn)
 
-		output := context.String("output")
-		if output == "" { // default to json output
-			output = "json"
+		printer, err := output.NewPrinter(context.String("output"), context.String("template"))
+		if err != nil {
+			return err
 		}
-		tmplStr := context.String("template")
 
 		r, err := ImageFsInfo(imageClient)
 		if err != nil {
 			return errors.Wrap(err, "image filesystem info request")
 		}
-		for _, info := range r.ImageFilesystems {
-			status, err := protobufObjectToJSON(info)
-			if err != nil {
-				return errors.Wrap(err, "marshal image filesystem info to json")
-			}
-
-			switch output {
-			case "json", "yaml", "go-template":
-				if err := outputStatusInfo(status, nil, output, tmplStr); err != nil {
-					return errors.Wrap(err, "output image filesystem info")
+		if printer.Structured() {
+			items := make([]output.Item, 0, len(r.ImageFilesystems))
+			for _, info := range r.ImageFilesystems {
+				status, err := protobufObjectToJSON(info)
+				if err != nil {
+					return errors.Wrap(err, "marshal image filesystem info to json")
 				}
-				continue
-			case "table": // table output is after this switch block
-			default:
-				return fmt.Errorf("output option cannot be %s", output)
+				obj, err := marshalStatusInfo(status, nil)
+				if err != nil {
+					return errors.Wrap(err, "marshal image filesystem info")
+				}
+				items = append(items, output.Item{Name: info.GetFsId().GetMountpoint(), Object: obj})
 			}
+			return printer.PrintItems(os.Stdout, items)
+		}
 
-			// otherwise output in table format
+		// otherwise output in table format
+		for _, info := range r.ImageFilesystems {
 			fmt.Printf("TimeStamp: %d\n", info.Timestamp)
 			fmt.Printf("UsedBytes: %s\n", info.UsedBytes)
 			fmt.Printf("Mountpoint: %s\n", info.FsId.Mountpoint)
@@ -17,11 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"os"
+
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/net/context"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 var runtimeStatusCommand = &cli.Command{
@@ -33,8 +37,8 @@ var runtimeStatusCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Value:   "json",
-			Usage:   "Output format, One of: json|yaml|go-template",
+			Value:   output.FormatJSON,
+			Usage:   "Output format, One of: json|yaml|go-template|jsonpath|custom-columns=NAME:.path[,...]",
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -43,7 +47,7 @@ var runtimeStatusCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "template",
-			Usage: "The template string is only used when output is go-template; The Template format is golang template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -63,6 +67,16 @@ var runtimeStatusCommand = &cli.Command{
 
 // Info sends a StatusRequest to the server, and parses the returned StatusResponse.
 func Info(cliContext *cli.Context, client pb.RuntimeServiceClient) error {
+	printer, err := output.NewPrinter(cliContext.String("output"), cliContext.String("template"))
+	if err != nil {
+		return err
+	}
+	switch printer.Format() {
+	case output.FormatJSON, output.FormatYAML, output.FormatGoTemplate, output.FormatJSONPath, output.FormatCustomColumns:
+	default:
+		return errors.Errorf("unsupported output format %q", printer.Format())
+	}
+
 	request := &pb.StatusRequest{Verbose: !cliContext.Bool("quiet")}
 	logrus.Debugf("StatusRequest: %v", request)
 	r, err := client.Status(context.Background(), request)
@@ -75,5 +89,9 @@ func Info(cliContext *cli.Context, client pb.RuntimeServiceClient) error {
 	if err != nil {
 		return err
 	}
-	return outputStatusInfo(status, r.Info, cliContext.String("output"), cliContext.String("template"))
+	info, err := marshalStatusInfo(status, r.Info)
+	if err != nil {
+		return err
+	}
+	return printer.PrintItems(os.Stdout, []output.Item{{Object: info}})
 }
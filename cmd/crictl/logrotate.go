@@ -0,0 +1,321 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/crilog"
+)
+
+const (
+	// rotatedLogTimeFormat is the timestamp suffix of rotated logs, which
+	// matches the one of the kubelet.
+	rotatedLogTimeFormat = "20060102-150405"
+	// compressSuffix is the suffix of compressed rotated logs.
+	compressSuffix = ".gz"
+	// tmpSuffix is the suffix of logs which are being compressed.
+	tmpSuffix = ".tmp"
+)
+
+var logsRotateCommand = &cli.Command{
+	Name:      "rotate",
+	Usage:     "Rotate the logs of running containers like the kubelet",
+	ArgsUsage: "[CONTAINER-ID...]",
+	Description: "Rotate the log of every running container, or of the given ones, which exceeds --max-size. " +
+		"The log is renamed by appending the current time, the runtime is asked to reopen it, " +
+		"older rotated logs are compressed and only --max-files logs are kept per container.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "max-size",
+			Value: "10Mi",
+			Usage: "Maximum size of a log before it is rotated, e.g. 10Mi",
+		},
+		&cli.IntFlag{
+			Name:  "max-files",
+			Value: 5,
+			Usage: "Maximum number of log files kept per container, including the current one, must be at least 2",
+		},
+		&cli.BoolFlag{
+			Name:    "daemon",
+			Aliases: []string{"d"},
+			Usage:   "Keep rotating the logs every --interval until interrupted",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 10 * time.Second,
+			Usage: "Interval in which the logs are checked in daemon mode",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		maxSize, err := resource.ParseQuantity(context.String("max-size"))
+		if err != nil {
+			return errors.Wrap(err, "parse --max-size")
+		}
+		if maxSize.Value() <= 0 {
+			return fmt.Errorf("--max-size must be positive")
+		}
+		if context.Int("max-files") < 2 {
+			return fmt.Errorf("--max-files must be at least 2")
+		}
+		if context.Bool("daemon") && context.Duration("interval") <= 0 {
+			return fmt.Errorf("--interval must be positive")
+		}
+
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		r := &logRotator{
+			client:   runtimeClient,
+			ids:      context.Args().Slice(),
+			maxSize:  maxSize.Value(),
+			maxFiles: context.Int("max-files"),
+			now:      time.Now,
+		}
+		if !context.Bool("daemon") {
+			return r.rotateAll(context.Context)
+		}
+
+		ticker := time.NewTicker(context.Duration("interval"))
+		defer ticker.Stop()
+		interrupt := SetupInterruptSignalHandler()
+		for {
+			if err := r.rotateAll(context.Context); err != nil {
+				logrus.Errorf("Rotating logs: %v", err)
+			}
+			select {
+			case <-interrupt:
+				return nil
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// logRotator rotates container logs following the policy of the kubelet
+// container log manager.
+type logRotator struct {
+	client pb.RuntimeServiceClient
+	// ids of the containers to rotate the logs of, all if empty.
+	ids      []string
+	maxSize  int64
+	maxFiles int
+	now      func() time.Time
+}
+
+// rotateAll rotates the logs of the running containers. Failures of single
+// containers are logged and the first one is returned.
+func (r *logRotator) rotateAll(ctx context.Context) error {
+	request := &pb.ListContainersRequest{
+		Filter: &pb.ContainerFilter{State: &pb.ContainerStateValue{State: pb.ContainerState_CONTAINER_RUNNING}},
+	}
+	logrus.Debugf("ListContainersRequest: %v", request)
+	containers, err := r.client.ListContainers(ctx, request)
+	logrus.Debugf("ListContainersResponse: %v", containers)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, c := range containers.Containers {
+		if len(r.ids) > 0 && !matchesContainerID(r.ids, c.Id) {
+			continue
+		}
+		if err := r.rotate(ctx, c.Id); err != nil {
+			logrus.Errorf("Rotating the log of container %s: %v", c.Id, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// matchesContainerID returns whether id matches one of the IDs or ID
+// prefixes.
+func matchesContainerID(ids []string, id string) bool {
+	for _, prefix := range ids {
+		if strings.HasPrefix(id, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotate rotates the log of a container if it exceeds the maximum size.
+func (r *logRotator) rotate(ctx context.Context, id string) error {
+	logPath, err := containerLogPath(ctx, r.client, id)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(logPath)
+	if err != nil {
+		return err
+	}
+	if fi.Size() < r.maxSize {
+		return nil
+	}
+	logrus.Debugf("Rotating the log %s of container %s with %d bytes", logPath, id, fi.Size())
+
+	rotated, err := r.cleanupUnusedLogs(logPath)
+	if err != nil {
+		return errors.Wrap(err, "clean up unused logs")
+	}
+	if rotated, err = r.removeExcessLogs(rotated); err != nil {
+		return errors.Wrap(err, "remove excess logs")
+	}
+	if err := r.compressUncompressedLogs(rotated); err != nil {
+		return errors.Wrap(err, "compress logs")
+	}
+	return r.rotateLatestLog(ctx, id, logPath)
+}
+
+// cleanupUnusedLogs removes the temporary files of interrupted compressions
+// and uncompressed logs whose compression completed, and returns the
+// remaining rotated logs, oldest first.
+func (r *logRotator) cleanupUnusedLogs(logPath string) ([]string, error) {
+	files, err := crilog.RotatedFiles(logPath)
+	if err != nil {
+		return nil, err
+	}
+	tmpFiles, err := filepath.Glob(logPath + ".*" + tmpSuffix)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range tmpFiles {
+		if err := os.Remove(file); err != nil {
+			return nil, err
+		}
+	}
+	compressed := make(map[string]bool)
+	for _, file := range files {
+		if strings.HasSuffix(file, compressSuffix) {
+			compressed[strings.TrimSuffix(file, compressSuffix)] = true
+		}
+	}
+	var rotated []string
+	for _, file := range files {
+		if compressed[file] {
+			if err := os.Remove(file); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rotated = append(rotated, file)
+	}
+	return rotated, nil
+}
+
+// removeExcessLogs removes the oldest rotated logs, such that the current
+// log, the one about to be rotated and the remaining ones do not exceed the
+// maximum number of files.
+func (r *logRotator) removeExcessLogs(rotated []string) ([]string, error) {
+	maxRotated := r.maxFiles - 2
+	if maxRotated < 0 {
+		maxRotated = 0
+	}
+	for len(rotated) > maxRotated {
+		logrus.Debugf("Removing rotated log %s", rotated[0])
+		if err := os.Remove(rotated[0]); err != nil {
+			return nil, err
+		}
+		rotated = rotated[1:]
+	}
+	return rotated, nil
+}
+
+// compressUncompressedLogs compresses all but the newest rotated log, which
+// might still be written to by the runtime.
+func (r *logRotator) compressUncompressedLogs(rotated []string) error {
+	for i, file := range rotated {
+		if i == len(rotated)-1 || strings.HasSuffix(file, compressSuffix) {
+			continue
+		}
+		if err := compressLog(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressLog compresses a log into a temporary file first, which is
+// renamed once it is complete.
+func compressLog(file string) error {
+	in, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := file + compressSuffix + tmpSuffix
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		out.Close()
+		os.Remove(tmp)
+	}()
+	w := gzip.NewWriter(out)
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, file+compressSuffix); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// rotateLatestLog renames the current log and asks the runtime to reopen
+// it. The rename is rolled back if the runtime fails to do so.
+func (r *logRotator) rotateLatestLog(ctx context.Context, id, logPath string) error {
+	rotated := logPath + "." + r.now().Format(rotatedLogTimeFormat)
+	if err := os.Rename(logPath, rotated); err != nil {
+		return err
+	}
+	request := &pb.ReopenContainerLogRequest{ContainerId: id}
+	logrus.Debugf("ReopenContainerLogRequest: %v", request)
+	resp, err := r.client.ReopenContainerLog(ctx, request)
+	logrus.Debugf("ReopenContainerLogResponse: %v", resp)
+	if err != nil {
+		if renameErr := os.Rename(rotated, logPath); renameErr != nil {
+			logrus.Errorf("Restoring the log %s of container %s: %v", logPath, id, renameErr)
+		}
+		return errors.Wrap(err, "reopen container log")
+	}
+	return nil
+}
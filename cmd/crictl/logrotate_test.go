@@ -0,0 +1,110 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestLogRotator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logrotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "0.log")
+	files := func() []string {
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+		sort.Strings(names)
+		return names
+	}
+	for name, size := range map[string]int{
+		"0.log":                        20,
+		"0.log.20210301-090000.gz":     1,
+		"0.log.20210301-100000":        1,
+		"0.log.20210301-100000.gz":     1,
+		"0.log.20210301-110000":        1,
+		"0.log.20210301-120000":        1,
+		"0.log.20210301-120000.gz.tmp": 1,
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeRuntimeClient{
+		containers: []*pb.Container{{Id: "c1"}},
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c1": {Status: &pb.ContainerStatus{LogPath: logPath}},
+		},
+	}
+	r := &logRotator{
+		client:   client,
+		maxSize:  10,
+		maxFiles: 4,
+		now:      func() time.Time { return time.Date(2021, 3, 1, 13, 0, 0, 0, time.UTC) },
+	}
+
+	// A failing reopen restores the log.
+	client.reopenErr = fmt.Errorf("reopen failed")
+	if err := r.rotateAll(context.Background()); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(logPath); err != nil {
+		t.Errorf("expected the log to be restored: %v", err)
+	}
+
+	client.reopenErr = nil
+	if err := r.rotateAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"0.log.20210301-110000.gz", "0.log.20210301-120000", "0.log.20210301-130000"}
+	if got := files(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected files %q, but got %q", expected, got)
+	}
+	if expected := []string{"c1", "c1"}; !reflect.DeepEqual(client.reopened, expected) {
+		t.Errorf("expected reopened logs %q, but got %q", expected, client.reopened)
+	}
+
+	// Logs below the maximum size are not rotated.
+	if err := ioutil.WriteFile(logPath, make([]byte, 5), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.rotateAll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.reopened) != 2 {
+		t.Errorf("expected no rotation, but got %q", client.reopened)
+	}
+}
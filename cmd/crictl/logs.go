@@ -20,13 +20,19 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
 	timetypes "github.com/docker/docker/api/types/time"
+	dockerterm "github.com/docker/docker/pkg/term"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
 	"k8s.io/kubernetes/pkg/kubelet/kuberuntime/logs"
 )
 
@@ -35,6 +41,7 @@ var logsCommand = &cli.Command{
 	Usage:                  "Fetch the logs of a container",
 	ArgsUsage:              "CONTAINER-ID",
 	UseShortOptionHandling: true,
+	Subcommands:            []*cli.Command{logsRotateCommand},
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:    "follow",
@@ -44,7 +51,7 @@ var logsCommand = &cli.Command{
 		&cli.BoolFlag{
 			Name:    "previous",
 			Aliases: []string{"p"},
-			Usage:   "Print the logs for the previous instance of the container in a pod if it exists",
+			Usage:   "Print the logs for the previous instance of the container in a pod if it exists. Use --attempt for other attempts",
 		},
 		&cli.Int64Flag{
 			Name:  "tail",
@@ -66,15 +73,83 @@ var logsCommand = &cli.Command{
 			Aliases: []string{"t"},
 			Usage:   "Show timestamps",
 		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "Show logs until timestamp (e.g. 2013-01-02T13:23:37) or relative (e.g. 42m for 42 minutes)",
+		},
+		&cli.StringFlag{
+			Name:  "stream",
+			Usage: "Show only the logs of the stream, One of: stdout|stderr",
+		},
+		&cli.StringFlag{
+			Name:  "grep",
+			Usage: "Show only the log lines matching the regular expression",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output format, One of: text|json. json prints an object with the time, stream, tag and message of every line",
+			Value:   "text",
+		},
+		&cli.BoolFlag{
+			Name:  "all-rotated",
+			Usage: "Read the rotated and compressed logs of the container before its current log",
+		},
+		&cli.BoolFlag{
+			Name:  "follow-restarts",
+			Usage: "Follow log output across restarts of the container, continuing with its next attempts",
+		},
+		&cli.StringFlag{
+			Name:  "pod",
+			Usage: "Print the logs of all containers of the pod with the given ID or name, or of the pod with the given name with --from-dir",
+		},
+		&cli.BoolFlag{
+			Name:  "from-dir",
+			Usage: "Read the log from the pod log directory instead of asking the runtime, e.g. for removed containers",
+		},
+		&cli.StringFlag{
+			Name:  "pods-log-dir",
+			Value: defaultPodLogsDir,
+			Usage: "Directory of the pod logs used by --from-dir",
+		},
+		&cli.StringFlag{
+			Name:  "pod-uid",
+			Usage: "UID of the pod whose log is read with --from-dir",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "Name of the container whose log is read with --from-dir. Optional if the pod has a single container",
+		},
+		&cli.Int64Flag{
+			Name:  "attempt",
+			Value: -1,
+			Usage: "Print the logs of the given attempt of the container. Defaults to the newest one with --from-dir",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Print the logs of all containers matching the label selector, e.g. key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key",
+		},
 	},
 	Action: func(ctx *cli.Context) (retErr error) {
-		runtimeService, err := getRuntimeService(ctx)
+		containerID := ctx.Args().First()
+		pod := ctx.String("pod")
+		labelMap, selector, err := parseLabelStringSlice(ctx.StringSlice("label"))
 		if err != nil {
 			return err
 		}
-
-		containerID := ctx.Args().First()
-		if containerID == "" {
+		fromDir := ctx.Bool("from-dir")
+		multiple := pod != "" || len(ctx.StringSlice("label")) > 0
+		switch {
+		case fromDir:
+			if containerID != "" || len(ctx.StringSlice("label")) > 0 {
+				return fmt.Errorf("a container ID and --label cannot be combined with --from-dir")
+			}
+			if pod == "" && ctx.String("pod-uid") == "" {
+				return fmt.Errorf("--from-dir requires --pod or --pod-uid")
+			}
+		case multiple && containerID != "":
+			return fmt.Errorf("a container ID cannot be combined with --pod or --label")
+		case containerID == "" && !multiple:
 			return fmt.Errorf("ID cannot be empty")
 		}
 		tailLines := ctx.Int64("tail")
@@ -85,6 +160,80 @@ var logsCommand = &cli.Command{
 		}
 		timestamp := ctx.Bool("timestamps")
 		previous := ctx.Bool("previous")
+
+		until, err := parseTimestamp(ctx.String("until"))
+		if err != nil {
+			return err
+		}
+		stream := pb.LogStreamType(ctx.String("stream"))
+		if stream != "" && stream != pb.Stdout && stream != pb.Stderr {
+			return fmt.Errorf("unsupported stream %q, must be one of: stdout|stderr", stream)
+		}
+		var grep *regexp.Regexp
+		if ctx.String("grep") != "" {
+			if grep, err = regexp.Compile(ctx.String("grep")); err != nil {
+				return errors.Wrap(err, "parse --grep")
+			}
+		}
+		outputFormat := ctx.String("output")
+		if outputFormat != "text" && outputFormat != "json" {
+			return fmt.Errorf("unsupported output format %q, must be one of: text|json", outputFormat)
+		}
+		opts := logStreamOptions{
+			follow:         ctx.Bool("follow") || ctx.Bool("follow-restarts"),
+			followRestarts: ctx.Bool("follow-restarts"),
+			tail:           tailLines,
+			timestamps:     timestamp,
+			color:          dockerterm.IsTerminal(os.Stdout.Fd()),
+			allRotated:     ctx.Bool("all-rotated"),
+			json:           outputFormat == "json",
+			stream:         stream,
+			grep:           grep,
+		}
+		if since != nil {
+			opts.since = &since.Time
+		}
+		if until != nil {
+			opts.until = &until.Time
+		}
+
+		if ctx.IsSet("attempt") && previous {
+			return fmt.Errorf("--attempt cannot be combined with --previous")
+		}
+		if fromDir {
+			if limitBytes >= 0 || opts.followRestarts {
+				return fmt.Errorf("--limit-bytes and --follow-restarts cannot be combined with --from-dir")
+			}
+			query := podLogQuery{
+				podUID:    ctx.String("pod-uid"),
+				podName:   pod,
+				container: ctx.String("name"),
+				attempt:   ctx.Int64("attempt"),
+			}
+			if previous {
+				query.attempt, query.previous = -1, true
+			}
+			target, err := findPodLog(ctx.String("pods-log-dir"), query)
+			if err != nil {
+				return err
+			}
+			streamCtx, cancelFn := interruptibleContext(ctx.Context)
+			defer cancelFn()
+			return streamLogs(streamCtx, nil, []*logTarget{target}, nil, opts, os.Stdout, os.Stderr)
+		}
+
+		if multiple || opts.followRestarts || opts.allRotated ||
+			until != nil || stream != "" || grep != nil || opts.json {
+			if previous || ctx.IsSet("attempt") || limitBytes >= 0 {
+				return fmt.Errorf("--previous, --attempt and --limit-bytes cannot be combined with --pod, --label, --follow-restarts, --all-rotated, --until, --stream, --grep or --output json")
+			}
+			return runLogStream(ctx, containerID, pod, labelMap, selector, opts)
+		}
+
+		runtimeService, err := getRuntimeService(ctx)
+		if err != nil {
+			return err
+		}
 		logOptions := logs.NewLogOptions(&v1.PodLogOptions{
 			Follow:     ctx.Bool("follow"),
 			TailLines:  &tailLines,
@@ -100,13 +249,21 @@ var logsCommand = &cli.Command{
 		if logPath == "" {
 			return fmt.Errorf("The container has not set log path")
 		}
-		if previous {
-			containerAttempt := status.GetMetadata().Attempt
-			if containerAttempt == uint32(0) {
-				return fmt.Errorf("Previous terminated container %s not found", status.GetMetadata().Name)
+		if previous || ctx.IsSet("attempt") {
+			containerAttempt := ctx.Int64("attempt")
+			if previous {
+				containerAttempt = int64(status.GetMetadata().Attempt) - 1
+				if containerAttempt < 0 {
+					return fmt.Errorf("Previous terminated container %s not found", status.GetMetadata().Name)
+				}
+			} else if containerAttempt < 0 {
+				return fmt.Errorf("invalid attempt %d", containerAttempt)
 			}
-			logPath = fmt.Sprintf("%s%s%s", logPath[:strings.LastIndex(logPath, "/")+1], fmt.Sprint(containerAttempt-1),
+			logPath = fmt.Sprintf("%s%s%s", logPath[:strings.LastIndex(logPath, "/")+1], fmt.Sprint(containerAttempt),
 				logPath[strings.LastIndex(logPath, "."):])
+			if _, err := os.Stat(logPath); err != nil {
+				return fmt.Errorf("Log of attempt %d of container %s not found", containerAttempt, status.GetMetadata().Name)
+			}
 		}
 		// build a WithCancel context based on cli.context
 		readLogCtx, cancelFn := context.WithCancel(ctx.Context)
@@ -131,6 +288,74 @@ var logsCommand = &cli.Command{
 	},
 }
 
+// runLogStream streams the logs of a single container, or of the
+// containers selected by the pod and labels, until the user hits CtrlC.
+func runLogStream(ctx *cli.Context, containerID, pod string, labelMap map[string]string, selector labels.Selector, opts logStreamOptions) error {
+	client, conn, err := getRuntimeClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeConnection(ctx, conn)
+
+	streamCtx, cancelFn := interruptibleContext(ctx.Context)
+	defer cancelFn()
+
+	if containerID != "" {
+		request := &pb.ContainerStatusRequest{ContainerId: containerID}
+		logrus.Debugf("ContainerStatusRequest: %v", request)
+		r, err := client.ContainerStatus(streamCtx, request)
+		logrus.Debugf("ContainerStatusResponse: %v", r)
+		if err != nil {
+			return err
+		}
+		status := r.GetStatus()
+		if status.GetLogPath() == "" {
+			return fmt.Errorf("The container has not set log path")
+		}
+		target := &logTarget{
+			id:      status.GetId(),
+			name:    status.GetMetadata().GetName(),
+			attempt: status.GetMetadata().GetAttempt(),
+			logPath: status.GetLogPath(),
+		}
+		// The container status lacks the pod sandbox, which is needed to
+		// find the next attempts.
+		containers, err := client.ListContainers(streamCtx, &pb.ListContainersRequest{
+			Filter: &pb.ContainerFilter{Id: status.GetId()},
+		})
+		if err != nil {
+			return err
+		}
+		for _, c := range containers.GetContainers() {
+			target.podID = c.PodSandboxId
+		}
+		return streamLogs(streamCtx, client, []*logTarget{target}, nil, opts, os.Stdout, os.Stderr)
+	}
+
+	discover := func() ([]*logTarget, error) {
+		return selectLogTargets(streamCtx, client, pod, labelMap, selector)
+	}
+	targets, err := discover()
+	if err != nil {
+		return err
+	}
+	return streamLogs(streamCtx, client, targets, discover, opts, os.Stdout, os.Stderr)
+}
+
+// interruptibleContext returns a context which is canceled once the user
+// hits CtrlC.
+func interruptibleContext(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancelFn := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-SetupInterruptSignalHandler():
+			cancelFn()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancelFn
+}
+
 // parseTimestamp parses timestamp string as golang duration,
 // then RFC3339 time and finally as a Unix timestamp.
 func parseTimestamp(value string) (*metav1.Time, error) {
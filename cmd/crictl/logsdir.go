@@ -0,0 +1,151 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultPodLogsDir is the directory the kubelet writes the container logs
+// to, laid out as <namespace>_<pod>_<uid>/<container>/<attempt>.log.
+const defaultPodLogsDir = "/var/log/pods"
+
+// podLogQuery selects a container log in the pod log directory.
+type podLogQuery struct {
+	podUID    string
+	podName   string
+	container string
+	// attempt of the container, -1 for the newest one.
+	attempt int64
+	// previous selects the attempt before the newest one.
+	previous bool
+}
+
+// findPodLog returns the target of the container log in root which matches
+// the query.
+func findPodLog(root string, q podLogQuery) (*logTarget, error) {
+	podDir, err := findPodLogDir(root, q.podUID, q.podName)
+	if err != nil {
+		return nil, err
+	}
+
+	container := q.container
+	if container == "" {
+		entries, err := ioutil.ReadDir(podDir)
+		if err != nil {
+			return nil, errors.Wrap(err, "read pod log directory")
+		}
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() {
+				names = append(names, e.Name())
+			}
+		}
+		if len(names) != 1 {
+			return nil, fmt.Errorf("pod %s has %d containers %v, select one with --name", filepath.Base(podDir), len(names), names)
+		}
+		container = names[0]
+	}
+
+	attempts, err := logAttempts(filepath.Join(podDir, container))
+	if err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return nil, fmt.Errorf("no logs of container %s found in %s", container, podDir)
+	}
+	attempt := attempts[len(attempts)-1]
+	switch {
+	case q.previous:
+		if len(attempts) < 2 {
+			return nil, fmt.Errorf("Previous terminated container %s not found", container)
+		}
+		attempt = attempts[len(attempts)-2]
+	case q.attempt >= 0:
+		attempt = -1
+		for _, a := range attempts {
+			if a == q.attempt {
+				attempt = a
+			}
+		}
+		if attempt < 0 {
+			return nil, fmt.Errorf("Log of attempt %d of container %s not found, available attempts are %v", q.attempt, container, attempts)
+		}
+	}
+
+	return &logTarget{
+		id:      fmt.Sprintf("%s/%s", filepath.Base(podDir), container),
+		name:    container,
+		attempt: uint32(attempt),
+		logPath: filepath.Join(podDir, container, fmt.Sprintf("%d.log", attempt)),
+	}, nil
+}
+
+// findPodLogDir returns the log directory of the single pod with the given
+// UID and name. Either of them may be empty.
+func findPodLogDir(root, uid, name string) (string, error) {
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return "", errors.Wrap(err, "read pods log directory")
+	}
+	var dirs []string
+	for _, e := range entries {
+		parts := strings.SplitN(e.Name(), "_", 3)
+		if !e.IsDir() || len(parts) != 3 {
+			continue
+		}
+		if (uid == "" || parts[2] == uid) && (name == "" || parts[1] == name) {
+			dirs = append(dirs, e.Name())
+		}
+	}
+	switch len(dirs) {
+	case 0:
+		return "", fmt.Errorf("no log directory of pod with name %q and UID %q found in %s", name, uid, root)
+	case 1:
+		return filepath.Join(root, dirs[0]), nil
+	default:
+		return "", fmt.Errorf("pod name %q is ambiguous, found %v, select one with --pod-uid", name, dirs)
+	}
+}
+
+// logAttempts returns the sorted attempts of the <attempt>.log files in dir.
+func logAttempts(dir string) ([]int64, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read container log directory")
+	}
+	var attempts []int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".log") {
+			continue
+		}
+		attempt, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), ".log"), 10, 32)
+		if err != nil {
+			continue
+		}
+		attempts = append(attempts, int64(attempt))
+	}
+	sort.Slice(attempts, func(i, j int) bool { return attempts[i] < attempts[j] })
+	return attempts, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindPodLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, path := range []string{
+		"default_web_uid1/nginx/0.log",
+		"default_web_uid1/nginx/2.log",
+		"default_web_uid1/nginx/10.log",
+		"default_web_uid1/nginx/10.log.20210301-120000.gz",
+		"kube-system_web_uid2/app/0.log",
+		"kube-system_web_uid2/sidecar/0.log",
+	} {
+		writeLog(t, filepath.Join(dir, path), "000000001 stdout line")
+	}
+
+	for _, tc := range []struct {
+		query    podLogQuery
+		expected string
+	}{
+		{podLogQuery{podUID: "uid1", attempt: -1}, "default_web_uid1/nginx/10.log"},
+		{podLogQuery{podUID: "uid1", attempt: -1, previous: true}, "default_web_uid1/nginx/2.log"},
+		{podLogQuery{podUID: "uid1", attempt: 0}, "default_web_uid1/nginx/0.log"},
+		{podLogQuery{podUID: "uid1", podName: "web", container: "nginx", attempt: 2}, "default_web_uid1/nginx/2.log"},
+		{podLogQuery{podUID: "uid2", container: "sidecar", attempt: -1}, "kube-system_web_uid2/sidecar/0.log"},
+		// Errors.
+		{podLogQuery{podUID: "uid1", attempt: 1}, ""},
+		{podLogQuery{podUID: "uid2", attempt: -1}, ""},
+		{podLogQuery{podUID: "uid2", container: "app", attempt: -1, previous: true}, ""},
+		{podLogQuery{podName: "web", attempt: -1}, ""},
+		{podLogQuery{podName: "db", attempt: -1}, ""},
+	} {
+		target, err := findPodLog(dir, tc.query)
+		if tc.expected == "" {
+			if err == nil {
+				t.Errorf("expected an error for %+v, but got %s", tc.query, target.logPath)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", tc.query, err)
+		} else if target.logPath != filepath.Join(dir, tc.expected) {
+			t.Errorf("expected %s for %+v, but got %s", tc.expected, tc.query, target.logPath)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/labels"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/crilog"
+)
+
+const (
+	// logTimeFormat is the format of the timestamps of streamed log lines,
+	// which matches the one of kubelet.
+	logTimeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+	// logDiscoveryInterval is the interval in which new containers are
+	// looked up while following the logs of a pod or a label selector.
+	logDiscoveryInterval = 2 * time.Second
+	// logStatusInterval is the interval in which a followed container is
+	// checked for having exited.
+	logStatusInterval = time.Second
+)
+
+// logPrefixColors are the colors of the prefixes of the containers.
+var logPrefixColors = []string{"\033[31m", "\033[32m", "\033[33m", "\033[34m", "\033[35m", "\033[36m"}
+
+// logTarget is a container whose log is streamed.
+type logTarget struct {
+	id      string
+	podID   string
+	podName string
+	name    string
+	attempt uint32
+	logPath string
+	// prefix is printed in front of every line of the container.
+	prefix string
+}
+
+// logStreamOptions are the options of streaming the logs of containers.
+type logStreamOptions struct {
+	// follow the logs until the containers exited.
+	follow bool
+	// followRestarts keeps following the next attempts of the containers.
+	followRestarts bool
+	// tail is the number of lines to print per container, -1 prints all.
+	tail int64
+	// since skips lines before the time if set.
+	since *time.Time
+	// timestamps prints the time of every line.
+	timestamps bool
+	// color prints the prefixes in color.
+	color bool
+	// allRotated reads the rotated logs before the current ones.
+	allRotated bool
+	// json prints every line as a JSON object.
+	json bool
+	// stream prints only the lines of the stream if set.
+	stream pb.LogStreamType
+	// until skips lines after the time if set, and ends following the logs
+	// once it has passed.
+	until *time.Time
+	// grep prints only the lines matching the expression if set.
+	grep *regexp.Regexp
+}
+
+// logLineJSON is a line of the JSON output.
+type logLineJSON struct {
+	Time      string `json:"time"`
+	Stream    string `json:"stream"`
+	Tag       string `json:"tag"`
+	Message   string `json:"message"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+}
+
+// matches returns whether a full line passes the filters.
+func (o *logStreamOptions) matches(e *crilog.Entry) bool {
+	switch {
+	case o.since != nil && e.Time.Before(*o.since):
+		return false
+	case o.until != nil && e.Time.After(*o.until):
+		return false
+	case o.stream != "" && e.Stream != o.stream:
+		return false
+	case o.grep != nil && !o.grep.Match(e.Log):
+		return false
+	}
+	return true
+}
+
+// pastUntil returns whether following the logs ends, because a line after
+// until has been read or until has passed.
+func (o *logStreamOptions) pastUntil(lineAfterUntil bool) bool {
+	return o.until != nil && (lineAfterUntil || time.Now().After(*o.until))
+}
+
+// logLine is a full line of the log of a target.
+type logLine struct {
+	target *logTarget
+	entry  *crilog.Entry
+}
+
+// logStreamer merges the log lines of several containers, ordered by their
+// timestamps.
+type logStreamer struct {
+	client         pb.RuntimeServiceClient
+	opts           logStreamOptions
+	stdout, stderr io.Writer
+
+	wg       sync.WaitGroup
+	backlogs chan []logLine
+	lines    chan logLine
+}
+
+// streamLogs prints the logs of the targets. The lines which have already
+// been written are merged by their timestamps. In follow mode, newer lines
+// are printed as they arrive, and discover, if set, is called regularly to
+// find new containers to stream the logs of. Without a client, the logs are
+// followed until ctx is done or until has passed.
+func streamLogs(ctx context.Context, client pb.RuntimeServiceClient, targets []*logTarget, discover func() ([]*logTarget, error), opts logStreamOptions, stdout, stderr io.Writer) error {
+	s := &logStreamer{
+		client:   client,
+		opts:     opts,
+		stdout:   stdout,
+		stderr:   stderr,
+		backlogs: make(chan []logLine),
+		lines:    make(chan logLine),
+	}
+	seen := make(map[string]bool)
+	for _, t := range targets {
+		seen[t.id] = true
+		s.start(ctx, t, opts.tail)
+	}
+	var backlog []logLine
+	for range targets {
+		select {
+		case lines := <-s.backlogs:
+			backlog = append(backlog, lines...)
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	if err := s.printBacklog(backlog); err != nil {
+		return err
+	}
+	if !opts.follow {
+		s.wg.Wait()
+		return nil
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+	done := finished
+	var discoverC <-chan time.Time
+	if discover != nil {
+		ticker := time.NewTicker(logDiscoveryInterval)
+		defer ticker.Stop()
+		discoverC = ticker.C
+		// Keep running until interrupted, new containers may show up.
+		done = nil
+	}
+	var untilC <-chan time.Time
+	if opts.until != nil {
+		timer := time.NewTimer(time.Until(*opts.until))
+		defer timer.Stop()
+		untilC = timer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-untilC:
+			// New containers have no lines before until.
+			discoverC, done = nil, finished
+		case l := <-s.lines:
+			if err := s.print(l); err != nil {
+				return err
+			}
+		case lines := <-s.backlogs:
+			if err := s.printBacklog(lines); err != nil {
+				return err
+			}
+		case <-discoverC:
+			targets, err := discover()
+			if err != nil {
+				logrus.Warnf("Unable to discover containers: %v", err)
+				continue
+			}
+			for _, t := range targets {
+				if !seen[t.id] {
+					seen[t.id] = true
+					// Show the whole log of containers started since.
+					s.start(ctx, t, -1)
+				}
+			}
+		}
+	}
+}
+
+// start streams the log of a target in the background. The lines up to the
+// current end of the log are sent as a single backlog, and the following
+// lines one by one.
+func (s *logStreamer) start(ctx context.Context, t *logTarget, tail int64) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		var backlog []logLine
+		sent := false
+		sendBacklog := func() {
+			if sent {
+				return
+			}
+			sent = true
+			if tail >= 0 && int64(len(backlog)) > tail {
+				backlog = backlog[int64(len(backlog))-tail:]
+			}
+			select {
+			case s.backlogs <- backlog:
+			case <-ctx.Done():
+			}
+		}
+		defer sendBacklog()
+
+		lineAfterUntil := false
+		for {
+			var joiner crilog.Joiner
+			handle := func(e *crilog.Entry, err error) error {
+				if err != nil {
+					logrus.Warnf("Skipping log line of container %s: %v", t.id, err)
+					return nil
+				}
+				if e = joiner.Add(e); e == nil {
+					return nil
+				}
+				if s.opts.until != nil && e.Time.After(*s.opts.until) {
+					lineAfterUntil = true
+				}
+				if !s.opts.matches(e) {
+					return nil
+				}
+				l := logLine{target: t, entry: e}
+				if !sent {
+					backlog = append(backlog, l)
+					return nil
+				}
+				select {
+				case s.lines <- l:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			if s.opts.allRotated {
+				if err := s.readRotated(t, handle); err != nil {
+					logrus.Warnf("Unable to read the rotated logs of container %s: %v", t.id, err)
+				}
+			}
+
+			var lastCheck time.Time
+			err := crilog.Follow(ctx, t.logPath, handle, func() bool {
+				sendBacklog()
+				if !s.opts.follow || s.opts.pastUntil(lineAfterUntil) {
+					return false
+				}
+				if time.Since(lastCheck) < logStatusInterval {
+					return true
+				}
+				lastCheck = time.Now()
+				return s.isRunning(ctx, t.id)
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					logrus.Warnf("Unable to read the log of container %s: %v", t.id, err)
+				}
+				return
+			}
+			if !s.opts.followRestarts || s.opts.pastUntil(lineAfterUntil) {
+				return
+			}
+			if t = s.waitForNextAttempt(ctx, t); t == nil {
+				return
+			}
+		}
+	}()
+}
+
+// readRotated reads the rotated logs of a target, oldest first.
+func (s *logStreamer) readRotated(t *logTarget, handle func(*crilog.Entry, error) error) error {
+	files, err := crilog.RotatedFiles(t.logPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		logrus.Debugf("Reading rotated log %s", file)
+		if err := crilog.ReadFile(file, handle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *logStreamer) isRunning(ctx context.Context, id string) bool {
+	if s.client == nil {
+		// Logs read from the pod log directory are followed until ctx is done.
+		return true
+	}
+	r, err := s.client.ContainerStatus(ctx, &pb.ContainerStatusRequest{ContainerId: id})
+	if err != nil {
+		return false
+	}
+	return r.GetStatus().GetState() == pb.ContainerState_CONTAINER_RUNNING
+}
+
+// waitForNextAttempt waits for a container of the same pod and name as t
+// with a higher attempt, and returns its target. It returns nil once ctx is
+// done.
+func (s *logStreamer) waitForNextAttempt(ctx context.Context, t *logTarget) *logTarget {
+	for {
+		r, err := s.client.ListContainers(ctx, &pb.ListContainersRequest{
+			Filter: &pb.ContainerFilter{PodSandboxId: t.podID},
+		})
+		if err != nil && ctx.Err() == nil {
+			logrus.Warnf("Unable to list the containers of pod %s: %v", t.podID, err)
+		}
+		var next *pb.Container
+		for _, c := range r.GetContainers() {
+			if c.GetMetadata().GetName() == t.name && c.GetMetadata().GetAttempt() > t.attempt &&
+				(next == nil || c.GetMetadata().GetAttempt() > next.GetMetadata().GetAttempt()) {
+				next = c
+			}
+		}
+		if next != nil {
+			if logPath, err := containerLogPath(ctx, s.client, next.Id); err == nil {
+				logrus.Debugf("Following attempt %d of container %s", next.GetMetadata().GetAttempt(), t.name)
+				return &logTarget{
+					id:      next.Id,
+					podID:   t.podID,
+					podName: t.podName,
+					name:    t.name,
+					attempt: next.GetMetadata().GetAttempt(),
+					logPath: logPath,
+					prefix:  t.prefix,
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logStatusInterval):
+		}
+	}
+}
+
+func (s *logStreamer) printBacklog(lines []logLine) error {
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].entry.Time.Before(lines[j].entry.Time)
+	})
+	for _, l := range lines {
+		if err := s.print(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print writes a line to the stream it was logged to, or as JSON to
+// stdout.
+func (s *logStreamer) print(l logLine) error {
+	if s.opts.json {
+		data, err := json.Marshal(&logLineJSON{
+			Time:      l.entry.Time.Format(time.RFC3339Nano),
+			Stream:    string(l.entry.Stream),
+			Tag:       l.entry.Tags,
+			Message:   string(l.entry.Log),
+			Pod:       l.target.podName,
+			Container: l.target.name,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(s.stdout, string(data))
+		return err
+	}
+
+	var buf bytes.Buffer
+	if prefix := l.target.prefix; prefix != "" {
+		if s.opts.color {
+			h := fnv.New32a()
+			h.Write([]byte(prefix))
+			prefix = logPrefixColors[h.Sum32()%uint32(len(logPrefixColors))] + prefix + colorReset
+		}
+		buf.WriteString(prefix)
+	}
+	if s.opts.timestamps {
+		buf.WriteString(l.entry.Time.Format(logTimeFormat))
+		buf.WriteByte(' ')
+	}
+	buf.Write(l.entry.Log)
+	buf.WriteByte('\n')
+	w := s.stdout
+	if l.entry.Stream == pb.Stderr {
+		w = s.stderr
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// containerLogPath returns the log path of a container.
+func containerLogPath(ctx context.Context, client pb.RuntimeServiceClient, id string) (string, error) {
+	request := &pb.ContainerStatusRequest{ContainerId: id}
+	logrus.Debugf("ContainerStatusRequest: %v", request)
+	r, err := client.ContainerStatus(ctx, request)
+	logrus.Debugf("ContainerStatusResponse: %v", r)
+	if err != nil {
+		return "", err
+	}
+	if r.GetStatus().GetLogPath() == "" {
+		return "", errors.Errorf("container %s has no log path", id)
+	}
+	return r.GetStatus().GetLogPath(), nil
+}
+
+// selectLogTargets returns the newest attempts of the containers of the pod
+// with the given ID, ID prefix or name, which match the labels. The lines of
+// every container are prefixed with its pod and container name.
+func selectLogTargets(ctx context.Context, client pb.RuntimeServiceClient, pod string, labelMap map[string]string, selector labels.Selector) ([]*logTarget, error) {
+	pods, err := selectPodSandboxes(ctx, client, pod)
+	if err != nil {
+		return nil, err
+	}
+
+	containers, err := client.ListContainers(ctx, &pb.ListContainersRequest{
+		Filter: &pb.ContainerFilter{LabelSelector: labelMap},
+	})
+	if err != nil {
+		return nil, err
+	}
+	newest := make(map[string]*pb.Container)
+	for _, c := range containers.Containers {
+		if pods[c.PodSandboxId] == nil || !matchesLabels(selector, c.Labels) {
+			continue
+		}
+		key := c.PodSandboxId + "/" + c.GetMetadata().GetName()
+		if n, ok := newest[key]; !ok || c.GetMetadata().GetAttempt() > n.GetMetadata().GetAttempt() {
+			newest[key] = c
+		}
+	}
+
+	var targets []*logTarget
+	for _, c := range newest {
+		logPath, err := containerLogPath(ctx, client, c.Id)
+		if err != nil {
+			logrus.Warnf("Skipping the log of container %s: %v", c.Id, err)
+			continue
+		}
+		podName := pods[c.PodSandboxId].GetMetadata().GetName()
+		targets = append(targets, &logTarget{
+			id:      c.Id,
+			podID:   c.PodSandboxId,
+			podName: podName,
+			name:    c.GetMetadata().GetName(),
+			attempt: c.GetMetadata().GetAttempt(),
+			logPath: logPath,
+			prefix:  fmt.Sprintf("[%s/%s] ", podName, c.GetMetadata().GetName()),
+		})
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].prefix < targets[j].prefix })
+	return targets, nil
+}
+
+// selectPodSandboxes returns the pod sandboxes with the given ID, ID prefix or
+// name by their IDs, or all of them if pod is empty.
+func selectPodSandboxes(ctx context.Context, client pb.RuntimeServiceClient, pod string) (map[string]*pb.PodSandbox, error) {
+	sandboxes, err := client.ListPodSandbox(ctx, &pb.ListPodSandboxRequest{})
+	if err != nil {
+		return nil, err
+	}
+	pods := make(map[string]*pb.PodSandbox)
+	for _, p := range sandboxes.Items {
+		if pod == "" || strings.HasPrefix(p.Id, pod) || p.GetMetadata().GetName() == pod {
+			pods[p.Id] = p
+		}
+	}
+	if len(pods) == 0 && pod != "" {
+		return nil, errors.Errorf("pod %q not found", pod)
+	}
+	return pods, nil
+}
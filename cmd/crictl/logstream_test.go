@@ -0,0 +1,244 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// writeLog writes a CRI log file of lines of the form "<nanoseconds> <stream> <message>".
+func writeLog(t *testing.T, path string, lines ...string) {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 3)
+		buf.WriteString("2021-03-01T12:00:00." + fields[0] + "Z " + fields[1] + " F " + fields[2] + "\n")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStreamLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLog(t, filepath.Join(dir, "web.log"), "000000001 stdout web 1", "000000003 stderr web 2", "000000005 stdout web 3")
+	writeLog(t, filepath.Join(dir, "db.log"), "000000002 stdout db 1", "000000004 stdout db 2")
+
+	targets := []*logTarget{
+		{id: "c1", logPath: filepath.Join(dir, "web.log"), prefix: "[pod/web] "},
+		{id: "c2", logPath: filepath.Join(dir, "db.log"), prefix: "[pod/db] "},
+	}
+	var stdout, stderr bytes.Buffer
+	err = streamLogs(context.Background(), &fakeRuntimeClient{}, targets, nil, logStreamOptions{tail: 2}, &stdout, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "[pod/db] db 1\n[pod/db] db 2\n[pod/web] web 3\n"
+	if stdout.String() != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, stdout.String())
+	}
+	if expected := "[pod/web] web 2\n"; stderr.String() != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, stderr.String())
+	}
+}
+
+func TestStreamLogsAllRotated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "0.log")
+	writeLog(t, logPath+".20210301-115900", "000000001 stdout one", "000000002 stdout two")
+	writeLog(t, logPath+".20210301-120000", "000000003 stdout three")
+	writeLog(t, logPath, "000000004 stdout four")
+
+	since := time.Date(2021, 3, 1, 12, 0, 0, 2, time.UTC)
+	for _, tc := range []struct {
+		opts     logStreamOptions
+		expected string
+	}{
+		{logStreamOptions{tail: -1}, "four\n"},
+		{logStreamOptions{tail: -1, allRotated: true}, "one\ntwo\nthree\nfour\n"},
+		{logStreamOptions{tail: 3, allRotated: true}, "two\nthree\nfour\n"},
+		{logStreamOptions{tail: -1, allRotated: true, since: &since}, "two\nthree\nfour\n"},
+		{logStreamOptions{tail: 1, allRotated: true, timestamps: true}, "2021-03-01T12:00:00.000000004Z four\n"},
+	} {
+		var stdout bytes.Buffer
+		err := streamLogs(context.Background(), &fakeRuntimeClient{}, []*logTarget{{id: "c1", logPath: logPath}}, nil, tc.opts, &stdout, &stdout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout.String() != tc.expected {
+			t.Errorf("expected %q for %+v, but got %q", tc.expected, tc.opts, stdout.String())
+		}
+	}
+}
+
+func TestStreamLogsJSONAndFilters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "0.log")
+	data := strings.Join([]string{
+		"2021-03-01T12:00:00.000000001Z stdout P GET ",
+		"2021-03-01T12:00:00.000000002Z stderr F warning: slow",
+		"2021-03-01T12:00:00.000000003Z stdout F /index.html",
+		"2021-03-01T12:00:00.000000004Z stdout F GET /favicon.ico",
+		"",
+	}, "\n")
+	if err := ioutil.WriteFile(logPath, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	target := &logTarget{id: "c1", name: "web", logPath: logPath}
+	until := time.Date(2021, 3, 1, 12, 0, 0, 3, time.UTC)
+
+	for _, tc := range []struct {
+		opts     logStreamOptions
+		expected string
+	}{
+		{
+			opts: logStreamOptions{tail: -1, json: true, stream: pb.Stdout},
+			expected: `{"time":"2021-03-01T12:00:00.000000001Z","stream":"stdout","tag":"F","message":"GET /index.html","container":"web"}` + "\n" +
+				`{"time":"2021-03-01T12:00:00.000000004Z","stream":"stdout","tag":"F","message":"GET /favicon.ico","container":"web"}` + "\n",
+		},
+		{
+			opts:     logStreamOptions{tail: -1, grep: regexp.MustCompile("^GET")},
+			expected: "GET /index.html\nGET /favicon.ico\n",
+		},
+		{
+			opts:     logStreamOptions{tail: -1, until: &until},
+			expected: "GET /index.html\nwarning: slow\n",
+		},
+	} {
+		var out bytes.Buffer
+		if err := streamLogs(context.Background(), &fakeRuntimeClient{}, []*logTarget{target}, nil, tc.opts, &out, &out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tc.expected {
+			t.Errorf("expected %q for %+v, but got %q", tc.expected, tc.opts, out.String())
+		}
+	}
+}
+
+// lineWriter sends every write to a channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestStreamLogsFollowRestarts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLog(t, filepath.Join(dir, "0.log"), "000000001 stdout first attempt")
+	writeLog(t, filepath.Join(dir, "1.log"), "000000002 stdout second attempt")
+
+	client := &fakeRuntimeClient{
+		containers: []*pb.Container{
+			{Id: "c0", PodSandboxId: "p1", Metadata: &pb.ContainerMetadata{Name: "web"}},
+			{Id: "c1", PodSandboxId: "p1", Metadata: &pb.ContainerMetadata{Name: "web", Attempt: 1}},
+			{Id: "other", PodSandboxId: "p1", Metadata: &pb.ContainerMetadata{Name: "db", Attempt: 2}},
+		},
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c0": {Status: &pb.ContainerStatus{State: pb.ContainerState_CONTAINER_EXITED, LogPath: filepath.Join(dir, "0.log")}},
+			"c1": {Status: &pb.ContainerStatus{State: pb.ContainerState_CONTAINER_RUNNING, LogPath: filepath.Join(dir, "1.log")}},
+		},
+	}
+	target := &logTarget{id: "c0", podID: "p1", name: "web", logPath: filepath.Join(dir, "0.log")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out := make(lineWriter)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- streamLogs(ctx, client, []*logTarget{target}, nil, logStreamOptions{follow: true, followRestarts: true, tail: -1}, out, out)
+	}()
+	var got []string
+	for len(got) < 2 {
+		select {
+		case line := <-out:
+			got = append(got, line)
+		case <-ctx.Done():
+			t.Fatalf("timed out, got %q", got)
+		}
+	}
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"first attempt\n", "second attempt\n"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, but got %q", expected, got)
+	}
+}
+
+func TestStreamLogsFollowUntil(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeLog(t, filepath.Join(dir, "0.log"), "000000001 stdout before", "000000003 stdout after")
+
+	client := &fakeRuntimeClient{
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c0": {Status: &pb.ContainerStatus{State: pb.ContainerState_CONTAINER_RUNNING, LogPath: filepath.Join(dir, "0.log")}},
+		},
+	}
+	target := &logTarget{id: "c0", podID: "p1", name: "web", logPath: filepath.Join(dir, "0.log")}
+	until := time.Date(2021, 3, 1, 12, 0, 0, 2, time.UTC)
+	discover := func() ([]*logTarget, error) { return []*logTarget{target}, nil }
+
+	// Following ends although the container is still running and new
+	// containers could show up.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var out bytes.Buffer
+	opts := logStreamOptions{follow: true, followRestarts: true, tail: -1, until: &until}
+	if err := streamLogs(ctx, client, []*logTarget{target}, discover, opts, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("expected following to end after until")
+	}
+	if out.String() != "before\n" {
+		t.Errorf("expected %q, but got %q", "before\n", out.String())
+	}
+}
@@ -156,9 +156,11 @@ func main() {
 		containerStatusCommand,
 		imageStatusCommand,
 		imageFsInfoCommand,
+		diskUsageCommand,
 		podStatusCommand,
 		logsCommand,
 		runtimePortForwardCommand,
+		replaySessionCommand,
 		listContainersCommand,
 		pullImageCommand,
 		runContainerCommand,
@@ -174,6 +176,10 @@ func main() {
 		updateContainerCommand,
 		configCommand,
 		statsCommand,
+		podStatsCommand,
+		summaryCommand,
+		uiCommand,
+		exporterCommand,
 		completionCommand,
 	}
 
@@ -0,0 +1,336 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+// podStatsSortKeys are the well-known --sort-by keys of pod stats.
+var podStatsSortKeys = output.SortKeys{
+	"name":      ".name",
+	"namespace": ".namespace",
+	"cpu-time":  ".cpu.usageCoreNanoSeconds",
+	"memory":    ".memory.workingSetBytes",
+	"size":      ".writableLayer.usedBytes",
+}
+
+var podStatsCommand = &cli.Command{
+	Name:                   "statsp",
+	Usage:                  "List pod(s) resource usage statistics",
+	UseShortOptionHandling: true,
+	ArgsUsage:              "[POD ID]",
+	Flags: append([]cli.Flag{
+		&cli.BoolFlag{
+			Name:    "all",
+			Aliases: []string{"a"},
+			Usage:   "Show all containers (default shows just running)",
+		},
+		&cli.StringFlag{
+			Name:  "id",
+			Value: "",
+			Usage: "Filter by pod id",
+		},
+		&cli.StringSliceFlag{
+			Name:  "label",
+			Usage: "Filter containers by label selector, e.g. key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key",
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   output.Usage,
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
+		},
+		&cli.IntFlag{
+			Name:    "seconds",
+			Aliases: []string{"s"},
+			Value:   1,
+			Usage:   "Sample duration for CPU usage in seconds",
+		},
+		&cli.BoolFlag{
+			Name:    "watch",
+			Aliases: []string{"w"},
+			Usage:   "Watch pod resources",
+		},
+		&cli.BoolFlag{
+			Name:    "containers",
+			Aliases: []string{"c"},
+			Usage:   "Show the containers of every pod on their own rows, or in the containers field of structured output",
+		},
+	}, sortFlags(podStatsSortKeys)...),
+	Action: func(context *cli.Context) error {
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		id := context.String("id")
+		if id == "" && context.NArg() > 0 {
+			id = context.Args().Get(0)
+		}
+
+		opts := statsOptions{
+			all:        context.Bool("all"),
+			podID:      id,
+			sample:     time.Duration(context.Int("seconds")) * time.Second,
+			output:     context.String("output"),
+			template:   context.String("template"),
+			watch:      context.Bool("watch"),
+			sortBy:     context.String("sort-by"),
+			reverse:    context.Bool("reverse"),
+			containers: context.Bool("containers"),
+		}
+		opts.labels, opts.labelSelector, err = parseLabelStringSlice(context.StringSlice("label"))
+		if err != nil {
+			return err
+		}
+
+		if err = PodStats(runtimeClient, opts); err != nil {
+			return errors.Wrap(err, "get pod stats")
+		}
+		return nil
+	},
+}
+
+// podCPUStats is the CPU usage of all containers of a pod.
+type podCPUStats struct {
+	UsageCoreNanoSeconds uint64 `json:"usageCoreNanoSeconds"`
+}
+
+// podMemoryStats is the memory usage of all containers of a pod.
+type podMemoryStats struct {
+	WorkingSetBytes uint64 `json:"workingSetBytes"`
+}
+
+// podFilesystemStats is the writable layer usage of all containers of a pod.
+type podFilesystemStats struct {
+	UsedBytes  uint64 `json:"usedBytes"`
+	InodesUsed uint64 `json:"inodesUsed"`
+}
+
+// podStats are the resource usage totals of the containers of a pod sandbox.
+type podStats struct {
+	ID            string             `json:"id"`
+	Name          string             `json:"name"`
+	Namespace     string             `json:"namespace"`
+	CPU           podCPUStats        `json:"cpu"`
+	Memory        podMemoryStats     `json:"memory"`
+	WritableLayer podFilesystemStats `json:"writableLayer"`
+	// Containers are the stats of the containers of the pod, which are only
+	// shown with --containers.
+	Containers []json.RawMessage `json:"containers,omitempty"`
+
+	cpuPerc float64
+	rows    []statsRow
+}
+
+// podStatsList is the structured output of statsp.
+type podStatsList struct {
+	Stats []*podStats `json:"stats"`
+}
+
+// add adds the sample of a container of the pod.
+func (p *podStats) add(row statsRow) error {
+	s := row.stats
+	p.CPU.UsageCoreNanoSeconds += s.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+	p.Memory.WorkingSetBytes += s.GetMemory().GetWorkingSetBytes().GetValue()
+	p.WritableLayer.UsedBytes += s.GetWritableLayer().GetUsedBytes().GetValue()
+	p.WritableLayer.InodesUsed += s.GetWritableLayer().GetInodesUsed().GetValue()
+	p.cpuPerc += row.cpuPerc
+
+	data, err := output.Marshal(s)
+	if err != nil {
+		return err
+	}
+	p.Containers = append(p.Containers, data)
+	p.rows = append(p.rows, row)
+	return nil
+}
+
+// PodStats sends a ListContainerStatsRequest to the server, and sums up the
+// returned container stats by pod sandbox.
+func PodStats(client pb.RuntimeServiceClient, opts statsOptions) error {
+	printer, err := output.NewPrinter(opts.output, opts.template)
+	if err != nil {
+		return err
+	}
+	sorter, err := output.NewSorter(opts.sortBy, opts.reverse, podStatsSortKeys)
+	if err != nil {
+		return err
+	}
+	filter := &pb.ContainerStatsFilter{}
+	if opts.podID != "" {
+		filter.PodSandboxId = opts.podID
+	}
+	if opts.labels != nil {
+		filter.LabelSelector = opts.labels
+	}
+	request := &pb.ListContainerStatsRequest{
+		Filter: filter,
+	}
+
+	display := newTableDisplay(20, 1, 3, ' ', 0)
+	return runStats(opts.watch, func(ctx context.Context) error {
+		return displayPodStats(ctx, client, request, display, printer, sorter, opts)
+	})
+}
+
+// groupPodStats sums up the container samples by pod sandbox. Container stats
+// do not refer to their pod sandbox, which is why the containers and pod
+// sandboxes are listed as well.
+func groupPodStats(ctx context.Context, client pb.RuntimeServiceClient, podID string, rows []statsRow) ([]*podStats, error) {
+	request := &pb.ListContainersRequest{
+		Filter: &pb.ContainerFilter{PodSandboxId: podID},
+	}
+	logrus.Debugf("ListContainerRequest: %v", request)
+	r, err := client.ListContainers(ctx, request)
+	logrus.Debugf("ListContainerResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	podIDs := make(map[string]string, len(r.GetContainers()))
+	for _, c := range r.GetContainers() {
+		podIDs[c.Id] = c.PodSandboxId
+	}
+	sandboxes := getSandboxesMetadata(client)
+
+	var pods []*podStats
+	podsByID := make(map[string]*podStats)
+	for _, row := range rows {
+		podID, ok := podIDs[row.stats.GetAttributes().GetId()]
+		if !ok {
+			// Skip containers removed in the meantime
+			continue
+		}
+		pod, ok := podsByID[podID]
+		if !ok {
+			pod = &podStats{
+				ID:        podID,
+				Name:      sandboxes[podID].GetName(),
+				Namespace: sandboxes[podID].GetNamespace(),
+			}
+			podsByID[podID] = pod
+			pods = append(pods, pod)
+		}
+		if err := pod.add(row); err != nil {
+			return nil, err
+		}
+	}
+	return pods, nil
+}
+
+// listStatsRows lists the container stats once, without sampling their CPU
+// usage, for the structured output. Non-running containers are skipped unless
+// opts.all is set.
+func listStatsRows(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, opts statsOptions) ([]statsRow, error) {
+	r, err := getContainerStats(ctx, client, request, opts.labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	var rows []statsRow
+	for _, s := range r.GetStats() {
+		if !opts.all && !hasUsage(s) {
+			continue
+		}
+		rows = append(rows, statsRow{stats: s})
+	}
+	return rows, nil
+}
+
+func displayPodStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, display *display, printer *output.Printer, sorter *output.Sorter, opts statsOptions) error {
+	var (
+		rows []statsRow
+		err  error
+	)
+	if printer.Structured() {
+		rows, err = listStatsRows(ctx, client, request, opts)
+	} else {
+		rows, err = sampleStats(ctx, client, request, nil, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	pods, err := groupPodStats(ctx, client, opts.podID, rows)
+	if err != nil {
+		return err
+	}
+
+	if err := sorter.Sort(pods); err != nil {
+		return err
+	}
+
+	if printer.Structured() {
+		items := make([]output.Item, 0, len(pods))
+		for _, pod := range pods {
+			if !opts.containers {
+				pod.Containers = nil
+			}
+			items = append(items, output.Item{Name: pod.ID, Object: pod})
+		}
+		return printer.PrintList(os.Stdout, &podStatsList{Stats: pods}, items)
+	}
+
+	display.AddRow([]string{columnPodID, columnPod, columnNamespace, columnCPU, columnMemory, columnDisk, columnInodes})
+	for _, pod := range pods {
+		display.AddRow([]string{
+			getTruncatedID(pod.ID, ""),
+			pod.Name,
+			pod.Namespace,
+			fmt.Sprintf("%.2f", pod.cpuPerc),
+			units.HumanSize(float64(pod.Memory.WorkingSetBytes)),
+			units.HumanSize(float64(pod.WritableLayer.UsedBytes)),
+			fmt.Sprintf("%d", pod.WritableLayer.InodesUsed),
+		})
+		if !opts.containers {
+			continue
+		}
+		for _, row := range pod.rows {
+			s := row.stats
+			display.AddRow([]string{
+				"  " + getTruncatedID(s.GetAttributes().GetId(), ""),
+				s.GetAttributes().GetMetadata().GetName(),
+				"",
+				fmt.Sprintf("%.2f", row.cpuPerc),
+				units.HumanSize(float64(s.GetMemory().GetWorkingSetBytes().GetValue())),
+				units.HumanSize(float64(s.GetWritableLayer().GetUsedBytes().GetValue())),
+				fmt.Sprintf("%d", s.GetWritableLayer().GetInodesUsed().GetValue()),
+			})
+		}
+	}
+	display.ClearScreen()
+	display.Flush()
+
+	return nil
+}
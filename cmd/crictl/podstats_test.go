@@ -0,0 +1,99 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func containerStats(id string, cpu, mem, disk uint64) *pb.ContainerStats {
+	return &pb.ContainerStats{
+		Attributes:    &pb.ContainerAttributes{Id: id},
+		Cpu:           &pb.CpuUsage{UsageCoreNanoSeconds: &pb.UInt64Value{Value: cpu}},
+		Memory:        &pb.MemoryUsage{WorkingSetBytes: &pb.UInt64Value{Value: mem}},
+		WritableLayer: &pb.FilesystemUsage{UsedBytes: &pb.UInt64Value{Value: disk}, InodesUsed: &pb.UInt64Value{Value: 1}},
+	}
+}
+
+func TestGroupPodStats(t *testing.T) {
+	client := &fakeRuntimeClient{
+		containers: []*pb.Container{
+			{Id: "c1", PodSandboxId: "p1"},
+			{Id: "c2", PodSandboxId: "p1"},
+			{Id: "c3", PodSandboxId: "p2"},
+		},
+		sandboxes: []*pb.PodSandbox{
+			{Id: "p1", Metadata: &pb.PodSandboxMetadata{Name: "web", Namespace: "default"}},
+		},
+	}
+	rows := []statsRow{
+		{stats: containerStats("c1", 10, 100, 1000), cpuPerc: 1.5},
+		{stats: containerStats("c3", 30, 300, 3000), cpuPerc: 3},
+		{stats: containerStats("c2", 20, 200, 2000), cpuPerc: 2.5},
+		{stats: containerStats("removed", 40, 400, 4000)},
+	}
+
+	pods, err := groupPodStats(context.Background(), client, "", rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 2 {
+		t.Fatalf("expected 2 pods, but got %d", len(pods))
+	}
+	p1 := pods[0]
+	if p1.ID != "p1" || p1.Name != "web" || p1.Namespace != "default" {
+		t.Errorf("unexpected pod %+v", p1)
+	}
+	if p1.CPU.UsageCoreNanoSeconds != 30 || p1.Memory.WorkingSetBytes != 300 ||
+		p1.WritableLayer.UsedBytes != 3000 || p1.WritableLayer.InodesUsed != 2 || p1.cpuPerc != 4 {
+		t.Errorf("unexpected totals %+v, cpu %.2f%%", p1, p1.cpuPerc)
+	}
+	if len(p1.Containers) != 2 || len(p1.rows) != 2 {
+		t.Errorf("expected 2 containers in pod p1, but got %d", len(p1.Containers))
+	}
+	if pods[1].ID != "p2" || pods[1].Name != "" {
+		t.Errorf("expected pod p2 without metadata, but got %+v", pods[1])
+	}
+}
+
+func TestListStatsRows(t *testing.T) {
+	client := &fakeRuntimeClient{
+		stats: []*pb.ContainerStats{
+			containerStats("running", 10, 100, 1000),
+			containerStats("exited", 0, 0, 1000),
+		},
+	}
+	request := &pb.ListContainerStatsRequest{}
+	for _, tc := range []struct {
+		all      bool
+		expected int
+	}{
+		{all: false, expected: 1},
+		{all: true, expected: 2},
+	} {
+		rows, err := listStatsRows(context.Background(), client, request, statsOptions{all: tc.all})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != tc.expected {
+			t.Errorf("all=%v: expected %d containers, but got %d", tc.all, tc.expected, len(rows))
+		}
+	}
+}
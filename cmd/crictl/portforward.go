@@ -17,28 +17,67 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/net/context"
-	restclient "k8s.io/client-go/rest"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/wsstream"
+)
+
+const (
+	// portForwardMinBackoff and portForwardMaxBackoff bound the delay
+	// before reconnecting a lost port forwarding session.
+	portForwardMinBackoff = time.Second
+	portForwardMaxBackoff = 30 * time.Second
 )
 
+// spdyUpgradeError is returned if the streaming server does not upgrade a
+// port forwarding connection to SPDY.
+type spdyUpgradeError struct {
+	err error
+}
+
+func (e *spdyUpgradeError) Error() string {
+	return fmt.Sprintf("unable to upgrade the connection to SPDY: %v", e.err)
+}
+
 var runtimePortForwardCommand = &cli.Command{
 	Name:      "port-forward",
 	Usage:     "Forward local port to a pod",
-	ArgsUsage: "POD-ID [LOCAL_PORT:]REMOTE_PORT",
+	ArgsUsage: "POD [LOCAL_PORT:]REMOTE_PORT... | --socks [HOST]:PORT POD",
+	Description: "POD is a pod ID, ID prefix or name. REMOTE_PORT is a port number or the name of a port of a container of the pod. " +
+		"Without ports, the ports of the port mappings of the pod, or else all named ports of its containers, are forwarded. " +
+		"With --socks, a SOCKS5 server forwards the connections of its clients to any port of the pod.",
+	Flags: append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "address",
+			Value: cli.NewStringSlice("localhost"),
+			Usage: "Addresses to listen on, comma separated or repeated. localhost listens on 127.0.0.1 and ::1",
+		},
+		&cli.StringFlag{
+			Name:  "socks",
+			Usage: "Serve SOCKS5 on [HOST]:PORT instead of forwarding fixed ports. Clients can connect to the ports of localhost and of the IPs of the pod. Without a host, --address is listened on",
+		},
+		transportFlag(),
+	}, streamingTLSFlags()...),
 	Action: func(context *cli.Context) error {
 		args := context.Args().Slice()
-		if len(args) < 2 {
+		if len(args) < 1 {
 			return cli.ShowSubcommandHelp(context)
 		}
 
@@ -49,8 +88,16 @@ var runtimePortForwardCommand = &cli.Command{
 		defer closeConnection(context, runtimeConn)
 
 		var opts = portforwardOptions{
-			id:    args[0],
-			ports: args[1:],
+			id:        args[0],
+			ports:     args[1:],
+			transport: context.String("transport"),
+			socks:     context.String("socks"),
+		}
+		for _, address := range context.StringSlice("address") {
+			opts.addresses = append(opts.addresses, strings.Split(address, ",")...)
+		}
+		if opts.tlsConfig, err = streamingTLSConfig(context); err != nil {
+			return err
 		}
 		err = PortForward(runtimeClient, opts)
 		if err != nil {
@@ -68,43 +115,330 @@ func PortForward(client pb.RuntimeServiceClient, opts portforwardOptions) error
 		return fmt.Errorf("ID cannot be empty")
 
 	}
-	request := &pb.PortForwardRequest{
-		PodSandboxId: opts.id,
+	switch opts.transport {
+	case transportSPDY, transportWebSocket, transportAuto:
+	default:
+		return fmt.Errorf("unsupported transport %q, must be one of: auto|websocket|spdy", opts.transport)
 	}
-	logrus.Debugf("PortForwardRequest: %v", request)
-	r, err := client.PortForward(context.Background(), request)
-	logrus.Debugf("PortForwardResponse; %v", r)
+	ctx := context.Background()
+	id, err := resolvePodSandbox(ctx, client, opts.id)
 	if err != nil {
 		return err
 	}
-	portforwardURL := r.Url
-
-	URL, err := url.Parse(portforwardURL)
-	if err != nil {
+	opts.id = id
+	if len(opts.addresses) == 0 {
+		opts.addresses = []string{"localhost"}
+	}
+	if opts.socks != "" {
+		if len(opts.ports) > 0 {
+			return fmt.Errorf("ports cannot be combined with --socks")
+		}
+		if !strings.Contains(opts.socks, ":") {
+			opts.socks = ":" + opts.socks
+		}
+		return forwardSOCKS(client, opts)
+	}
+	if opts.ports, err = resolveForwardedPorts(ctx, client, id, opts.ports); err != nil {
 		return err
 	}
+	logrus.Debugf("Ports to forword: %v", opts.ports)
+	if opts.transport == transportWebSocket {
+		return forwardPortsWebSocket(client, opts)
+	}
+
+	stop := SetupInterruptSignalHandler()
+	session := newPortForwardSession(ctx, client, opts)
+	for {
+		reconnect, err := session.step(stop)
+		// The session only ends with an upgrade error before it connected.
+		if _, ok := err.(*spdyUpgradeError); ok && opts.transport == transportAuto {
+			logrus.Debugf("Falling back to WebSocket: %v", err)
+			return forwardPortsWebSocket(client, opts)
+		}
+		if !reconnect {
+			return err
+		}
+	}
+}
 
-	if URL.Host == "" {
-		URL.Host = kubeletURLHost
+// portForwardSession forwards ports over SPDY and reconnects when the
+// session is lost, until interrupted or the pod is gone.
+type portForwardSession struct {
+	opts portforwardOptions
+	// forward forwards the ports until the session is lost, see
+	// forwardPortsSPDY.
+	forward func(opts portforwardOptions, stop <-chan struct{}) ([]string, error)
+	// ready returns an error if the pod is no longer ready.
+	ready func() error
+	// after waits before reconnecting, see time.After.
+	after func(d time.Duration) <-chan time.Time
+
+	connected bool
+	backoff   time.Duration
+}
+
+func newPortForwardSession(ctx context.Context, client pb.RuntimeServiceClient, opts portforwardOptions) *portForwardSession {
+	return &portForwardSession{
+		opts: opts,
+		forward: func(opts portforwardOptions, stop <-chan struct{}) ([]string, error) {
+			return forwardPortsSPDY(client, opts, stop)
+		},
+		ready: func() error {
+			return checkPodSandboxReady(ctx, client, opts.id)
+		},
+		after:   time.After,
+		backoff: portForwardMinBackoff,
 	}
+}
 
-	if URL.Scheme == "" {
-		URL.Scheme = kubeletURLSchema
+// step forwards the ports until the session is lost and waits before the
+// next one. It returns false if forwarding is over, with the error of the
+// session if it never connected or with the reason the pod is not ready.
+func (s *portForwardSession) step(stop <-chan struct{}) (bool, error) {
+	ports, err := s.forward(s.opts, stop)
+	select {
+	case <-stop:
+		return false, nil
+	default:
+	}
+	if ports != nil {
+		// Keep random local ports when reconnecting.
+		s.opts.ports = ports
+		s.connected, s.backoff = true, portForwardMinBackoff
+	} else if !s.connected {
+		return false, err
+	}
+	if err := s.ready(); err != nil {
+		return false, err
+	}
+	if err != nil {
+		logrus.Warnf("Unable to forward ports of pod %s, reconnecting in %v: %v", s.opts.id, s.backoff, err)
+	} else {
+		logrus.Warnf("Lost connection to pod %s, reconnecting in %v", s.opts.id, s.backoff)
+	}
+	select {
+	case <-stop:
+		return false, nil
+	case <-s.after(s.backoff):
+	}
+	if ports == nil {
+		if s.backoff *= 2; s.backoff > portForwardMaxBackoff {
+			s.backoff = portForwardMaxBackoff
+		}
 	}
+	return true, nil
+}
 
-	logrus.Debugf("PortForward URL: %v", URL)
-	transport, upgrader, err := spdy.RoundTripperFor(&restclient.Config{})
+// forwardPortsSPDY forwards the ports over a SPDY connection until it is lost
+// or stop is closed. It returns the forwarded ports with their local port
+// numbers if the listeners were set up.
+func forwardPortsSPDY(client pb.RuntimeServiceClient, opts portforwardOptions, stop <-chan struct{}) ([]string, error) {
+	// Upgrade the connection up front, so that the auto transport can fall
+	// back to WebSocket before listening.
+	conn, err := dialPortForwardSPDY(client, opts.id, opts.tlsConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", URL)
+	dialer := &upgradedDialer{conn: conn, protocol: portforward.PortForwardProtocolV1Name}
 
 	readyChan := make(chan struct{})
+	pf, err := portforward.NewOnAddresses(dialer, opts.addresses, opts.ports, stop, readyChan, os.Stdout, os.Stderr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	err = pf.ForwardPorts()
+	forwarded, portsErr := pf.GetPorts()
+	if portsErr != nil {
+		return nil, err
+	}
+	ports := make([]string, 0, len(forwarded))
+	for _, p := range forwarded {
+		ports = append(ports, fmt.Sprintf("%d:%d", p.Local, p.Remote))
+	}
+	return ports, err
+}
 
-	logrus.Debugf("Ports to forword: %v", opts.ports)
-	pf, err := portforward.New(dialer, opts.ports, SetupInterruptSignalHandler(), readyChan, os.Stdout, os.Stderr)
+// dialPortForwardSPDY sends a PortForwardRequest and upgrades the connection
+// to its URL to SPDY. Over SPDY, the streams of all ports share the
+// connection.
+func dialPortForwardSPDY(client pb.RuntimeServiceClient, id string, tlsConfig *tls.Config) (httpstream.Connection, error) {
+	URL, err := portForwardURL(client, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add(httpstream.HeaderProtocolVersion, portforward.PortForwardProtocolV1Name)
+	upgrader := spdystream.NewRoundTripper(tlsConfig, true, false)
+	resp, err := (&http.Client{Transport: upgrader}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	conn, err := upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, &spdyUpgradeError{err: err}
+	}
+	return conn, nil
+}
+
+// portForwardURL sends a PortForwardRequest of the ports and returns its URL.
+func portForwardURL(client pb.RuntimeServiceClient, id string, ports []int32) (*url.URL, error) {
+	request := &pb.PortForwardRequest{
+		PodSandboxId: id,
+		Port:         ports,
+	}
+	logrus.Debugf("PortForwardRequest: %v", request)
+	r, err := client.PortForward(context.Background(), request)
+	logrus.Debugf("PortForwardResponse; %v", r)
+	if err != nil {
+		return nil, err
+	}
+	URL, err := parseStreamURL(r.Url)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("PortForward URL: %v", URL)
+	return URL, nil
+}
+
+// upgradedDialer returns a connection which has already been upgraded.
+type upgradedDialer struct {
+	conn     httpstream.Connection
+	protocol string
+}
+
+func (d *upgradedDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	return d.conn, d.protocol, nil
+}
+
+// forwardedPort is a local port forwarded to a port of the pod.
+type forwardedPort struct {
+	local  uint16
+	remote uint16
+}
+
+// parseForwardedPorts parses ports of the form [LOCAL_PORT:]REMOTE_PORT. An
+// empty local port selects a random one.
+func parseForwardedPorts(specs []string) ([]forwardedPort, error) {
+	var ports []forwardedPort
+	for _, spec := range specs {
+		local, remote := spec, spec
+		if i := strings.Index(spec, ":"); i >= 0 {
+			local, remote = spec[:i], spec[i+1:]
+		}
+		var (
+			port forwardedPort
+			err  error
+		)
+		if port.remote, err = parsePort(remote); err != nil || port.remote == 0 {
+			return nil, fmt.Errorf("invalid remote port in %q", spec)
+		}
+		if local != "" {
+			if port.local, err = parsePort(local); err != nil {
+				return nil, fmt.Errorf("invalid local port in %q", spec)
+			}
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func parsePort(port string) (uint16, error) {
+	p, err := strconv.ParseUint(port, 10, 16)
+	return uint16(p), err
+}
+
+// forwardPortsWebSocket forwards the ports until interrupted. Every local
+// connection is forwarded over a WebSocket connection of its own.
+func forwardPortsWebSocket(client pb.RuntimeServiceClient, opts portforwardOptions) error {
+	ports, err := parseForwardedPorts(opts.ports)
 	if err != nil {
 		return err
 	}
-	return pf.ForwardPorts()
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	hosts, err := listenHosts(opts.addresses)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		listening := false
+		for _, host := range hosts {
+			l, err := net.Listen("tcp", net.JoinHostPort(host.ip, strconv.Itoa(int(port.local))))
+			if err != nil {
+				if !host.optional {
+					return err
+				}
+				logrus.Debugf("Unable to listen on %s: %v", host.ip, err)
+				continue
+			}
+			listening = true
+			listeners = append(listeners, l)
+			// Listen on the same random port on all hosts.
+			port.local = uint16(l.Addr().(*net.TCPAddr).Port)
+			fmt.Printf("Forwarding from %s -> %d\n", l.Addr(), port.remote)
+			go acceptForwardedConnections(client, opts.id, opts.tlsConfig, l, port.remote)
+		}
+		if !listening {
+			return fmt.Errorf("unable to listen on port %d", port.local)
+		}
+	}
+	<-SetupInterruptSignalHandler()
+	return nil
+}
+
+// listenHost is an IP address to listen on.
+type listenHost struct {
+	ip string
+	// optional hosts are skipped if they cannot be listened on.
+	optional bool
+}
+
+// listenHosts returns the IP addresses of the addresses to listen on, where
+// localhost stands for the loopback addresses of the available IP versions.
+func listenHosts(addresses []string) ([]listenHost, error) {
+	var hosts []listenHost
+	for _, address := range addresses {
+		if address == "localhost" {
+			hosts = append(hosts, listenHost{ip: "127.0.0.1", optional: true}, listenHost{ip: "::1", optional: true})
+			continue
+		}
+		if net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("%s is not a valid IP", address)
+		}
+		hosts = append(hosts, listenHost{ip: address})
+	}
+	return hosts, nil
+}
+
+// acceptForwardedConnections forwards the connections of the listener to the
+// remote port until it is closed.
+func acceptForwardedConnections(client pb.RuntimeServiceClient, id string, tlsConfig *tls.Config, l net.Listener, remote uint16) {
+	local := l.Addr().(*net.TCPAddr).Port
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			fmt.Printf("Handling connection for %d\n", local)
+			URL, err := portForwardURL(client, id, []int32{int32(remote)})
+			if err == nil {
+				err = wsstream.ForwardPort(tlsConfig, URL, remote, conn)
+			} else {
+				conn.Close()
+			}
+			if err != nil {
+				logrus.Errorf("Forwarding port %d: %v", remote, err)
+			}
+		}()
+	}
 }
@@ -0,0 +1,299 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/socks5"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestParseForwardedPorts(t *testing.T) {
+	ports, err := parseForwardedPorts([]string{"8080", "8081:80", ":443"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []forwardedPort{{local: 8080, remote: 8080}, {local: 8081, remote: 80}, {local: 0, remote: 443}}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %+v, but got %+v", expected, ports)
+	}
+	for _, spec := range []string{"", "http", "8080:", "8080:0", "70000", "a:80"} {
+		if _, err := parseForwardedPorts([]string{spec}); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
+
+func TestResolvePodSandbox(t *testing.T) {
+	client := &fakeRuntimeClient{
+		sandboxes: []*pb.PodSandbox{
+			{Id: "abc1", Metadata: &pb.PodSandboxMetadata{Name: "web"}, State: pb.PodSandboxState_SANDBOX_NOTREADY},
+			{Id: "abc2", Metadata: &pb.PodSandboxMetadata{Name: "web"}, State: pb.PodSandboxState_SANDBOX_READY},
+			{Id: "def1", Metadata: &pb.PodSandboxMetadata{Name: "db"}, State: pb.PodSandboxState_SANDBOX_READY},
+			{Id: "def2", Metadata: &pb.PodSandboxMetadata{Name: "db"}, State: pb.PodSandboxState_SANDBOX_READY},
+		},
+	}
+	for pod, expected := range map[string]string{"web": "abc2", "abc": "abc2", "abc1": "abc1", "def2": "def2"} {
+		id, err := resolvePodSandbox(context.Background(), client, pod)
+		if err != nil {
+			t.Errorf("%s: %v", pod, err)
+		} else if id != expected {
+			t.Errorf("%s: expected pod %s, but got %s", pod, expected, id)
+		}
+	}
+	for _, pod := range []string{"db", "def", "cache"} {
+		if _, err := resolvePodSandbox(context.Background(), client, pod); err == nil {
+			t.Errorf("expected an error for %q", pod)
+		}
+	}
+}
+
+func TestResolveForwardedPorts(t *testing.T) {
+	client := &fakeRuntimeClient{
+		containers: []*pb.Container{
+			{Id: "c1", PodSandboxId: "p1", Annotations: map[string]string{
+				containerPortsAnnotation: `[{"name":"http","containerPort":8080,"protocol":"TCP"},{"name":"dns","containerPort":53,"protocol":"UDP"}]`,
+			}},
+			{Id: "c2", PodSandboxId: "p1", Annotations: map[string]string{
+				containerPortsAnnotation: `[{"name":"metrics","containerPort":9090}]`,
+			}},
+			{Id: "c3", PodSandboxId: "p2", Annotations: map[string]string{
+				containerPortsAnnotation: `[{"name":"grpc","containerPort":50051}]`,
+			}},
+		},
+		podStatus: map[string]*pb.PodSandboxStatusResponse{
+			"p1": {Info: map[string]string{"info": `{"config":{"port_mappings":[{"container_port":80,"host_port":8080},{"protocol":1,"container_port":53},{"container_port":443}]}}`}},
+			"p2": {},
+		},
+	}
+
+	ports, err := resolveForwardedPorts(context.Background(), client, "p1", []string{"8081", "9000:http", ":metrics", "http"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"8081", "9000:8080", ":9090", "8080:8080"}
+	if !reflect.DeepEqual(ports, expected) {
+		t.Errorf("expected %v, but got %v", expected, ports)
+	}
+	for _, name := range []string{"dns", "grpc", "https"} {
+		if _, err := resolveForwardedPorts(context.Background(), client, "p1", []string{name}); err == nil {
+			t.Errorf("expected an error for %q", name)
+		}
+	}
+
+	// Without ports, the port mappings or else the named ports are
+	// forwarded.
+	for id, expected := range map[string][]string{"p1": {"80", "443"}, "p2": {"50051"}} {
+		ports, err := resolveForwardedPorts(context.Background(), client, id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ports, expected) {
+			t.Errorf("%s: expected %v, but got %v", id, expected, ports)
+		}
+	}
+}
+
+func TestListenHosts(t *testing.T) {
+	hosts, err := listenHosts([]string{"localhost", "0.0.0.0", "fd00::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []listenHost{{"127.0.0.1", true}, {"::1", true}, {"0.0.0.0", false}, {"fd00::1", false}}
+	if !reflect.DeepEqual(hosts, expected) {
+		t.Errorf("expected %v, but got %v", expected, hosts)
+	}
+	if _, err := listenHosts([]string{"example.com"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
+
+// sessionResult is the result of a port forwarding session.
+type sessionResult struct {
+	ports []string
+	err   error
+}
+
+// fakePortForwardSession returns a session which forwards ports with the
+// results of sessions in turn and records the ports of every session and the
+// delays before reconnecting.
+func fakePortForwardSession(ports []string, sessions []sessionResult) (*portForwardSession, *[][]string, *[]time.Duration) {
+	var (
+		forwarded [][]string
+		delays    []time.Duration
+	)
+	session := &portForwardSession{
+		opts: portforwardOptions{id: "p1", ports: ports},
+		forward: func(opts portforwardOptions, stop <-chan struct{}) ([]string, error) {
+			forwarded = append(forwarded, opts.ports)
+			r := sessions[0]
+			sessions = sessions[1:]
+			return r.ports, r.err
+		},
+		ready: func() error { return nil },
+		after: func(d time.Duration) <-chan time.Time {
+			delays = append(delays, d)
+			c := make(chan time.Time, 1)
+			c <- time.Time{}
+			return c
+		},
+		backoff: portForwardMinBackoff,
+	}
+	return session, &forwarded, &delays
+}
+
+func TestPortForwardSessionReconnect(t *testing.T) {
+	lost := errors.New("connection lost")
+	session, forwarded, delays := fakePortForwardSession([]string{":80"}, []sessionResult{
+		{ports: []string{"41234:80"}},
+		{err: lost},
+		{err: lost},
+		{err: lost},
+		{ports: []string{"41234:80"}, err: lost},
+		{err: lost},
+	})
+	stop := make(chan struct{})
+	for i := 0; i < 6; i++ {
+		reconnect, err := session.step(stop)
+		if !reconnect || err != nil {
+			t.Fatalf("session %d: expected to reconnect, but got %v, %v", i, reconnect, err)
+		}
+	}
+
+	// The random local port of the first session is kept.
+	expectedPorts := [][]string{{":80"}, {"41234:80"}, {"41234:80"}, {"41234:80"}, {"41234:80"}, {"41234:80"}}
+	if !reflect.DeepEqual(*forwarded, expectedPorts) {
+		t.Errorf("expected sessions of %v, but got %v", expectedPorts, *forwarded)
+	}
+	// The backoff grows while reconnecting fails and is reset once a session
+	// connects.
+	expectedDelays := []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, time.Second, time.Second}
+	if !reflect.DeepEqual(*delays, expectedDelays) {
+		t.Errorf("expected delays of %v, but got %v", expectedDelays, *delays)
+	}
+}
+
+func TestPortForwardSessionMaxBackoff(t *testing.T) {
+	results := []sessionResult{{ports: []string{"8080:80"}}}
+	for i := 0; i < 8; i++ {
+		results = append(results, sessionResult{err: errors.New("refused")})
+	}
+	session, _, delays := fakePortForwardSession([]string{"8080:80"}, results)
+	for range results {
+		if _, err := session.step(make(chan struct{})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last := (*delays)[len(*delays)-1]; last != portForwardMaxBackoff {
+		t.Errorf("expected the backoff to be capped at %v, but got %v", portForwardMaxBackoff, last)
+	}
+}
+
+func TestPortForwardSessionEnd(t *testing.T) {
+	refused := &spdyUpgradeError{err: errors.New("refused")}
+
+	// A session which never connected is not retried.
+	session, _, _ := fakePortForwardSession([]string{"80"}, []sessionResult{{err: refused}})
+	if reconnect, err := session.step(make(chan struct{})); reconnect || err != refused {
+		t.Errorf("expected the first session to end with %v, but got %v, %v", refused, reconnect, err)
+	}
+
+	// Reconnecting stops once the pod is not ready.
+	notReady := errors.New("pod is not ready")
+	session, _, _ = fakePortForwardSession([]string{"80"}, []sessionResult{{ports: []string{"80:80"}}, {err: refused}})
+	if reconnect, err := session.step(make(chan struct{})); !reconnect || err != nil {
+		t.Fatalf("expected to reconnect, but got %v, %v", reconnect, err)
+	}
+	session.ready = func() error { return notReady }
+	if reconnect, err := session.step(make(chan struct{})); reconnect || err != notReady {
+		t.Errorf("expected the session to end with %v, but got %v, %v", notReady, reconnect, err)
+	}
+
+	// An interrupt ends the session without an error.
+	session, _, _ = fakePortForwardSession([]string{"80"}, []sessionResult{{ports: []string{"80:80"}}})
+	stop := make(chan struct{})
+	close(stop)
+	if reconnect, err := session.step(stop); reconnect || err != nil {
+		t.Errorf("expected an interrupted session to end, but got %v, %v", reconnect, err)
+	}
+}
+
+func TestSOCKSAllowedHosts(t *testing.T) {
+	client := &fakeRuntimeClient{
+		podStatus: map[string]*pb.PodSandboxStatusResponse{
+			"p1": {Status: &pb.PodSandboxStatus{Network: &pb.PodSandboxNetworkStatus{
+				Ip:            "10.0.0.5",
+				AdditionalIps: []*pb.PodIP{{Ip: "fd00::0005"}},
+			}}},
+		},
+	}
+	ips, err := podSandboxIPs(context.Background(), client, "p1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &socksForwarder{podIPs: ips}
+	for host, expected := range map[string]bool{
+		"localhost":   true,
+		"127.0.0.1":   true,
+		"127.0.0.53":  true,
+		"::1":         true,
+		"10.0.0.5":    true,
+		"fd00::5":     true,
+		"10.0.0.6":    false,
+		"example.com": false,
+		"":            false,
+	} {
+		if allowed := f.allowed(host); allowed != expected {
+			t.Errorf("%q: expected allowed %v, but got %v", host, expected, allowed)
+		}
+	}
+}
+
+func TestSOCKSWebSocketDialFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no upgrade", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	f := &socksForwarder{
+		client:    &fakeRuntimeClient{forwardURL: ts.URL},
+		opts:      portforwardOptions{id: "p1"},
+		webSocket: true,
+	}
+	local, remote := net.Pipe()
+	reply := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(local)
+		reply <- data
+	}()
+	if err := f.forwardWebSocket(80, remote); err == nil {
+		t.Error("expected the failed dial to be returned")
+	}
+	// The client is told that the connection failed instead of succeeded.
+	if data := <-reply; len(data) < 2 || data[1] != socks5.GeneralFailure {
+		t.Errorf("expected a general failure reply, but got %v", data)
+	}
+}
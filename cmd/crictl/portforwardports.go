@@ -0,0 +1,209 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// containerPortsAnnotation holds the ports of the container spec of the
+// kubelet, as a JSON list of v1.ContainerPort.
+const containerPortsAnnotation = "io.kubernetes.container.ports"
+
+// podSandboxStatusInfo is the part of the verbose pod sandbox status of
+// containerd holding the pod sandbox config.
+type podSandboxStatusInfo struct {
+	Config *pb.PodSandboxConfig `json:"config"`
+}
+
+// resolvePodSandbox returns the ID of the pod with the given ID, or of the
+// only ready pod with the given ID prefix or name.
+func resolvePodSandbox(ctx context.Context, client pb.RuntimeServiceClient, pod string) (string, error) {
+	pods, err := selectPodSandboxes(ctx, client, pod)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := pods[pod]; ok {
+		return pod, nil
+	}
+	var ready []string
+	for id, p := range pods {
+		if p.State == pb.PodSandboxState_SANDBOX_READY {
+			ready = append(ready, id)
+		}
+	}
+	sort.Strings(ready)
+	switch len(ready) {
+	case 0:
+		return "", errors.Errorf("pod %q is not ready", pod)
+	case 1:
+		return ready[0], nil
+	default:
+		return "", errors.Errorf("pod %q is ambiguous, it matches the pods %s", pod, strings.Join(ready, ", "))
+	}
+}
+
+// resolveForwardedPorts replaces the port names in ports of the form
+// [LOCAL_PORT:]REMOTE_PORT by the TCP ports of the same name in the
+// containers of the pod. A name without a local port is forwarded from the
+// port it names. Without ports, the container ports of the port mappings of
+// the pod are forwarded, or else all named ports of its containers.
+func resolveForwardedPorts(ctx context.Context, client pb.RuntimeServiceClient, id string, ports []string) ([]string, error) {
+	var names map[string]uint16
+	lookup := func(name string) (uint16, error) {
+		if names == nil {
+			var err error
+			if names, err = containerPortNames(ctx, client, id); err != nil {
+				return 0, err
+			}
+		}
+		if port, ok := names[name]; ok {
+			return port, nil
+		}
+		return 0, errors.Errorf("port %q not found in the containers of pod %s", name, id)
+	}
+
+	if len(ports) == 0 {
+		mapped, err := sandboxPortMappings(ctx, client, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(mapped) == 0 {
+			named, err := containerPortNames(ctx, client, id)
+			if err != nil {
+				return nil, err
+			}
+			for _, port := range named {
+				mapped = append(mapped, port)
+			}
+		}
+		if len(mapped) == 0 {
+			return nil, errors.Errorf("no ports given, and pod %s has neither port mappings nor named container ports", id)
+		}
+		sort.Slice(mapped, func(i, j int) bool { return mapped[i] < mapped[j] })
+		var res []string
+		for i, port := range mapped {
+			if i > 0 && port == mapped[i-1] {
+				continue
+			}
+			res = append(res, strconv.Itoa(int(port)))
+		}
+		return res, nil
+	}
+
+	res := make([]string, 0, len(ports))
+	for _, spec := range ports {
+		local, remote := "", spec
+		if i := strings.Index(spec, ":"); i >= 0 {
+			local, remote = spec[:i+1], spec[i+1:]
+		}
+		if _, err := parsePort(remote); err == nil || remote == "" {
+			res = append(res, spec)
+			continue
+		}
+		port, err := lookup(remote)
+		if err != nil {
+			return nil, err
+		}
+		if local == "" {
+			local = fmt.Sprintf("%d:", port)
+		}
+		res = append(res, fmt.Sprintf("%s%d", local, port))
+	}
+	return res, nil
+}
+
+// containerPortNames returns the named TCP ports of the containers of the
+// pod, which the kubelet annotates them with.
+func containerPortNames(ctx context.Context, client pb.RuntimeServiceClient, id string) (map[string]uint16, error) {
+	request := &pb.ListContainersRequest{Filter: &pb.ContainerFilter{PodSandboxId: id}}
+	logrus.Debugf("ListContainersRequest: %v", request)
+	r, err := client.ListContainers(ctx, request)
+	logrus.Debugf("ListContainersResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]uint16)
+	for _, c := range r.Containers {
+		data, ok := c.Annotations[containerPortsAnnotation]
+		if !ok || c.PodSandboxId != id {
+			continue
+		}
+		var ports []v1.ContainerPort
+		if err := json.Unmarshal([]byte(data), &ports); err != nil {
+			logrus.Warnf("Unable to parse the ports of container %s: %v", c.Id, err)
+			continue
+		}
+		for _, p := range ports {
+			if p.Name != "" && (p.Protocol == "" || p.Protocol == v1.ProtocolTCP) {
+				names[p.Name] = uint16(p.ContainerPort)
+			}
+		}
+	}
+	return names, nil
+}
+
+// sandboxPortMappings returns the container ports of the TCP port mappings
+// of the pod sandbox config, which is part of the verbose pod sandbox status
+// of some runtimes.
+func sandboxPortMappings(ctx context.Context, client pb.RuntimeServiceClient, id string) ([]uint16, error) {
+	request := &pb.PodSandboxStatusRequest{PodSandboxId: id, Verbose: true}
+	logrus.Debugf("PodSandboxStatusRequest: %v", request)
+	r, err := client.PodSandboxStatus(ctx, request)
+	logrus.Debugf("PodSandboxStatusResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	var info podSandboxStatusInfo
+	if data, ok := r.GetInfo()["info"]; ok {
+		if err := json.Unmarshal([]byte(data), &info); err != nil {
+			return nil, errors.Wrap(err, "parse pod sandbox status info")
+		}
+	}
+	var ports []uint16
+	for _, m := range info.Config.GetPortMappings() {
+		if m.Protocol == pb.Protocol_TCP && m.ContainerPort > 0 {
+			ports = append(ports, uint16(m.ContainerPort))
+		}
+	}
+	return ports, nil
+}
+
+// checkPodSandboxReady returns an error if the pod is not ready.
+func checkPodSandboxReady(ctx context.Context, client pb.RuntimeServiceClient, id string) error {
+	request := &pb.PodSandboxStatusRequest{PodSandboxId: id}
+	logrus.Debugf("PodSandboxStatusRequest: %v", request)
+	r, err := client.PodSandboxStatus(ctx, request)
+	logrus.Debugf("PodSandboxStatusResponse: %v", r)
+	if err != nil {
+		return err
+	}
+	if r.GetStatus().GetState() != pb.PodSandboxState_SANDBOX_READY {
+		return errors.Errorf("pod %s is not ready", id)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/socks5"
+	"github.com/kubernetes-sigs/cri-tools/pkg/wsstream"
+)
+
+// socksForwarder forwards the connections of SOCKS5 clients to the ports of
+// a pod. Every CONNECT request is served by a new port forwarding stream.
+type socksForwarder struct {
+	client pb.RuntimeServiceClient
+	opts   portforwardOptions
+	// podIPs are the IP addresses of the pod, which clients may connect to
+	// besides the loopback addresses.
+	podIPs map[string]bool
+	// webSocket forwards every connection over a WebSocket connection of its
+	// own instead of a stream of the shared SPDY connection.
+	webSocket bool
+
+	mu        sync.Mutex
+	conn      httpstream.Connection
+	requestID int
+}
+
+// forwardSOCKS serves SOCKS5 clients on the --socks address until
+// interrupted. Without a host, it listens on the --address addresses.
+func forwardSOCKS(client pb.RuntimeServiceClient, opts portforwardOptions) error {
+	host, port, err := net.SplitHostPort(opts.socks)
+	if err != nil {
+		return errors.Wrap(err, "parse --socks")
+	}
+	hosts := []listenHost{{ip: host}}
+	if host == "" {
+		if hosts, err = listenHosts(opts.addresses); err != nil {
+			return err
+		}
+	}
+	podIPs, err := podSandboxIPs(context.Background(), client, opts.id)
+	if err != nil {
+		return err
+	}
+	f := &socksForwarder{
+		client:    client,
+		opts:      opts,
+		podIPs:    podIPs,
+		webSocket: opts.transport == transportWebSocket,
+	}
+	if !f.webSocket {
+		if _, err := f.connection(); err != nil {
+			if _, ok := err.(*spdyUpgradeError); !ok || opts.transport != transportAuto {
+				return err
+			}
+			logrus.Debugf("Falling back to WebSocket: %v", err)
+			f.webSocket = true
+		}
+	}
+
+	var listeners []net.Listener
+	defer func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}()
+	for _, h := range hosts {
+		l, err := net.Listen("tcp", net.JoinHostPort(h.ip, port))
+		if err != nil {
+			if !h.optional {
+				return err
+			}
+			logrus.Debugf("Unable to listen on %s: %v", h.ip, err)
+			continue
+		}
+		listeners = append(listeners, l)
+		// Listen on the same random port on all hosts.
+		port = strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+		fmt.Printf("Serving SOCKS5 on %s\n", l.Addr())
+		go f.accept(l)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("unable to listen on %s", opts.socks)
+	}
+	<-SetupInterruptSignalHandler()
+	return nil
+}
+
+// podSandboxIPs returns the IP addresses of a pod.
+func podSandboxIPs(ctx context.Context, client pb.RuntimeServiceClient, id string) (map[string]bool, error) {
+	request := &pb.PodSandboxStatusRequest{PodSandboxId: id}
+	logrus.Debugf("PodSandboxStatusRequest: %v", request)
+	r, err := client.PodSandboxStatus(ctx, request)
+	logrus.Debugf("PodSandboxStatusResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	ips := make(map[string]bool)
+	network := r.GetStatus().GetNetwork()
+	for _, ip := range append([]*pb.PodIP{{Ip: network.GetIp()}}, network.GetAdditionalIps()...) {
+		if parsed := net.ParseIP(ip.GetIp()); parsed != nil {
+			ips[parsed.String()] = true
+		}
+	}
+	return ips, nil
+}
+
+// allowed returns whether a host is reachable through port forwarding, which
+// only connects to the ports of the pod.
+func (f *socksForwarder) allowed(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || f.podIPs[ip.String()])
+}
+
+func (f *socksForwarder) accept(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go f.serve(conn)
+	}
+}
+
+// serve forwards the connection of a SOCKS5 client to the port it requests.
+func (f *socksForwarder) serve(conn net.Conn) {
+	host, port, err := socks5.ReadRequest(conn)
+	if err != nil {
+		logrus.Debugf("Invalid SOCKS5 request from %s: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	if !f.allowed(host) || port == 0 {
+		logrus.Warnf("Refusing connection to %s, only the ports of the pod can be forwarded", net.JoinHostPort(host, strconv.Itoa(int(port))))
+		_ = socks5.WriteReply(conn, socks5.NotAllowed)
+		conn.Close()
+		return
+	}
+	fmt.Printf("Handling connection for port %d\n", port)
+	if f.webSocket {
+		err = f.forwardWebSocket(port, conn)
+	} else {
+		err = f.forwardSPDY(port, conn)
+	}
+	if err != nil {
+		logrus.Errorf("Forwarding port %d: %v", port, err)
+	}
+}
+
+// forwardWebSocket forwards the connection over a WebSocket connection of its
+// own.
+func (f *socksForwarder) forwardWebSocket(port uint16, conn net.Conn) error {
+	URL, err := portForwardURL(f.client, f.opts.id, []int32{int32(port)})
+	if err != nil {
+		_ = socks5.WriteReply(conn, socks5.GeneralFailure)
+		conn.Close()
+		return err
+	}
+	// The reply tells whether the connection succeeded, so the pod is
+	// dialed first.
+	p, err := wsstream.DialPort(f.opts.tlsConfig, URL, port)
+	if err != nil {
+		_ = socks5.WriteReply(conn, socks5.GeneralFailure)
+		conn.Close()
+		return err
+	}
+	if err := socks5.WriteReply(conn, socks5.Succeeded); err != nil {
+		p.Close()
+		conn.Close()
+		return err
+	}
+	return p.Forward(conn)
+}
+
+// forwardSPDY forwards the connection over a new pair of streams of the
+// shared SPDY connection.
+func (f *socksForwarder) forwardSPDY(port uint16, conn net.Conn) error {
+	defer conn.Close()
+	errorStream, dataStream, err := f.createStreams(port)
+	if err != nil {
+		_ = socks5.WriteReply(conn, socks5.GeneralFailure)
+		return err
+	}
+	if err := socks5.WriteReply(conn, socks5.Succeeded); err != nil {
+		dataStream.Reset()
+		return err
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		message, err := ioutil.ReadAll(errorStream)
+		if err == nil && len(message) > 0 {
+			err = errors.New(string(message))
+		}
+		errCh <- err
+	}()
+	go func() {
+		// Tell the pod that no more data is sent.
+		defer dataStream.Close()
+		_, _ = io.Copy(dataStream, conn)
+	}()
+	_, _ = io.Copy(conn, dataStream)
+	return <-errCh
+}
+
+// createStreams creates the error and data streams of a forwarded
+// connection, as the port forwarding protocol of SPDY prescribes.
+func (f *socksForwarder) createStreams(port uint16) (httpstream.Stream, httpstream.Stream, error) {
+	conn, err := f.connection()
+	if err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	f.requestID++
+	requestID := f.requestID
+	f.mu.Unlock()
+
+	headers := http.Header{}
+	headers.Set(v1.StreamType, v1.StreamTypeError)
+	headers.Set(v1.PortHeader, strconv.Itoa(int(port)))
+	headers.Set(v1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+	errorStream, err := conn.CreateStream(headers)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "create error stream")
+	}
+	// The error stream is only read.
+	errorStream.Close()
+	headers.Set(v1.StreamType, v1.StreamTypeData)
+	dataStream, err := conn.CreateStream(headers)
+	if err != nil {
+		errorStream.Reset()
+		return nil, nil, errors.Wrap(err, "create data stream")
+	}
+	return errorStream, dataStream, nil
+}
+
+// connection returns the shared SPDY connection, which is established again
+// if it was lost.
+func (f *socksForwarder) connection() (httpstream.Connection, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		select {
+		case <-f.conn.CloseChan():
+			logrus.Warnf("Lost connection to pod %s, reconnecting", f.opts.id)
+			f.conn = nil
+		default:
+			return f.conn, nil
+		}
+	}
+	conn, err := dialPortForwardSPDY(f.client, f.opts.id, f.opts.tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	f.conn = conn
+	return conn, nil
+}
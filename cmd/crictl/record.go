@@ -0,0 +1,303 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	remoteclient "k8s.io/client-go/tools/remotecommand"
+)
+
+const (
+	// castVersion is the version of the asciinema cast format.
+	castVersion = 2
+	// castOutput and castResize are the kinds of the events of a cast.
+	castOutput = "o"
+	castResize = "r"
+)
+
+// castHeader is the first line of a cast file.
+type castHeader struct {
+	Version     int               `json:"version"`
+	Width       uint16            `json:"width"`
+	Height      uint16            `json:"height"`
+	Timestamp   int64             `json:"timestamp"`
+	Command     string            `json:"command,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	ContainerID string            `json:"container_id,omitempty"`
+}
+
+// castEvent is a line of a cast file after the header, encoded as the
+// array [time, kind, data].
+type castEvent struct {
+	// Time is the number of seconds since the start of the session.
+	Time float64
+	Kind string
+	Data string
+}
+
+func (e *castEvent) UnmarshalJSON(b []byte) error {
+	fields := []interface{}{&e.Time, &e.Kind, &e.Data}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return errors.Errorf("expected 3 fields, but got %d", len(fields))
+	}
+	return nil
+}
+
+// sessionRecorder records the output and terminal sizes of an exec or attach
+// session in the asciinema v2 cast format.
+type sessionRecorder struct {
+	w      io.WriteCloser
+	header castHeader
+
+	mu      sync.Mutex
+	start   time.Time
+	err     error
+	flushes []func()
+}
+
+// createSessionRecorder creates the cast file of a session in the container,
+// running the command if set. Only the user can read the file, as the output
+// of the session can contain secrets.
+func createSessionRecorder(path, id string, cmd []string) (*sessionRecorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "create session recording")
+	}
+	return newSessionRecorder(f, id, cmd), nil
+}
+
+func newSessionRecorder(w io.WriteCloser, id string, cmd []string) *sessionRecorder {
+	header := castHeader{
+		Version:     castVersion,
+		Command:     strings.Join(cmd, " "),
+		ContainerID: id,
+	}
+	if term := os.Getenv("TERM"); term != "" {
+		header.Env = map[string]string{"TERM": term}
+	}
+	return &sessionRecorder{w: w, header: header}
+}
+
+// begin writes the header with the terminal size, 80x24 if not set, and
+// starts the clock of the events.
+func (r *sessionRecorder) begin(size *remoteclient.TerminalSize) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start = time.Now()
+	r.header.Width, r.header.Height = 80, 24
+	if size != nil {
+		r.header.Width, r.header.Height = size.Width, size.Height
+	}
+	r.header.Timestamp = r.start.Unix()
+	data, err := json.Marshal(r.header)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(r.w, "%s\n", data)
+	return err
+}
+
+// event records an event. Recording errors do not interrupt the session, the
+// first one is returned by close.
+func (r *sessionRecorder) event(kind, data string) {
+	if r.err != nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err == nil {
+		_, err = fmt.Fprintf(r.w, "[%.6f, %q, %s]\n", time.Since(r.start).Seconds(), kind, encoded)
+	}
+	r.err = err
+}
+
+// output returns a writer passing the output to w and recording it. As JSON
+// strings can only hold valid UTF-8, an incomplete sequence at the end of a
+// write is held back until the next one.
+func (r *sessionRecorder) output(w io.Writer) io.Writer {
+	var pending []byte
+	r.flushes = append(r.flushes, func() {
+		if len(pending) > 0 {
+			r.event(castOutput, string(pending))
+		}
+	})
+	return writerFunc(func(p []byte) (int, error) {
+		n, err := w.Write(p)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		data := append(pending, p[:n]...)
+		cut := len(data)
+		for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+			if utf8.RuneStart(data[i]) {
+				if !utf8.FullRune(data[i:]) {
+					cut = i
+				}
+				break
+			}
+		}
+		pending = append([]byte(nil), data[cut:]...)
+		if cut > 0 {
+			r.event(castOutput, string(data[:cut]))
+		}
+		return n, err
+	})
+}
+
+// sizeQueue returns a terminal size queue recording the sizes of q.
+func (r *sessionRecorder) sizeQueue(q remoteclient.TerminalSizeQueue) remoteclient.TerminalSizeQueue {
+	return sizeQueueFunc(func() *remoteclient.TerminalSize {
+		size := q.Next()
+		if size != nil {
+			r.mu.Lock()
+			r.event(castResize, fmt.Sprintf("%dx%d", size.Width, size.Height))
+			r.mu.Unlock()
+		}
+		return size
+	})
+}
+
+// close writes the pending output and closes the cast file, a nil recorder is
+// ignored.
+func (r *sessionRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, flush := range r.flushes {
+		flush()
+	}
+	if err := r.w.Close(); r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		logrus.Warnf("Unable to record the session: %v", r.err)
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+type sizeQueueFunc func() *remoteclient.TerminalSize
+
+func (f sizeQueueFunc) Next() *remoteclient.TerminalSize { return f() }
+
+// recordFlag returns the flag recording an exec or attach session.
+func recordFlag() cli.Flag {
+	return &cli.StringFlag{
+		Name:  "record",
+		Usage: "Record the output and terminal sizes of the session to the file in the asciinema v2 format, see replay-session",
+	}
+}
+
+var replaySessionCommand = &cli.Command{
+	Name:      "replay-session",
+	Usage:     "Replay a session recorded by exec or attach with --record",
+	ArgsUsage: "FILE",
+	Flags: []cli.Flag{
+		&cli.Float64Flag{
+			Name:  "speed",
+			Value: 1,
+			Usage: "Playback speed, 0 prints the whole session at once",
+		},
+		&cli.DurationFlag{
+			Name:  "idle-time-limit",
+			Usage: "Maximum time between two events, 0 keeps the recorded pauses",
+		},
+	},
+	Action: func(ctx *cli.Context) error {
+		if ctx.NArg() != 1 {
+			return cli.ShowSubcommandHelp(ctx)
+		}
+		speed := ctx.Float64("speed")
+		if speed < 0 {
+			return fmt.Errorf("--speed must not be negative")
+		}
+		f, err := os.Open(ctx.Args().First())
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		header, events, err := readCast(f)
+		if err != nil {
+			return err
+		}
+		logrus.Debugf("Cast header: %+v", header)
+		return replaySession(os.Stdout, events, speed, ctx.Duration("idle-time-limit"), time.Sleep)
+	},
+}
+
+// readCast reads the header and events of a cast file.
+func readCast(r io.Reader) (*castHeader, []castEvent, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	header := &castHeader{}
+	if err := dec.Decode(header); err != nil {
+		return nil, nil, errors.Wrap(err, "read cast header")
+	}
+	if header.Version != castVersion {
+		return nil, nil, errors.Errorf("unsupported cast version %d", header.Version)
+	}
+	var events []castEvent
+	for {
+		var e castEvent
+		if err := dec.Decode(&e); err == io.EOF {
+			return header, events, nil
+		} else if err != nil {
+			return nil, nil, errors.Wrapf(err, "read event %d", len(events)+1)
+		}
+		events = append(events, e)
+	}
+}
+
+// replaySession writes the output events to w in the pace they were
+// recorded, sped up by speed and with pauses limited to idleLimit if set.
+func replaySession(w io.Writer, events []castEvent, speed float64, idleLimit time.Duration, sleep func(time.Duration)) error {
+	last := 0.0
+	for _, e := range events {
+		if speed > 0 && e.Time > last {
+			pause := time.Duration((e.Time - last) / speed * float64(time.Second))
+			if idleLimit > 0 && pause > idleLimit {
+				pause = idleLimit
+			}
+			sleep(pause)
+			last = e.Time
+		}
+		// Resizes cannot be replayed in the terminal of the viewer.
+		if e.Kind != castOutput {
+			continue
+		}
+		if _, err := io.WriteString(w, e.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	remoteclient "k8s.io/client-go/tools/remotecommand"
+)
+
+type sizeQueue []*remoteclient.TerminalSize
+
+func (q *sizeQueue) Next() *remoteclient.TerminalSize {
+	if len(*q) == 0 {
+		return nil
+	}
+	size := (*q)[0]
+	*q = (*q)[1:]
+	return size
+}
+
+func TestSessionRecorder(t *testing.T) {
+	var cast bytes.Buffer
+	r := newSessionRecorder(nopWriteCloser{&cast}, "c1", []string{"sh", "-c", "ls"})
+	if err := r.begin(&remoteclient.TerminalSize{Width: 120, Height: 40}); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	w := r.output(&stdout)
+	// "é" is split across the writes.
+	for _, p := range []string{"h\xc3", "\xa9llo\r\n", "\xe2\x82"} {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q := r.sizeQueue(&sizeQueue{{Width: 100, Height: 30}})
+	if size := q.Next(); size == nil || size.Width != 100 {
+		t.Errorf("unexpected size %v", size)
+	}
+	if size := q.Next(); size != nil {
+		t.Errorf("unexpected size %v", size)
+	}
+	r.close()
+	if stdout.String() != "héllo\r\n\xe2\x82" {
+		t.Errorf("unexpected output %q", stdout.String())
+	}
+
+	header, events, err := readCast(&cast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 ||
+		header.ContainerID != "c1" || header.Command != "sh -c ls" || header.Timestamp == 0 {
+		t.Errorf("unexpected header %+v", header)
+	}
+	var kinds, data []string
+	for _, e := range events {
+		kinds = append(kinds, e.Kind)
+		data = append(data, e.Data)
+	}
+	if !reflect.DeepEqual(kinds, []string{"o", "o", "r", "o"}) ||
+		!reflect.DeepEqual(data, []string{"h", "éllo\r\n", "100x30", "\ufffd\ufffd"}) {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+func TestCreateSessionRecorderMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not supported")
+	}
+	dir, err := ioutil.TempDir("", "record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "session.cast")
+	recorder, err := createSessionRecorder(path, "c1", []string{"sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder.close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("expected mode 0600, but got %o", mode)
+	}
+}
+
+func TestReadCastErrors(t *testing.T) {
+	for _, cast := range []string{
+		"",
+		`{"version": 1, "width": 80, "height": 24}`,
+		`{"version": 2, "width": 80, "height": 24}` + "\n" + `[0.1, "o"]`,
+		`{"version": 2, "width": 80, "height": 24}` + "\n" + `[0.1, "o", "a", "b"]`,
+		`{"version": 2, "width": 80, "height": 24}` + "\n" + `{"time": 0.1}`,
+	} {
+		if _, _, err := readCast(strings.NewReader(cast)); err == nil {
+			t.Errorf("expected an error for %q", cast)
+		}
+	}
+}
+
+func TestReplaySession(t *testing.T) {
+	events := []castEvent{
+		{Time: 0.5, Kind: "o", Data: "$ "},
+		{Time: 1, Kind: "r", Data: "100x30"},
+		{Time: 11, Kind: "o", Data: "ls\r\n"},
+		{Time: 11, Kind: "o", Data: "bin\r\n"},
+	}
+	for _, tc := range []struct {
+		speed     float64
+		idleLimit time.Duration
+		pauses    []time.Duration
+	}{
+		{speed: 1, pauses: []time.Duration{500 * time.Millisecond, 500 * time.Millisecond, 10 * time.Second}},
+		{speed: 2, idleLimit: 2 * time.Second, pauses: []time.Duration{250 * time.Millisecond, 250 * time.Millisecond, 2 * time.Second}},
+		{speed: 0},
+	} {
+		var out bytes.Buffer
+		var pauses []time.Duration
+		if err := replaySession(&out, events, tc.speed, tc.idleLimit, func(d time.Duration) { pauses = append(pauses, d) }); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "$ ls\r\nbin\r\n" {
+			t.Errorf("unexpected output %q", out.String())
+		}
+		if !reflect.DeepEqual(pauses, tc.pauses) {
+			t.Errorf("speed %v: expected pauses %v, but got %v", tc.speed, tc.pauses, pauses)
+		}
+	}
+}
+
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -20,8 +20,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/go-units"
@@ -32,6 +34,8 @@ import (
 
 	errorUtils "k8s.io/apimachinery/pkg/util/errors"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 type sandboxByCreated []*pb.PodSandbox
@@ -42,6 +46,14 @@ func (a sandboxByCreated) Less(i, j int) bool {
 	return a[i].CreatedAt > a[j].CreatedAt
 }
 
+// sandboxSortKeys are the well-known --sort-by keys of pod sandboxes.
+var sandboxSortKeys = output.SortKeys{
+	"created":   ".createdAt",
+	"name":      ".metadata.name",
+	"state":     ".state",
+	"namespace": ".metadata.namespace",
+}
+
 var runPodCommand = &cli.Command{
 	Name:      "runp",
 	Usage:     "Run a new pod",
@@ -194,7 +206,8 @@ var podStatusCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|go-template|table",
+			Usage:   output.Usage,
+			Value:   output.FormatJSON,
 		},
 		&cli.BoolFlag{
 			Name:    "quiet",
@@ -203,7 +216,7 @@ var podStatusCommand = &cli.Command{
 		},
 		&cli.StringFlag{
 			Name:  "template",
-			Usage: "The template string is only used when output is go-template; The Template format is golang template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 	},
 	Action: func(context *cli.Context) error {
@@ -215,15 +228,8 @@ var podStatusCommand = &cli.Command{
 			return err
 		}
 		defer closeConnection(context, runtimeConn)
-		for i := 0; i < context.NArg(); i++ {
-			id := context.Args().Get(i)
 
-			err := PodSandboxStatus(runtimeClient, id, context.String("output"), context.Bool("quiet"), context.String("template"))
-			if err != nil {
-				return errors.Wrapf(err, "getting the pod sandbox status for %q", id)
-			}
-		}
-		return nil
+		return PodSandboxStatus(runtimeClient, context.Args().Slice(), context.String("output"), context.Bool("quiet"), context.String("template"))
 	},
 }
 
@@ -231,7 +237,7 @@ var listPodCommand = &cli.Command{
 	Name:                   "pods",
 	Usage:                  "List pods",
 	UseShortOptionHandling: true,
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.StringFlag{
 			Name:  "id",
 			Value: "",
@@ -255,7 +261,7 @@ var listPodCommand = &cli.Command{
 		},
 		&cli.StringSliceFlag{
 			Name:  "label",
-			Usage: "filter by key=value label",
+			Usage: "filter by label selector, e.g. key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key",
 		},
 		&cli.BoolFlag{
 			Name:    "verbose",
@@ -270,8 +276,12 @@ var listPodCommand = &cli.Command{
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|table",
-			Value:   "table",
+			Usage:   output.Usage,
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 		&cli.BoolFlag{
 			Name:    "latest",
@@ -287,7 +297,12 @@ var listPodCommand = &cli.Command{
 			Name:  "no-trunc",
 			Usage: "Show output without truncating the ID",
 		},
-	},
+		&cli.BoolFlag{
+			Name:    "watch",
+			Aliases: []string{"w"},
+			Usage:   "Watch pods and highlight the changes",
+		},
+	}, sortFlags(sandboxSortKeys)...),
 	Action: func(context *cli.Context) error {
 		var err error
 		runtimeClient, runtimeConn, err := getRuntimeClient(context)
@@ -302,13 +317,17 @@ var listPodCommand = &cli.Command{
 			verbose:            context.Bool("verbose"),
 			quiet:              context.Bool("quiet"),
 			output:             context.String("output"),
+			template:           context.String("template"),
 			latest:             context.Bool("latest"),
 			last:               context.Int("last"),
 			noTrunc:            context.Bool("no-trunc"),
 			nameRegexp:         context.String("name"),
 			podNamespaceRegexp: context.String("namespace"),
+			sortBy:             context.String("sort-by"),
+			reverse:            context.Bool("reverse"),
+			watch:              context.Bool("watch"),
 		}
-		opts.labels, err = parseLabelStringSlice(context.StringSlice("label"))
+		opts.labels, opts.labelSelector, err = parseLabelStringSlice(context.StringSlice("label"))
 		if err != nil {
 			return err
 		}
@@ -388,41 +407,53 @@ func marshalPodSandboxStatus(ps *pb.PodSandboxStatus) (string, error) {
 	return marshalMapInOrder(jsonMap, *ps)
 }
 
-// PodSandboxStatus sends a PodSandboxStatusRequest to the server, and parses
-// the returned PodSandboxStatusResponse.
-func PodSandboxStatus(client pb.RuntimeServiceClient, ID, output string, quiet bool, tmplStr string) error {
+// PodSandboxStatus sends a PodSandboxStatusRequest to the server for each of
+// the IDs, and parses the returned PodSandboxStatusResponses.
+func PodSandboxStatus(client pb.RuntimeServiceClient, IDs []string, format string, quiet bool, tmplStr string) error {
 	verbose := !(quiet)
-	if output == "" { // default to json output
-		output = "json"
-	}
-	if ID == "" {
-		return fmt.Errorf("ID cannot be empty")
-	}
-
-	request := &pb.PodSandboxStatusRequest{
-		PodSandboxId: ID,
-		Verbose:      verbose,
-	}
-	logrus.Debugf("PodSandboxStatusRequest: %v", request)
-	r, err := client.PodSandboxStatus(context.Background(), request)
-	logrus.Debugf("PodSandboxStatusResponse: %v", r)
+	printer, err := output.NewPrinter(format, tmplStr)
 	if err != nil {
 		return err
 	}
 
-	status, err := marshalPodSandboxStatus(r.Status)
-	if err != nil {
-		return err
+	var items []output.Item
+	for _, ID := range IDs {
+		if ID == "" {
+			return fmt.Errorf("ID cannot be empty")
+		}
+		request := &pb.PodSandboxStatusRequest{
+			PodSandboxId: ID,
+			Verbose:      verbose,
+		}
+		logrus.Debugf("PodSandboxStatusRequest: %v", request)
+		r, err := client.PodSandboxStatus(context.Background(), request)
+		logrus.Debugf("PodSandboxStatusResponse: %v", r)
+		if err != nil {
+			return errors.Wrapf(err, "getting the pod sandbox status for %q", ID)
+		}
+
+		if !printer.Structured() {
+			outputPodSandboxStatusTable(r, verbose)
+			continue
+		}
+		status, err := marshalPodSandboxStatus(r.Status)
+		if err != nil {
+			return err
+		}
+		info, err := marshalStatusInfo(status, r.Info)
+		if err != nil {
+			return err
+		}
+		items = append(items, output.Item{Name: r.Status.Id, Object: info})
 	}
-	switch output {
-	case "json", "yaml", "go-template":
-		return outputStatusInfo(status, r.Info, output, tmplStr)
-	case "table": // table output is after this switch block
-	default:
-		return fmt.Errorf("output option cannot be %s", output)
+
+	if !printer.Structured() {
+		return nil
 	}
+	return printer.PrintItems(os.Stdout, items)
+}
 
-	// output in table format by default.
+func outputPodSandboxStatusTable(r *pb.PodSandboxStatusResponse, verbose bool) {
 	fmt.Printf("ID: %s\n", r.Status.Id)
 	if r.Status.Metadata != nil {
 		if r.Status.Metadata.Name != "" {
@@ -461,13 +492,19 @@ func PodSandboxStatus(client pb.RuntimeServiceClient, ID, output string, quiet b
 	if verbose {
 		fmt.Printf("Info: %v\n", r.GetInfo())
 	}
-
-	return nil
 }
 
 // ListPodSandboxes sends a ListPodSandboxRequest to the server, and parses
 // the returned ListPodSandboxResponse.
 func ListPodSandboxes(client pb.RuntimeServiceClient, opts listOptions) error {
+	printer, err := output.NewPrinter(opts.output, opts.template)
+	if err != nil {
+		return err
+	}
+	sorter, err := output.NewSorter(opts.sortBy, opts.reverse, sandboxSortKeys)
+	if err != nil {
+		return err
+	}
 	filter := &pb.PodSandboxFilter{}
 	if opts.id != "" {
 		filter.Id = opts.id
@@ -492,36 +529,56 @@ func ListPodSandboxes(client pb.RuntimeServiceClient, opts listOptions) error {
 	request := &pb.ListPodSandboxRequest{
 		Filter: filter,
 	}
-	logrus.Debugf("ListPodSandboxRequest: %v", request)
-	r, err := client.ListPodSandbox(context.Background(), request)
-	logrus.Debugf("ListPodSandboxResponse: %v", r)
+
+	if opts.watch {
+		if opts.quiet || opts.verbose {
+			return errors.New("watch mode cannot be used with --quiet or --verbose")
+		}
+		return watchObjects(printer, func() ([]string, []watchItem, error) {
+			r, err := listPodSandboxes(client, request, sorter, opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			wide := printer.Format() == output.FormatWide
+			var wideInfos map[string]*podWideInfo
+			if wide {
+				wideInfos = getPodsWideInfo(client, r.Items)
+			}
+			items := make([]watchItem, 0, len(r.Items))
+			for _, pod := range r.Items {
+				items = append(items, watchItem{
+					id:     pod.Id,
+					state:  convertPodState(pod.State),
+					row:    podTableRow(pod, opts, wideInfos[pod.Id]),
+					object: pod,
+				})
+			}
+			return podTableHeader(wide), items, nil
+		})
+	}
+
+	r, err := listPodSandboxes(client, request, sorter, opts)
 	if err != nil {
 		return err
 	}
-	r.Items = getSandboxesList(r.GetItems(), opts)
 
-	switch opts.output {
-	case "json":
-		return outputProtobufObjAsJSON(r)
-	case "yaml":
-		return outputProtobufObjAsYAML(r)
-	case "table":
-	// continue; output will be generated after the switch block ends.
-	default:
-		return fmt.Errorf("unsupported output format %q", opts.output)
+	if printer.Structured() {
+		items := make([]output.Item, 0, len(r.Items))
+		for _, pod := range r.Items {
+			items = append(items, output.Item{Name: pod.Id, Object: pod})
+		}
+		return printer.PrintList(os.Stdout, r, items)
+	}
+
+	wide := printer.Format() == output.FormatWide && !opts.verbose && !opts.quiet
+	var wideInfos map[string]*podWideInfo
+	if wide {
+		wideInfos = getPodsWideInfo(client, r.Items)
 	}
 
 	display := newTableDisplay(20, 1, 3, ' ', 0)
 	if !opts.verbose && !opts.quiet {
-		display.AddRow([]string{
-			columnPodID,
-			columnCreated,
-			columnState,
-			columnName,
-			columnNamespace,
-			columnAttempt,
-			columnPodRuntime,
-		})
+		display.AddRow(podTableHeader(wide))
 	}
 	for _, pod := range r.Items {
 		if opts.quiet {
@@ -529,21 +586,7 @@ func ListPodSandboxes(client pb.RuntimeServiceClient, opts listOptions) error {
 			continue
 		}
 		if !opts.verbose {
-			createdAt := time.Unix(0, pod.CreatedAt)
-			ctm := units.HumanDuration(time.Now().UTC().Sub(createdAt)) + " ago"
-			id := pod.Id
-			if !opts.noTrunc {
-				id = getTruncatedID(id, "")
-			}
-			display.AddRow([]string{
-				id,
-				ctm,
-				convertPodState(pod.State),
-				pod.Metadata.Name,
-				pod.Metadata.Namespace,
-				fmt.Sprintf("%d", pod.Metadata.Attempt),
-				getSandboxesRuntimeHandler(pod),
-			})
+			display.AddRow(podTableRow(pod, opts, wideInfos[pod.Id]))
 			continue
 		}
 
@@ -588,6 +631,141 @@ func ListPodSandboxes(client pb.RuntimeServiceClient, opts listOptions) error {
 	return nil
 }
 
+// listPodSandboxes sends a ListPodSandboxRequest to the server and returns
+// the pod sandboxes matching opts in the requested order.
+func listPodSandboxes(client pb.RuntimeServiceClient, request *pb.ListPodSandboxRequest, sorter *output.Sorter, opts listOptions) (*pb.ListPodSandboxResponse, error) {
+	logrus.Debugf("ListPodSandboxRequest: %v", request)
+	r, err := client.ListPodSandbox(context.Background(), request)
+	logrus.Debugf("ListPodSandboxResponse: %v", r)
+	if err != nil {
+		return nil, err
+	}
+	r.Items = getSandboxesList(r.GetItems(), opts)
+	if err := sorter.Sort(r.Items); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func podTableHeader(wide bool) []string {
+	header := []string{
+		columnPodID,
+		columnCreated,
+		columnState,
+		columnName,
+		columnNamespace,
+		columnAttempt,
+		columnPodRuntime,
+	}
+	if wide {
+		header = append(header, columnIP, columnOtherIPs, columnHostNet, columnContainers)
+	}
+	return header
+}
+
+// podTableRow returns the table row of a pod sandbox. The wide output
+// columns are added if info is not nil.
+func podTableRow(pod *pb.PodSandbox, opts listOptions, info *podWideInfo) []string {
+	createdAt := time.Unix(0, pod.CreatedAt)
+	ctm := units.HumanDuration(time.Now().UTC().Sub(createdAt)) + " ago"
+	id := pod.Id
+	if !opts.noTrunc {
+		id = getTruncatedID(id, "")
+	}
+	row := []string{
+		id,
+		ctm,
+		convertPodState(pod.State),
+		pod.Metadata.Name,
+		pod.Metadata.Namespace,
+		fmt.Sprintf("%d", pod.Metadata.Attempt),
+		getSandboxesRuntimeHandler(pod),
+	}
+	if info != nil {
+		row = append(row,
+			info.ip,
+			info.additionalIPs,
+			fmt.Sprintf("%t", info.hostNetwork),
+			fmt.Sprintf("%d/%d", info.running, info.total),
+		)
+	}
+	return row
+}
+
+// podWideInfo holds the details shown by `crictl pods -o wide` which are not
+// part of the listed pod sandboxes.
+type podWideInfo struct {
+	ip            string
+	additionalIPs string
+	hostNetwork   bool
+	running       int
+	total         int
+}
+
+// wideInfoParallelism is the number of status requests of the wide output
+// which are sent at a time.
+const wideInfoParallelism = 10
+
+// getPodsWideInfo collects the wide output details of pods. Containers are
+// counted from a single ListContainers call, whereas the pod sandbox status
+// is requested concurrently, at most wideInfoParallelism at a time, to keep
+// large nodes fast.
+func getPodsWideInfo(client pb.RuntimeServiceClient, pods []*pb.PodSandbox) map[string]*podWideInfo {
+	infos := make(map[string]*podWideInfo, len(pods))
+	for _, pod := range pods {
+		infos[pod.Id] = &podWideInfo{ip: "-", additionalIPs: "-"}
+	}
+
+	r, err := client.ListContainers(context.Background(), &pb.ListContainersRequest{})
+	if err != nil {
+		logrus.Warnf("listing containers: %v", err)
+	} else {
+		for _, c := range r.GetContainers() {
+			info, ok := infos[c.PodSandboxId]
+			if !ok {
+				continue
+			}
+			info.total++
+			if c.State == pb.ContainerState_CONTAINER_RUNNING {
+				info.running++
+			}
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, wideInfoParallelism)
+	)
+	for _, pod := range pods {
+		wg.Add(1)
+		go func(id string, info *podWideInfo) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r, err := client.PodSandboxStatus(context.Background(), &pb.PodSandboxStatusRequest{PodSandboxId: id})
+			if err != nil {
+				logrus.Warnf("getting the status of the pod sandbox %q: %v", id, err)
+				return
+			}
+			status := r.GetStatus()
+			if ip := status.GetNetwork().GetIp(); ip != "" {
+				info.ip = ip
+			}
+			var additionalIPs []string
+			for _, ip := range status.GetNetwork().GetAdditionalIps() {
+				additionalIPs = append(additionalIPs, ip.GetIp())
+			}
+			if len(additionalIPs) > 0 {
+				info.additionalIPs = strings.Join(additionalIPs, ",")
+			}
+			info.hostNetwork = status.GetLinux().GetNamespaces().GetOptions().GetNetwork() == pb.NamespaceMode_NODE
+		}(pod.Id, infos[pod.Id])
+	}
+	wg.Wait()
+	return infos
+}
+
 func convertPodState(state pb.PodSandboxState) string {
 	switch state {
 	case pb.PodSandboxState_SANDBOX_READY:
@@ -610,9 +788,10 @@ func getSandboxesRuntimeHandler(sandbox *pb.PodSandbox) string {
 func getSandboxesList(sandboxesList []*pb.PodSandbox, opts listOptions) []*pb.PodSandbox {
 	filtered := []*pb.PodSandbox{}
 	for _, p := range sandboxesList {
-		// Filter by pod name/namespace regular expressions.
+		// Filter by pod name/namespace regular expressions and set based label selectors.
 		if matchesRegex(opts.nameRegexp, p.Metadata.Name) &&
-			matchesRegex(opts.podNamespaceRegexp, p.Metadata.Namespace) {
+			matchesRegex(opts.podNamespaceRegexp, p.Metadata.Namespace) &&
+			matchesLabels(opts.labelSelector, p.Labels) {
 			filtered = append(filtered, p)
 		}
 	}
@@ -18,6 +18,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"sort"
 	"time"
 
@@ -26,7 +27,11 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/labels"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/cgroups"
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 type statsOptions struct {
@@ -40,10 +45,41 @@ type statsOptions struct {
 	sample time.Duration
 	// labels are selectors for the sandbox
 	labels map[string]string
+	// labelSelector holds the set based label requirements
+	labelSelector labels.Selector
 	// output format
 	output string
+	// template string for the go-template, jsonpath and custom-columns output
+	template string
 	// live watch
 	watch bool
+	// sortBy is a well-known key or JSONPath to sort the list by
+	sortBy string
+	// reverse the sort order
+	reverse bool
+	// containers shows the containers of every pod in the pod stats
+	containers bool
+	// record is the file the stats are recorded to
+	record string
+	// recordFormat is the format of the record file, csv or json
+	recordFormat string
+	// interval between recorded samples
+	interval time.Duration
+	// duration of the recording, 0 records until interrupted
+	duration time.Duration
+	// source of the stats, cri or cgroup
+	source string
+	// cgroupRoot is the mount point of the cgroup filesystem
+	cgroupRoot string
+}
+
+// statsSortKeys are the well-known --sort-by keys of container stats.
+var statsSortKeys = output.SortKeys{
+	"name":      ".attributes.metadata.name",
+	"namespace": `.attributes.labels.io\.kubernetes\.pod\.namespace`,
+	"cpu-time":  ".cpu.usageCoreNanoSeconds.value",
+	"memory":    ".memory.workingSetBytes.value",
+	"size":      ".writableLayer.usedBytes.value",
 }
 
 var statsCommand = &cli.Command{
@@ -51,7 +87,7 @@ var statsCommand = &cli.Command{
 	Usage:                  "List container(s) resource usage statistics",
 	UseShortOptionHandling: true,
 	ArgsUsage:              "[ID]",
-	Flags: []cli.Flag{
+	Flags: append([]cli.Flag{
 		&cli.BoolFlag{
 			Name:    "all",
 			Aliases: []string{"a"},
@@ -70,12 +106,17 @@ var statsCommand = &cli.Command{
 		},
 		&cli.StringSliceFlag{
 			Name:  "label",
-			Usage: "Filter by key=value label",
+			Usage: "Filter by label selector, e.g. key=value, key!=value, 'key in (a,b)', 'key notin (a,b)', key or !key",
 		},
 		&cli.StringFlag{
 			Name:    "output",
 			Aliases: []string{"o"},
-			Usage:   "Output format, One of: json|yaml|table",
+			Usage:   output.Usage,
+			Value:   output.FormatTable,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
 		},
 		&cli.IntFlag{
 			Name:    "seconds",
@@ -88,7 +129,34 @@ var statsCommand = &cli.Command{
 			Aliases: []string{"w"},
 			Usage:   "Watch pod resources",
 		},
-	},
+		&cli.StringFlag{
+			Name:  "record",
+			Usage: "Append timestamped samples to a file instead of displaying them",
+		},
+		&cli.StringFlag{
+			Name:  "record-format",
+			Usage: "Format of the record file, One of: csv|json. Defaults to csv for .csv files and to JSON lines otherwise",
+		},
+		&cli.DurationFlag{
+			Name:  "interval",
+			Value: 5 * time.Second,
+			Usage: "Interval between recorded samples",
+		},
+		&cli.DurationFlag{
+			Name:  "duration",
+			Usage: "Duration of the recording. Records until interrupted if not set",
+		},
+		&cli.StringFlag{
+			Name:  "source",
+			Value: statsSourceCRI,
+			Usage: "Source of the stats, One of: cri|cgroup. cgroup merges the CRI stats with memory, throttling, pids, IO and pressure stats read from the cgroup filesystem",
+		},
+		&cli.StringFlag{
+			Name:  "cgroup-root",
+			Value: cgroups.DefaultRoot,
+			Usage: "Mount point of the cgroup filesystem used by --source cgroup",
+		},
+	}, sortFlags(statsSortKeys)...),
 	Action: func(context *cli.Context) error {
 		runtimeClient, runtimeConn, err := getRuntimeClient(context)
 		if err != nil {
@@ -102,14 +170,23 @@ var statsCommand = &cli.Command{
 		}
 
 		opts := statsOptions{
-			all:    context.Bool("all"),
-			id:     id,
-			podID:  context.String("pod"),
-			sample: time.Duration(context.Int("seconds")) * time.Second,
-			output: context.String("output"),
-			watch:  context.Bool("watch"),
+			all:          context.Bool("all"),
+			id:           id,
+			podID:        context.String("pod"),
+			sample:       time.Duration(context.Int("seconds")) * time.Second,
+			output:       context.String("output"),
+			template:     context.String("template"),
+			watch:        context.Bool("watch"),
+			sortBy:       context.String("sort-by"),
+			reverse:      context.Bool("reverse"),
+			record:       context.String("record"),
+			recordFormat: context.String("record-format"),
+			interval:     context.Duration("interval"),
+			duration:     context.Duration("duration"),
+			source:       context.String("source"),
+			cgroupRoot:   context.String("cgroup-root"),
 		}
-		opts.labels, err = parseLabelStringSlice(context.StringSlice("label"))
+		opts.labels, opts.labelSelector, err = parseLabelStringSlice(context.StringSlice("label"))
 		if err != nil {
 			return err
 		}
@@ -132,6 +209,14 @@ func (c containerStatsByID) Less(i, j int) bool {
 // ContainerStats sends a ListContainerStatsRequest to the server, and
 // parses the returned ListContainerStatsResponse.
 func ContainerStats(client pb.RuntimeServiceClient, opts statsOptions) error {
+	printer, err := output.NewPrinter(opts.output, opts.template)
+	if err != nil {
+		return err
+	}
+	sorter, err := output.NewSorter(opts.sortBy, opts.reverse, statsSortKeys)
+	if err != nil {
+		return err
+	}
 	filter := &pb.ContainerStatsFilter{}
 	if opts.id != "" {
 		filter.Id = opts.id
@@ -146,92 +231,126 @@ func ContainerStats(client pb.RuntimeServiceClient, opts statsOptions) error {
 		Filter: filter,
 	}
 
-	display := newTableDisplay(20, 1, 3, ' ', 0)
-	if !opts.watch {
-		if err := displayStats(context.TODO(), client, request, display, opts); err != nil {
-			return err
+	switch opts.source {
+	case statsSourceCRI:
+	case statsSourceCgroup:
+		if opts.record != "" {
+			return errors.New("recording is not supported with the cgroup source")
 		}
-	} else {
-		displayErrCh := make(chan error, 1)
-		ticker := time.NewTicker(500 * time.Millisecond)
-		defer ticker.Stop()
-		watchCtx, cancelFn := context.WithCancel(context.Background())
-		defer cancelFn()
-		// Put the displayStats in another goroutine.
-		// because it might be time consuming with lots of containers.
-		// and we want to cancel it ASAP when user hit CtrlC
-		go func() {
-			for range ticker.C {
-				if err := displayStats(watchCtx, client, request, display, opts); err != nil {
-					displayErrCh <- err
-					break
-				}
+	default:
+		return errors.Errorf("unsupported stats source %q, must be one of: cri|cgroup", opts.source)
+	}
+
+	if opts.record != "" {
+		return recordStats(client, request, opts)
+	}
+
+	display := newTableDisplay(20, 1, 3, ' ', 0)
+	return runStats(opts.watch, func(ctx context.Context) error {
+		return displayStats(ctx, client, request, display, printer, sorter, opts)
+	})
+}
+
+// runStats calls displayFn once, or every 500ms until the user hits CtrlC
+// in watch mode.
+func runStats(watch bool, displayFn func(ctx context.Context) error) error {
+	if !watch {
+		return displayFn(context.TODO())
+	}
+
+	displayErrCh := make(chan error, 1)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	watchCtx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+	// Put the displayFn in another goroutine.
+	// because it might be time consuming with lots of containers.
+	// and we want to cancel it ASAP when user hit CtrlC
+	go func() {
+		for range ticker.C {
+			if err := displayFn(watchCtx); err != nil {
+				displayErrCh <- err
+				break
 			}
-		}()
-		// listen for CtrlC or error
-		select {
-		case <-SetupInterruptSignalHandler():
-			cancelFn()
-			return nil
-		case err := <-displayErrCh:
-			return err
 		}
+	}()
+	// listen for CtrlC or error
+	select {
+	case <-SetupInterruptSignalHandler():
+		cancelFn()
+		return nil
+	case err := <-displayErrCh:
+		return err
 	}
-
-	return nil
 }
 
-func getContainerStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest) (*pb.ListContainerStatsResponse, error) {
+func getContainerStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, selector labels.Selector) (*pb.ListContainerStatsResponse, error) {
 	logrus.Debugf("ListContainerStatsRequest: %v", request)
 	r, err := client.ListContainerStats(ctx, request)
 	logrus.Debugf("ListContainerResponse: %v", r)
 	if err != nil {
 		return nil, err
 	}
+	filtered := []*pb.ContainerStats{}
+	for _, s := range r.Stats {
+		if matchesLabels(selector, s.GetAttributes().GetLabels()) {
+			filtered = append(filtered, s)
+		}
+	}
+	r.Stats = filtered
 	sort.Sort(containerStatsByID(r.Stats))
 	return r, nil
 }
 
-func displayStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, display *display, opts statsOptions) error {
-	r, err := getContainerStats(ctx, client, request)
+// statsRow is the sample of a container along with its CPU usage percentage
+// during the sample window.
+type statsRow struct {
+	stats   *pb.ContainerStats
+	cpuPerc float64
+}
+
+// hasUsage returns whether the container uses any CPU or memory, which
+// non-running containers do not.
+func hasUsage(s *pb.ContainerStats) bool {
+	return s.GetCpu().GetUsageCoreNanoSeconds().GetValue() != 0 || s.GetMemory().GetWorkingSetBytes().GetValue() != 0
+}
+
+// sampleStats lists the container stats twice, opts.sample apart, and
+// returns the second sample of the containers which existed in both, ordered
+// by sorter. Non-running containers are skipped unless opts.all is set.
+func sampleStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, sorter *output.Sorter, opts statsOptions) ([]statsRow, error) {
+	r, err := getContainerStats(ctx, client, request, opts.labelSelector)
 	if err != nil {
-		return err
-	}
-	switch opts.output {
-	case "json":
-		return outputProtobufObjAsJSON(r)
-	case "yaml":
-		return outputProtobufObjAsYAML(r)
+		return nil, err
 	}
 	oldStats := make(map[string]*pb.ContainerStats)
 	for _, s := range r.GetStats() {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
 		oldStats[s.Attributes.Id] = s
 	}
 
 	time.Sleep(opts.sample)
 
-	r, err = getContainerStats(ctx, client, request)
+	r, err = getContainerStats(ctx, client, request, opts.labelSelector)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := sorter.Sort(r.Stats); err != nil {
+		return nil, err
 	}
 
-	display.AddRow([]string{columnContainer, columnCPU, columnMemory, columnDisk, columnInodes})
+	var rows []statsRow
 	for _, s := range r.GetStats() {
 		if ctx.Err() != nil {
-			return ctx.Err()
+			return nil, ctx.Err()
 		}
-		id := getTruncatedID(s.Attributes.Id, "")
-		cpu := s.GetCpu().GetUsageCoreNanoSeconds().GetValue()
-		mem := s.GetMemory().GetWorkingSetBytes().GetValue()
-		disk := s.GetWritableLayer().GetUsedBytes().GetValue()
-		inodes := s.GetWritableLayer().GetInodesUsed().GetValue()
-		if !opts.all && cpu == 0 && mem == 0 {
+		if !opts.all && !hasUsage(s) {
 			// Skip non-running container
 			continue
 		}
+		cpu := s.GetCpu().GetUsageCoreNanoSeconds().GetValue()
 		old, ok := oldStats[s.Attributes.Id]
 		if !ok {
 			// Skip new container
@@ -242,13 +361,64 @@ func displayStats(ctx context.Context, client pb.RuntimeServiceClient, request *
 			// Only generate cpuPerc for running container
 			duration := s.GetCpu().GetTimestamp() - old.GetCpu().GetTimestamp()
 			if duration == 0 {
-				return fmt.Errorf("cpu stat is not updated during sample")
+				return nil, fmt.Errorf("cpu stat is not updated during sample")
 			}
 			cpuPerc = float64(cpu-old.GetCpu().GetUsageCoreNanoSeconds().GetValue()) / float64(duration) * 100
 		}
-		display.AddRow([]string{id, fmt.Sprintf("%.2f", cpuPerc), units.HumanSize(float64(mem)),
-			units.HumanSize(float64(disk)), fmt.Sprintf("%d", inodes)})
+		rows = append(rows, statsRow{stats: s, cpuPerc: cpuPerc})
+	}
 
+	return rows, nil
+}
+
+func displayStats(ctx context.Context, client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, display *display, printer *output.Printer, sorter *output.Sorter, opts statsOptions) error {
+	if printer.Structured() {
+		r, err := getContainerStats(ctx, client, request, opts.labelSelector)
+		if err != nil {
+			return err
+		}
+		if err := sorter.Sort(r.Stats); err != nil {
+			return err
+		}
+		if opts.source == statsSourceCgroup {
+			return printCgroupStats(ctx, client, r.Stats, printer, opts)
+		}
+		items := make([]output.Item, 0, len(r.Stats))
+		for _, s := range r.Stats {
+			items = append(items, output.Item{Name: s.GetAttributes().GetId(), Object: s})
+		}
+		return printer.PrintList(os.Stdout, r, items)
+	}
+
+	rows, err := sampleStats(ctx, client, request, sorter, opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.source == statsSourceCgroup {
+		stats := make([]*pb.ContainerStats, 0, len(rows))
+		for _, row := range rows {
+			stats = append(stats, row.stats)
+		}
+		cgroupStats := readCgroupStats(ctx, client, opts.cgroupRoot, stats)
+		display.AddRow(cgroupStatsHeader)
+		for _, row := range rows {
+			display.AddRow(cgroupStatsRow(row, cgroupStats[row.stats.GetAttributes().GetId()]))
+		}
+		display.ClearScreen()
+		display.Flush()
+		return nil
+	}
+
+	display.AddRow([]string{columnContainer, columnCPU, columnMemory, columnDisk, columnInodes})
+	for _, row := range rows {
+		s := row.stats
+		id := getTruncatedID(s.Attributes.Id, "")
+		mem := s.GetMemory().GetWorkingSetBytes().GetValue()
+		disk := s.GetWritableLayer().GetUsedBytes().GetValue()
+		inodes := s.GetWritableLayer().GetInodesUsed().GetValue()
+		display.AddRow([]string{id, fmt.Sprintf("%.2f", row.cpuPerc), units.HumanSize(float64(mem)),
+			units.HumanSize(float64(disk)), fmt.Sprintf("%d", inodes)})
 	}
 	display.ClearScreen()
 	display.Flush()
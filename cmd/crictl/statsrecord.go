@@ -0,0 +1,259 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	kubetypes "k8s.io/kubernetes/pkg/kubelet/types"
+)
+
+// Formats of recorded stats.
+const (
+	recordFormatCSV  = "csv"
+	recordFormatJSON = "json"
+)
+
+// statsRecord is a sample of a container recorded by `crictl stats
+// --record`. The derived fields are unset for the first sample of a
+// container.
+type statsRecord struct {
+	Timestamp               time.Time `json:"timestamp"`
+	ContainerID             string    `json:"containerId"`
+	Name                    string    `json:"name"`
+	PodName                 string    `json:"podName"`
+	PodNamespace            string    `json:"podNamespace"`
+	CPUPercent              *float64  `json:"cpuPercent,omitempty"`
+	CPUUsageCoreNanoSeconds uint64    `json:"cpuUsageCoreNanoSeconds"`
+	MemoryWorkingSetBytes   uint64    `json:"memoryWorkingSetBytes"`
+	MemoryDeltaBytes        *int64    `json:"memoryDeltaBytes,omitempty"`
+	DiskUsedBytes           uint64    `json:"diskUsedBytes"`
+	DiskDeltaBytes          *int64    `json:"diskDeltaBytes,omitempty"`
+	InodesUsed              uint64    `json:"inodesUsed"`
+	InodesDelta             *int64    `json:"inodesDelta,omitempty"`
+}
+
+// statsRecordHeader is the header of the CSV format.
+var statsRecordHeader = []string{
+	"timestamp",
+	"container_id",
+	"name",
+	"pod_name",
+	"pod_namespace",
+	"cpu_percent",
+	"cpu_usage_core_nanoseconds",
+	"memory_working_set_bytes",
+	"memory_delta_bytes",
+	"disk_used_bytes",
+	"disk_delta_bytes",
+	"inodes_used",
+	"inodes_delta",
+}
+
+// newStatsRecord creates the record of sample s taken at ts. The derived
+// fields are calculated from the previous sample of the container, if old
+// is not nil.
+func newStatsRecord(ts time.Time, s, old *pb.ContainerStats) *statsRecord {
+	attrs := s.GetAttributes()
+	r := &statsRecord{
+		Timestamp:               ts.UTC(),
+		ContainerID:             attrs.GetId(),
+		Name:                    attrs.GetMetadata().GetName(),
+		PodName:                 attrs.GetLabels()[kubetypes.KubernetesPodNameLabel],
+		PodNamespace:            attrs.GetLabels()[kubetypes.KubernetesPodNamespaceLabel],
+		CPUUsageCoreNanoSeconds: s.GetCpu().GetUsageCoreNanoSeconds().GetValue(),
+		MemoryWorkingSetBytes:   s.GetMemory().GetWorkingSetBytes().GetValue(),
+		DiskUsedBytes:           s.GetWritableLayer().GetUsedBytes().GetValue(),
+		InodesUsed:              s.GetWritableLayer().GetInodesUsed().GetValue(),
+	}
+	if old == nil {
+		return r
+	}
+
+	oldCPU := old.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+	if duration := s.GetCpu().GetTimestamp() - old.GetCpu().GetTimestamp(); duration > 0 && r.CPUUsageCoreNanoSeconds >= oldCPU {
+		cpuPerc := float64(r.CPUUsageCoreNanoSeconds-oldCPU) / float64(duration) * 100
+		r.CPUPercent = &cpuPerc
+	}
+	memoryDelta := int64(r.MemoryWorkingSetBytes) - int64(old.GetMemory().GetWorkingSetBytes().GetValue())
+	r.MemoryDeltaBytes = &memoryDelta
+	diskDelta := int64(r.DiskUsedBytes) - int64(old.GetWritableLayer().GetUsedBytes().GetValue())
+	r.DiskDeltaBytes = &diskDelta
+	inodesDelta := int64(r.InodesUsed) - int64(old.GetWritableLayer().GetInodesUsed().GetValue())
+	r.InodesDelta = &inodesDelta
+	return r
+}
+
+func (r *statsRecord) csvRow() []string {
+	formatInt := func(i *int64) string {
+		if i == nil {
+			return ""
+		}
+		return strconv.FormatInt(*i, 10)
+	}
+	cpuPerc := ""
+	if r.CPUPercent != nil {
+		cpuPerc = strconv.FormatFloat(*r.CPUPercent, 'f', 2, 64)
+	}
+	return []string{
+		r.Timestamp.Format(time.RFC3339Nano),
+		r.ContainerID,
+		r.Name,
+		r.PodName,
+		r.PodNamespace,
+		cpuPerc,
+		strconv.FormatUint(r.CPUUsageCoreNanoSeconds, 10),
+		strconv.FormatUint(r.MemoryWorkingSetBytes, 10),
+		formatInt(r.MemoryDeltaBytes),
+		strconv.FormatUint(r.DiskUsedBytes, 10),
+		formatInt(r.DiskDeltaBytes),
+		strconv.FormatUint(r.InodesUsed, 10),
+		formatInt(r.InodesDelta),
+	}
+}
+
+// statsRecordWriter appends records to a file in the CSV or JSON lines
+// format.
+type statsRecordWriter struct {
+	format string
+	csv    *csv.Writer
+	json   *json.Encoder
+}
+
+// newStatsRecordWriter creates a writer for w. The CSV header is only
+// written if w is empty, so that records can be appended to existing files.
+func newStatsRecordWriter(w io.Writer, format string, empty bool) (*statsRecordWriter, error) {
+	switch format {
+	case recordFormatCSV:
+		cw := csv.NewWriter(w)
+		if empty {
+			if err := cw.Write(statsRecordHeader); err != nil {
+				return nil, err
+			}
+			cw.Flush()
+		}
+		return &statsRecordWriter{format: format, csv: cw}, cw.Error()
+	case recordFormatJSON:
+		return &statsRecordWriter{format: format, json: json.NewEncoder(w)}, nil
+	default:
+		return nil, errors.Errorf("unsupported record format %q", format)
+	}
+}
+
+func (w *statsRecordWriter) write(records []*statsRecord) error {
+	for _, r := range records {
+		var err error
+		if w.format == recordFormatCSV {
+			err = w.csv.Write(r.csvRow())
+		} else {
+			err = w.json.Encode(r)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if w.csv != nil {
+		w.csv.Flush()
+		return w.csv.Error()
+	}
+	return nil
+}
+
+// recordFormat returns the format of the record file, which defaults to
+// CSV for files with a .csv extension and to JSON lines otherwise.
+func recordFormat(opts statsOptions) string {
+	if opts.recordFormat != "" {
+		return opts.recordFormat
+	}
+	if strings.HasSuffix(strings.ToLower(opts.record), ".csv") {
+		return recordFormatCSV
+	}
+	return recordFormatJSON
+}
+
+// recordStats appends a sample of the container stats to opts.record every
+// opts.interval, until opts.duration has passed or the user hits CtrlC.
+func recordStats(client pb.RuntimeServiceClient, request *pb.ListContainerStatsRequest, opts statsOptions) error {
+	if opts.interval <= 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	f, err := os.OpenFile(opts.record, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrap(err, "open record file")
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := newStatsRecordWriter(f, recordFormat(opts), info.Size() == 0)
+	if err != nil {
+		return err
+	}
+
+	var deadline <-chan time.Time
+	if opts.duration > 0 {
+		deadline = time.After(opts.duration)
+	}
+	ticker := time.NewTicker(opts.interval)
+	defer ticker.Stop()
+
+	previous := make(map[string]*pb.ContainerStats)
+	for {
+		ts := time.Now()
+		r, err := getContainerStats(context.Background(), client, request, opts.labelSelector)
+		if err != nil {
+			return err
+		}
+		current := make(map[string]*pb.ContainerStats, len(r.GetStats()))
+		records := make([]*statsRecord, 0, len(r.GetStats()))
+		for _, s := range r.GetStats() {
+			cpu := s.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+			mem := s.GetMemory().GetWorkingSetBytes().GetValue()
+			if !opts.all && cpu == 0 && mem == 0 {
+				// Skip non-running container
+				continue
+			}
+			current[s.Attributes.Id] = s
+			records = append(records, newStatsRecord(ts, s, previous[s.Attributes.Id]))
+		}
+		if err := w.write(records); err != nil {
+			return errors.Wrap(err, "write records")
+		}
+		logrus.Debugf("Recorded %d samples to %s", len(records), opts.record)
+		previous = current
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return nil
+		case <-SetupInterruptSignalHandler():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestNewStatsRecord(t *testing.T) {
+	sample := func(ts int64, cpu, memory, disk, inodes uint64) *pb.ContainerStats {
+		return &pb.ContainerStats{
+			Attributes: &pb.ContainerAttributes{
+				Id:       "c1",
+				Metadata: &pb.ContainerMetadata{Name: "nginx"},
+				Labels:   map[string]string{"io.kubernetes.pod.name": "web", "io.kubernetes.pod.namespace": "default"},
+			},
+			Cpu:           &pb.CpuUsage{Timestamp: ts, UsageCoreNanoSeconds: &pb.UInt64Value{Value: cpu}},
+			Memory:        &pb.MemoryUsage{WorkingSetBytes: &pb.UInt64Value{Value: memory}},
+			WritableLayer: &pb.FilesystemUsage{UsedBytes: &pb.UInt64Value{Value: disk}, InodesUsed: &pb.UInt64Value{Value: inodes}},
+		}
+	}
+	ts := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	old := sample(1e9, 1e9, 4096, 1000, 10)
+	s := sample(3e9, 2e9, 6144, 800, 12)
+
+	first := newStatsRecord(ts, old, nil)
+	if first.CPUPercent != nil || first.MemoryDeltaBytes != nil || first.DiskDeltaBytes != nil || first.InodesDelta != nil {
+		t.Errorf("expected no derived fields for the first sample, but got %+v", first)
+	}
+
+	r := newStatsRecord(ts, s, old)
+	if r.PodName != "web" || r.PodNamespace != "default" || r.Name != "nginx" {
+		t.Errorf("unexpected container attributes %+v", r)
+	}
+	if r.CPUPercent == nil || *r.CPUPercent != 50 {
+		t.Errorf("expected 50%% CPU, but got %v", r.CPUPercent)
+	}
+	if *r.MemoryDeltaBytes != 2048 {
+		t.Errorf("expected memory delta 2048, but got %d", *r.MemoryDeltaBytes)
+	}
+	if *r.DiskDeltaBytes != -200 || *r.InodesDelta != 2 {
+		t.Errorf("expected disk delta -200 and inodes delta 2, but got %d and %d", *r.DiskDeltaBytes, *r.InodesDelta)
+	}
+
+	var buf bytes.Buffer
+	w, err := newStatsRecordWriter(&buf, recordFormatCSV, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write([]*statsRecord{first, r}); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join(statsRecordHeader, ",") + "\n" +
+		"2021-03-01T12:00:00Z,c1,nginx,web,default,,1000000000,4096,,1000,,10,\n" +
+		"2021-03-01T12:00:00Z,c1,nginx,web,default,50.00,2000000000,6144,2048,800,-200,12,2\n"
+	if buf.String() != expected {
+		t.Errorf("expected CSV %q, but got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if w, err = newStatsRecordWriter(&buf, recordFormatJSON, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write([]*statsRecord{r}); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["cpuPercent"] != float64(50) || decoded["inodesDelta"] != float64(2) {
+		t.Errorf("unexpected JSON record %s", buf.String())
+	}
+}
+
+func TestRecordFormat(t *testing.T) {
+	for _, tc := range []struct {
+		opts     statsOptions
+		expected string
+	}{
+		{statsOptions{record: "stats.CSV"}, recordFormatCSV},
+		{statsOptions{record: "stats.jsonl"}, recordFormatJSON},
+		{statsOptions{record: "stats.csv", recordFormat: recordFormatJSON}, recordFormatJSON},
+	} {
+		if format := recordFormat(tc.opts); format != tc.expected {
+			t.Errorf("record %q: expected format %q, but got %q", tc.opts.record, tc.expected, format)
+		}
+	}
+}
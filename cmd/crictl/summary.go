@@ -0,0 +1,355 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+var summaryCommand = &cli.Command{
+	Name:  "summary",
+	Usage: "Display the resource usage of the node in the kubelet stats summary format",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output format, One of: json|yaml|go-template|jsonpath",
+			Value:   output.FormatJSON,
+		},
+		&cli.StringFlag{
+			Name:  "template",
+			Usage: "The template string is only used when output is go-template or jsonpath; The Template format is golang template or JSONPath",
+		},
+		&cli.IntFlag{
+			Name:    "seconds",
+			Aliases: []string{"s"},
+			Value:   1,
+			Usage:   "Sample duration for the CPU usage in nano cores in seconds, 0 skips the sample",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		printer, err := output.NewPrinter(context.String("output"), context.String("template"))
+		if err != nil {
+			return err
+		}
+		switch printer.Format() {
+		case output.FormatJSON, output.FormatYAML, output.FormatGoTemplate, output.FormatJSONPath:
+		default:
+			return errors.Errorf("unsupported output format %q", context.String("output"))
+		}
+
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		imageClient, imageConn, err := getImageClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, imageConn)
+
+		sample := time.Duration(context.Int("seconds")) * time.Second
+		summary, err := getSummary(runtimeClient, imageClient, sample)
+		if err != nil {
+			return errors.Wrap(err, "get summary")
+		}
+		return printer.PrintList(os.Stdout, summary, nil)
+	},
+}
+
+// The following types mirror the JSON encoding of the kubelet stats summary
+// API (k8s.io/kubelet/pkg/apis/stats/v1alpha1), restricted to the fields
+// which can be filled from CRI data.
+
+type summary struct {
+	Node nodeStats         `json:"node"`
+	Pods []summaryPodStats `json:"pods"`
+}
+
+type nodeStats struct {
+	NodeName  string        `json:"nodeName"`
+	StartTime *metav1.Time  `json:"startTime,omitempty"`
+	Runtime   *runtimeStats `json:"runtime,omitempty"`
+}
+
+type runtimeStats struct {
+	ImageFs *fsStats `json:"imageFs,omitempty"`
+}
+
+type podReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	UID       string `json:"uid"`
+}
+
+type summaryPodStats struct {
+	PodRef           podReference            `json:"podRef"`
+	StartTime        metav1.Time             `json:"startTime"`
+	Containers       []summaryContainerStats `json:"containers"`
+	CPU              *cpuStats               `json:"cpu,omitempty"`
+	Memory           *memoryStats            `json:"memory,omitempty"`
+	EphemeralStorage *fsStats                `json:"ephemeral-storage,omitempty"`
+}
+
+type summaryContainerStats struct {
+	Name      string       `json:"name"`
+	StartTime metav1.Time  `json:"startTime"`
+	CPU       *cpuStats    `json:"cpu,omitempty"`
+	Memory    *memoryStats `json:"memory,omitempty"`
+	Rootfs    *fsStats     `json:"rootfs,omitempty"`
+	Logs      *fsStats     `json:"logs,omitempty"`
+}
+
+type cpuStats struct {
+	Time                 metav1.Time `json:"time"`
+	UsageNanoCores       *uint64     `json:"usageNanoCores,omitempty"`
+	UsageCoreNanoSeconds *uint64     `json:"usageCoreNanoSeconds,omitempty"`
+}
+
+type memoryStats struct {
+	Time            metav1.Time `json:"time"`
+	WorkingSetBytes *uint64     `json:"workingSetBytes,omitempty"`
+}
+
+type fsStats struct {
+	Time       metav1.Time `json:"time"`
+	UsedBytes  *uint64     `json:"usedBytes,omitempty"`
+	InodesUsed *uint64     `json:"inodesUsed,omitempty"`
+}
+
+// getSummary builds the stats summary of the ready pods and their running
+// containers. The CPU usage in nano cores is sampled over sample if it is
+// not zero.
+func getSummary(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageServiceClient, sample time.Duration) (*summary, error) {
+	ctx := context.Background()
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	s := &summary{Node: nodeStats{NodeName: hostname}, Pods: []summaryPodStats{}}
+	if boot, err := bootTime(procStatPath); err != nil {
+		logrus.Debugf("Unable to get the boot time of the node: %v", err)
+	} else {
+		start := metav1.NewTime(boot)
+		s.Node.StartTime = &start
+	}
+
+	fsInfo, err := ImageFsInfo(imageClient)
+	if err != nil {
+		return nil, err
+	}
+	if filesystems := fsInfo.GetImageFilesystems(); len(filesystems) > 0 {
+		s.Node.Runtime = &runtimeStats{ImageFs: newFsStats(filesystems[0])}
+	}
+
+	pods, err := runtimeClient.ListPodSandbox(ctx, &pb.ListPodSandboxRequest{
+		Filter: &pb.PodSandboxFilter{State: &pb.PodSandboxStateValue{State: pb.PodSandboxState_SANDBOX_READY}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	containers, err := runtimeClient.ListContainers(ctx, &pb.ListContainersRequest{
+		Filter: &pb.ContainerFilter{State: &pb.ContainerStateValue{State: pb.ContainerState_CONTAINER_RUNNING}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request := &pb.ListContainerStatsRequest{Filter: &pb.ContainerStatsFilter{}}
+	stats := make(map[string]statsRow)
+	if sample > 0 {
+		rows, err := sampleStats(ctx, runtimeClient, request, nil, statsOptions{all: true, sample: sample})
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range rows {
+			stats[row.stats.GetAttributes().GetId()] = row
+		}
+	} else {
+		r, err := getContainerStats(ctx, runtimeClient, request, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, cs := range r.Stats {
+			stats[cs.GetAttributes().GetId()] = statsRow{stats: cs}
+		}
+	}
+
+	podContainers := make(map[string][]*pb.Container)
+	for _, c := range containers.Containers {
+		podContainers[c.PodSandboxId] = append(podContainers[c.PodSandboxId], c)
+	}
+	sort.Sort(sandboxByCreated(pods.Items))
+	for _, pod := range pods.Items {
+		ps := summaryPodStats{
+			PodRef: podReference{
+				Name:      pod.GetMetadata().GetName(),
+				Namespace: pod.GetMetadata().GetNamespace(),
+				UID:       pod.GetMetadata().GetUid(),
+			},
+			StartTime:  unixNanoTime(pod.CreatedAt),
+			Containers: []summaryContainerStats{},
+		}
+		for _, c := range podContainers[pod.Id] {
+			row, ok := stats[c.Id]
+			if !ok {
+				continue
+			}
+			cs := newSummaryContainerStats(c, row, sample > 0)
+			_, logBytes, err := containerLogSize(ctx, runtimeClient, c.Id)
+			if err != nil {
+				logrus.Warnf("Unable to get the log size of container %s: %v", c.Id, err)
+			} else {
+				cs.Logs = &fsStats{Time: metav1.Now(), UsedBytes: &logBytes}
+			}
+			ps.Containers = append(ps.Containers, cs)
+		}
+		addPodTotals(&ps)
+		s.Pods = append(s.Pods, ps)
+	}
+	return s, nil
+}
+
+func newSummaryContainerStats(c *pb.Container, row statsRow, sampled bool) summaryContainerStats {
+	s := row.stats
+	cs := summaryContainerStats{Name: c.GetMetadata().GetName(), StartTime: unixNanoTime(c.CreatedAt)}
+	if cpu := s.GetCpu(); cpu != nil {
+		cs.CPU = &cpuStats{Time: unixNanoTime(cpu.Timestamp), UsageCoreNanoSeconds: uint64Value(cpu.UsageCoreNanoSeconds)}
+		if sampled {
+			nanoCores := uint64(row.cpuPerc / 100 * float64(time.Second))
+			cs.CPU.UsageNanoCores = &nanoCores
+		}
+	}
+	if memory := s.GetMemory(); memory != nil {
+		cs.Memory = &memoryStats{Time: unixNanoTime(memory.Timestamp), WorkingSetBytes: uint64Value(memory.WorkingSetBytes)}
+	}
+	if fs := s.GetWritableLayer(); fs != nil {
+		cs.Rootfs = newFsStats(fs)
+	}
+	return cs
+}
+
+// addPodTotals sums up the usage of the containers of a pod. The ephemeral
+// storage of a pod is the usage of the writable layers and the logs of its
+// containers.
+func addPodTotals(ps *summaryPodStats) {
+	for _, cs := range ps.Containers {
+		if cs.CPU != nil {
+			if ps.CPU == nil {
+				ps.CPU = &cpuStats{Time: cs.CPU.Time}
+			}
+			ps.CPU.UsageNanoCores = addUint64(ps.CPU.UsageNanoCores, cs.CPU.UsageNanoCores)
+			ps.CPU.UsageCoreNanoSeconds = addUint64(ps.CPU.UsageCoreNanoSeconds, cs.CPU.UsageCoreNanoSeconds)
+		}
+		if cs.Memory != nil {
+			if ps.Memory == nil {
+				ps.Memory = &memoryStats{Time: cs.Memory.Time}
+			}
+			ps.Memory.WorkingSetBytes = addUint64(ps.Memory.WorkingSetBytes, cs.Memory.WorkingSetBytes)
+		}
+		for _, fs := range []*fsStats{cs.Rootfs, cs.Logs} {
+			if fs == nil {
+				continue
+			}
+			if ps.EphemeralStorage == nil {
+				ps.EphemeralStorage = &fsStats{Time: fs.Time}
+			}
+			ps.EphemeralStorage.UsedBytes = addUint64(ps.EphemeralStorage.UsedBytes, fs.UsedBytes)
+			ps.EphemeralStorage.InodesUsed = addUint64(ps.EphemeralStorage.InodesUsed, fs.InodesUsed)
+		}
+	}
+}
+
+func newFsStats(fs *pb.FilesystemUsage) *fsStats {
+	return &fsStats{
+		Time:       unixNanoTime(fs.Timestamp),
+		UsedBytes:  uint64Value(fs.UsedBytes),
+		InodesUsed: uint64Value(fs.InodesUsed),
+	}
+}
+
+// procStatPath is the kernel statistics file of Linux, which contains the
+// boot time.
+const procStatPath = "/proc/stat"
+
+// bootTime returns the boot time from the btime line of a /proc/stat file,
+// which the kubelet reports as the start time of the node.
+func bootTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "parse btime of %s", path)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, err
+	}
+	return time.Time{}, errors.Errorf("no btime in %s", path)
+}
+
+func unixNanoTime(ns int64) metav1.Time {
+	return metav1.NewTime(time.Unix(0, ns))
+}
+
+func uint64Value(v *pb.UInt64Value) *uint64 {
+	if v == nil {
+		return nil
+	}
+	value := v.Value
+	return &value
+}
+
+// addUint64 returns the sum of a and b, or nil if both are nil.
+func addUint64(a, b *uint64) *uint64 {
+	if a == nil && b == nil {
+		return nil
+	}
+	var sum uint64
+	for _, v := range []*uint64{a, b} {
+		if v != nil {
+			sum += *v
+		}
+	}
+	return &sum
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestGetSummary(t *testing.T) {
+	runtimeClient := &fakeRuntimeClient{
+		sandboxes: []*pb.PodSandbox{
+			{Id: "p1", Metadata: &pb.PodSandboxMetadata{Name: "web", Namespace: "default", Uid: "uid1"}},
+		},
+		containers: []*pb.Container{
+			{Id: "c1", PodSandboxId: "p1", Metadata: &pb.ContainerMetadata{Name: "nginx"}},
+			{Id: "c2", PodSandboxId: "p1", Metadata: &pb.ContainerMetadata{Name: "sidecar"}},
+		},
+		stats:    []*pb.ContainerStats{containerStats("c1", 10, 100, 1000), containerStats("c2", 20, 200, 2000)},
+		statuses: map[string]*pb.ContainerStatusResponse{"c1": {Status: &pb.ContainerStatus{}}},
+	}
+	imageClient := &fakeImageClient{
+		filesystems: []*pb.FilesystemUsage{{UsedBytes: &pb.UInt64Value{Value: 5000}, InodesUsed: &pb.UInt64Value{Value: 50}}},
+	}
+
+	s, err := getSummary(runtimeClient, imageClient, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Node struct {
+			Runtime struct {
+				ImageFs struct{ UsedBytes, InodesUsed uint64 }
+			}
+		}
+		Pods []struct {
+			PodRef     struct{ Name, Namespace, UID string }
+			Containers []struct {
+				Name   string
+				CPU    map[string]interface{}
+				Rootfs struct{ UsedBytes uint64 }
+				Logs   *struct{ UsedBytes uint64 }
+			}
+			CPU              struct{ UsageCoreNanoSeconds uint64 }
+			Memory           struct{ WorkingSetBytes uint64 }
+			EphemeralStorage struct{ UsedBytes, InodesUsed uint64 } `json:"ephemeral-storage"`
+		}
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if fs := decoded.Node.Runtime.ImageFs; fs.UsedBytes != 5000 || fs.InodesUsed != 50 {
+		t.Errorf("unexpected image filesystem stats %+v", fs)
+	}
+	if len(decoded.Pods) != 1 {
+		t.Fatalf("expected 1 pod, but got %s", data)
+	}
+	pod := decoded.Pods[0]
+	if pod.PodRef.Name != "web" || pod.PodRef.Namespace != "default" || pod.PodRef.UID != "uid1" {
+		t.Errorf("unexpected pod reference %+v", pod.PodRef)
+	}
+	if pod.CPU.UsageCoreNanoSeconds != 30 || pod.Memory.WorkingSetBytes != 300 {
+		t.Errorf("unexpected pod usage in %s", data)
+	}
+	// The log size of c2 is unknown because its status fails.
+	if pod.EphemeralStorage.UsedBytes != 3000 || pod.EphemeralStorage.InodesUsed != 2 {
+		t.Errorf("unexpected pod ephemeral storage %+v", pod.EphemeralStorage)
+	}
+	if len(pod.Containers) != 2 || pod.Containers[0].Name != "nginx" || pod.Containers[0].Rootfs.UsedBytes != 1000 {
+		t.Fatalf("unexpected containers in %s", data)
+	}
+	if pod.Containers[0].Logs == nil || pod.Containers[1].Logs != nil {
+		t.Errorf("expected only the logs of nginx, but got %s", data)
+	}
+	if _, ok := pod.Containers[0].CPU["usageNanoCores"]; ok {
+		t.Errorf("expected no usageNanoCores without a sample, but got %s", data)
+	}
+}
+
+func TestBootTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "stat")
+	stat := "cpu  10 0 20 300 0 0 0 0 0 0\nintr 12345 0 1\nctxt 6789\nbtime 1614600000\nprocesses 42\n"
+	if err := ioutil.WriteFile(path, []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	boot, err := bootTime(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Unix(1614600000, 0); !boot.Equal(expected) {
+		t.Errorf("expected boot time %v, but got %v", expected, boot)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("cpu  10 0 20 300 0 0 0 0 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bootTime(path); err == nil {
+		t.Error("expected an error without btime")
+	}
+}
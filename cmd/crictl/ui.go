@@ -0,0 +1,628 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	dockerterm "github.com/docker/docker/pkg/term"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/kuberuntime/logs"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+const (
+	// uiLogTailLines is the number of log lines shown by the logs view.
+	uiLogTailLines = 500
+	// uiStopTimeout is the timeout in seconds for stopping containers.
+	uiStopTimeout = 10
+)
+
+var uiCommand = &cli.Command{
+	Name:  "ui",
+	Usage: "Display an interactive view of the pods, containers and images",
+	Description: `Keys:
+   tab, 1, 2, 3    switch between the pods, containers and images panes
+   up, down, j, k  select a row, page up and page down scroll by pages
+   enter, i        inspect the selected row
+   l               tail the logs of the selected container
+   e               exec a shell in the selected container
+   s               stop the selected pod or container
+   d               remove the selected pod, container or image
+   r               refresh now
+   esc             return from the inspect and logs views
+   q, ctrl-c       quit`,
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:    "interval",
+			Aliases: []string{"n"},
+			Value:   2 * time.Second,
+			Usage:   "Refresh interval of the views",
+		},
+		&cli.StringFlag{
+			Name:  "shell",
+			Value: "sh",
+			Usage: "Shell to exec in containers",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Duration("interval") <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		runtimeClient, runtimeConn, err := getRuntimeClient(context)
+		if err != nil {
+			return err
+		}
+		defer closeConnection(context, runtimeConn)
+
+		// Share the runtime connection unless a different image endpoint is
+		// configured.
+		imageClient := pb.NewImageServiceClient(runtimeConn)
+		if ImageEndpoint != "" && ImageEndpoint != RuntimeEndpoint {
+			var imageConn *grpc.ClientConn
+			imageClient, imageConn, err = getImageClient(context)
+			if err != nil {
+				return err
+			}
+			defer closeConnection(context, imageConn)
+		}
+
+		u := newUI(runtimeClient, imageClient, context.Duration("interval"), context.String("shell"))
+		if err := u.run(); err != nil {
+			return errors.Wrap(err, "run ui")
+		}
+		return nil
+	},
+}
+
+// uiPane is one of the lists shown by the ui.
+type uiPane int
+
+const (
+	panePods uiPane = iota
+	paneContainers
+	paneImages
+	numPanes
+)
+
+func (p uiPane) String() string {
+	return [...]string{"Pods", "Containers", "Images"}[p]
+}
+
+// uiMode is the view shown by the ui.
+type uiMode int
+
+const (
+	modeList uiMode = iota
+	modeInspect
+	modeLogs
+	modeConfirm
+)
+
+// uiConfirmation is an action waiting for the confirmation of the user.
+type uiConfirmation struct {
+	prompt string
+	action func() (string, error)
+}
+
+// ui is an interactive terminal view of the pods, containers and images of
+// the runtime.
+type ui struct {
+	runtimeClient pb.RuntimeServiceClient
+	imageClient   pb.ImageServiceClient
+	interval      time.Duration
+	shell         string
+
+	out     io.Writer
+	stdin   io.ReadCloser
+	fd      uintptr
+	state   *dockerterm.State
+	runtime string
+
+	pane     uiPane
+	selected [numPanes]int
+	offset   [numPanes]int
+
+	mode         uiMode
+	detailTitle  string
+	detailID     string
+	detail       []string
+	detailOffset int
+	confirmation *uiConfirmation
+	message      string
+
+	pods       []*pb.PodSandbox
+	containers []*pb.Container
+	images     []*pb.Image
+	history    map[string]*statsHistory
+	lastStats  map[string]*pb.ContainerStats
+}
+
+func newUI(runtimeClient pb.RuntimeServiceClient, imageClient pb.ImageServiceClient, interval time.Duration, shell string) *ui {
+	return &ui{
+		runtimeClient: runtimeClient,
+		imageClient:   imageClient,
+		interval:      interval,
+		shell:         shell,
+		pane:          paneContainers,
+		history:       make(map[string]*statsHistory),
+		lastStats:     make(map[string]*pb.ContainerStats),
+	}
+}
+
+// run shows the ui until the user quits.
+func (u *ui) run() error {
+	stdin, stdout, _ := dockerterm.StdStreams()
+	fd, isTerminal := dockerterm.GetFdInfo(stdin)
+	if !isTerminal {
+		return fmt.Errorf("input is not a terminal")
+	}
+	u.stdin, u.out, u.fd = stdin, stdout, fd
+
+	// Log messages would corrupt the screen.
+	logrus.SetOutput(ioutil.Discard)
+
+	if r, err := u.runtimeClient.Version(context.Background(), &pb.VersionRequest{}); err == nil {
+		u.runtime = r.RuntimeName + " " + r.RuntimeVersion
+	}
+
+	if err := u.resume(); err != nil {
+		return err
+	}
+	defer u.suspend()
+
+	// Only one read of stdin is pending at a time, and the next one is
+	// requested once the previous keys have been handled. This hands the
+	// terminal over to exec without a reader racing for its input.
+	keys := make(chan []string)
+	next := make(chan struct{}, 1)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 64)
+		for range next {
+			n, err := u.stdin.Read(buf)
+			if err != nil {
+				readErr <- err
+				return
+			}
+			keys <- parseKeys(buf[:n])
+		}
+	}()
+
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	u.refresh()
+	u.draw()
+	next <- struct{}{}
+	for {
+		select {
+		case ks := <-keys:
+			for _, key := range ks {
+				if u.handleKey(key) {
+					return nil
+				}
+			}
+			u.draw()
+			next <- struct{}{}
+		case <-ticker.C:
+			u.refresh()
+			u.draw()
+		case err := <-readErr:
+			return err
+		case <-SetupInterruptSignalHandler():
+			return nil
+		}
+	}
+}
+
+// resume puts the terminal into raw mode and switches to the alternate
+// screen.
+func (u *ui) resume() error {
+	state, err := dockerterm.MakeRaw(u.fd)
+	if err != nil {
+		return err
+	}
+	u.state = state
+	fmt.Fprint(u.out, "\033[?1049h\033[?25l")
+	return nil
+}
+
+// suspend restores the terminal.
+func (u *ui) suspend() {
+	fmt.Fprint(u.out, "\033[?25h\033[?1049l")
+	if u.state != nil {
+		dockerterm.RestoreTerminal(u.fd, u.state)
+		u.state = nil
+	}
+}
+
+// refresh lists the pods, containers, images and container stats again.
+func (u *ui) refresh() {
+	ctx := context.Background()
+	var errs []string
+
+	if r, err := u.runtimeClient.ListPodSandbox(ctx, &pb.ListPodSandboxRequest{}); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		u.pods = r.Items
+		sort.Sort(sandboxByCreated(u.pods))
+	}
+	if r, err := u.runtimeClient.ListContainers(ctx, &pb.ListContainersRequest{}); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		u.containers = r.Containers
+		sort.Sort(containerByCreated(u.containers))
+	}
+	if r, err := u.imageClient.ListImages(ctx, &pb.ListImagesRequest{}); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		u.images = r.Images
+		sort.Sort(imageByRef(u.images))
+	}
+	if r, err := u.runtimeClient.ListContainerStats(ctx, &pb.ListContainerStatsRequest{}); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		u.updateStats(r.Stats)
+	}
+
+	if u.mode == modeLogs {
+		u.loadLogs(u.detailID)
+	}
+	if len(errs) > 0 {
+		u.message = strings.Join(errs, "; ")
+	}
+	for pane := panePods; pane < numPanes; pane++ {
+		u.selected[pane] = clampIndex(u.selected[pane], u.paneLen(pane))
+	}
+}
+
+// updateStats records the CPU and memory usage of the containers in their
+// history.
+func (u *ui) updateStats(stats []*pb.ContainerStats) {
+	lastStats := make(map[string]*pb.ContainerStats, len(stats))
+	for _, s := range stats {
+		id := s.GetAttributes().GetId()
+		h, ok := u.history[id]
+		if !ok {
+			h = &statsHistory{}
+			u.history[id] = h
+		}
+		if old, ok := u.lastStats[id]; ok {
+			h.addCPU(cpuPercent(old, s))
+		}
+		h.addMemory(float64(s.GetMemory().GetWorkingSetBytes().GetValue()))
+		lastStats[id] = s
+	}
+	for id := range u.history {
+		if _, ok := lastStats[id]; !ok {
+			delete(u.history, id)
+		}
+	}
+	u.lastStats = lastStats
+}
+
+func (u *ui) paneLen(pane uiPane) int {
+	switch pane {
+	case panePods:
+		return len(u.pods)
+	case paneContainers:
+		return len(u.containers)
+	default:
+		return len(u.images)
+	}
+}
+
+// handleKey handles a key press and returns true if the ui should quit.
+func (u *ui) handleKey(key string) bool {
+	if key == keyCtrlC {
+		return true
+	}
+	switch u.mode {
+	case modeConfirm:
+		c := u.confirmation
+		u.mode, u.confirmation = modeList, nil
+		if key != "y" && key != "Y" {
+			u.message = "Cancelled"
+			return false
+		}
+		msg, err := c.action()
+		if err != nil {
+			u.message = err.Error()
+			return false
+		}
+		u.message = msg
+		u.refresh()
+	case modeInspect, modeLogs:
+		u.scrollDetail(key)
+	default:
+		return u.handleListKey(key)
+	}
+	return false
+}
+
+func (u *ui) handleListKey(key string) bool {
+	u.message = ""
+	n := u.paneLen(u.pane)
+	page := u.bodyHeight() - 1
+	switch key {
+	case "q":
+		return true
+	case keyTab:
+		u.pane = (u.pane + 1) % numPanes
+	case "1", "2", "3":
+		u.pane = uiPane(key[0] - '1')
+	case keyUp, "k":
+		u.selected[u.pane] = clampIndex(u.selected[u.pane]-1, n)
+	case keyDown, "j":
+		u.selected[u.pane] = clampIndex(u.selected[u.pane]+1, n)
+	case keyPageUp:
+		u.selected[u.pane] = clampIndex(u.selected[u.pane]-page, n)
+	case keyPageDown:
+		u.selected[u.pane] = clampIndex(u.selected[u.pane]+page, n)
+	case keyHome, "g":
+		u.selected[u.pane] = 0
+	case keyEnd, "G":
+		u.selected[u.pane] = clampIndex(n-1, n)
+	case "r":
+		u.refresh()
+	case keyEnter, "i":
+		u.inspect()
+	case "l":
+		if c := u.selectedContainer(); c != nil {
+			u.mode, u.detailID, u.detailOffset = modeLogs, c.Id, -1
+			u.detailTitle = "Logs of container " + c.Metadata.GetName()
+			u.loadLogs(c.Id)
+		}
+	case "e":
+		if c := u.selectedContainer(); c != nil {
+			u.exec(c.Id)
+		}
+	case "s":
+		u.confirmStop()
+	case "d":
+		u.confirmRemove()
+	}
+	return false
+}
+
+// selectedContainer returns the selected container, or nil with a message
+// if no container is selected.
+func (u *ui) selectedContainer() *pb.Container {
+	if u.pane != paneContainers || len(u.containers) == 0 {
+		u.message = "Select a container first"
+		return nil
+	}
+	return u.containers[u.selected[paneContainers]]
+}
+
+func (u *ui) scrollDetail(key string) {
+	page := u.bodyHeight() - 1
+	maxOffset := len(u.detail) - page
+	if maxOffset < 0 {
+		maxOffset = 0
+	}
+	offset := u.detailOffset
+	if offset < 0 {
+		offset = maxOffset
+	}
+	switch key {
+	case keyEsc, "q":
+		u.mode, u.detail, u.detailID = modeList, nil, ""
+		return
+	case keyUp, "k":
+		offset--
+	case keyDown, "j":
+		offset++
+	case keyPageUp:
+		offset -= page
+	case keyPageDown:
+		offset += page
+	case keyHome, "g":
+		offset = 0
+	case keyEnd, "G":
+		offset = maxOffset
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= maxOffset {
+		// Follow new log lines at the end of the logs.
+		offset = maxOffset
+		if u.mode == modeLogs {
+			offset = -1
+		}
+	}
+	u.detailOffset = offset
+}
+
+// inspect shows the verbose status of the selected row.
+func (u *ui) inspect() {
+	if u.paneLen(u.pane) == 0 {
+		return
+	}
+	ctx := context.Background()
+	idx := u.selected[u.pane]
+	var (
+		status string
+		info   map[string]string
+		err    error
+	)
+	switch u.pane {
+	case panePods:
+		pod := u.pods[idx]
+		u.detailTitle = "Pod " + pod.Metadata.GetName()
+		var r *pb.PodSandboxStatusResponse
+		if r, err = u.runtimeClient.PodSandboxStatus(ctx, &pb.PodSandboxStatusRequest{PodSandboxId: pod.Id, Verbose: true}); err == nil {
+			status, err = marshalPodSandboxStatus(r.Status)
+			info = r.Info
+		}
+	case paneContainers:
+		c := u.containers[idx]
+		u.detailTitle = "Container " + c.Metadata.GetName()
+		var r *pb.ContainerStatusResponse
+		if r, err = u.runtimeClient.ContainerStatus(ctx, &pb.ContainerStatusRequest{ContainerId: c.Id, Verbose: true}); err == nil {
+			status, err = marshalContainerStatus(r.Status)
+			info = r.Info
+		}
+	case paneImages:
+		image := u.images[idx]
+		u.detailTitle = "Image " + imageName(image)
+		var r *pb.ImageStatusResponse
+		if r, err = u.imageClient.ImageStatus(ctx, &pb.ImageStatusRequest{Image: &pb.ImageSpec{Image: image.Id}, Verbose: true}); err == nil {
+			if r.Image == nil {
+				err = fmt.Errorf("no such image %s", image.Id)
+			} else {
+				status, err = protobufObjectToJSON(r.Image)
+				info = r.Info
+			}
+		}
+	}
+	var data json.RawMessage
+	if err == nil {
+		data, err = marshalStatusInfo(status, info)
+	}
+	if err == nil {
+		data, err = output.Marshal(data)
+	}
+	if err != nil {
+		u.message = err.Error()
+		return
+	}
+	u.mode, u.detailOffset = modeInspect, 0
+	u.detail = strings.Split(string(data), "\n")
+}
+
+// loadLogs reads the last log lines of a container into the logs view.
+func (u *ui) loadLogs(id string) {
+	r, err := u.runtimeClient.ContainerStatus(context.Background(), &pb.ContainerStatusRequest{ContainerId: id})
+	if err != nil {
+		u.message = err.Error()
+		return
+	}
+	logPath := r.GetStatus().GetLogPath()
+	if logPath == "" {
+		u.message = "The container has not set log path"
+		return
+	}
+	tailLines := int64(uiLogTailLines)
+	logOptions := logs.NewLogOptions(&v1.PodLogOptions{TailLines: &tailLines}, time.Now())
+	var buf bytes.Buffer
+	if err := logs.ReadLogs(context.Background(), logPath, id, logOptions, nil, &buf, &buf); err != nil {
+		u.message = err.Error()
+		return
+	}
+	u.detail = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+}
+
+// exec runs a shell in a container. The ui is suspended while the shell
+// runs.
+func (u *ui) exec(id string) {
+	u.suspend()
+	fmt.Fprintf(u.out, "Executing %s in container %s, exit the shell to return.\n", u.shell, getTruncatedID(id, ""))
+	err := Exec(u.runtimeClient, execOptions{
+		id:    id,
+		tty:   true,
+		stdin: true,
+		cmd:   []string{u.shell},
+	})
+	if err := u.resume(); err != nil {
+		u.message = err.Error()
+		return
+	}
+	if err != nil {
+		u.message = errors.Wrap(err, "exec").Error()
+	}
+}
+
+func (u *ui) confirmStop() {
+	if u.paneLen(u.pane) == 0 {
+		return
+	}
+	ctx := context.Background()
+	switch u.pane {
+	case panePods:
+		pod := u.pods[u.selected[panePods]]
+		u.confirm("Stop pod "+pod.Metadata.GetName(), func() (string, error) {
+			_, err := u.runtimeClient.StopPodSandbox(ctx, &pb.StopPodSandboxRequest{PodSandboxId: pod.Id})
+			return "Stopped pod " + pod.Metadata.GetName(), err
+		})
+	case paneContainers:
+		c := u.containers[u.selected[paneContainers]]
+		u.confirm("Stop container "+c.Metadata.GetName(), func() (string, error) {
+			_, err := u.runtimeClient.StopContainer(ctx, &pb.StopContainerRequest{ContainerId: c.Id, Timeout: uiStopTimeout})
+			return "Stopped container " + c.Metadata.GetName(), err
+		})
+	default:
+		u.message = "Images cannot be stopped"
+	}
+}
+
+func (u *ui) confirmRemove() {
+	if u.paneLen(u.pane) == 0 {
+		return
+	}
+	ctx := context.Background()
+	switch u.pane {
+	case panePods:
+		pod := u.pods[u.selected[panePods]]
+		u.confirm("Remove pod "+pod.Metadata.GetName(), func() (string, error) {
+			_, err := u.runtimeClient.RemovePodSandbox(ctx, &pb.RemovePodSandboxRequest{PodSandboxId: pod.Id})
+			return "Removed pod " + pod.Metadata.GetName(), err
+		})
+	case paneContainers:
+		c := u.containers[u.selected[paneContainers]]
+		u.confirm("Remove container "+c.Metadata.GetName(), func() (string, error) {
+			_, err := u.runtimeClient.RemoveContainer(ctx, &pb.RemoveContainerRequest{ContainerId: c.Id})
+			return "Removed container " + c.Metadata.GetName(), err
+		})
+	case paneImages:
+		image := u.images[u.selected[paneImages]]
+		u.confirm("Remove image "+imageName(image), func() (string, error) {
+			_, err := u.imageClient.RemoveImage(ctx, &pb.RemoveImageRequest{Image: &pb.ImageSpec{Image: image.Id}})
+			return "Removed image " + imageName(image), err
+		})
+	}
+}
+
+func (u *ui) confirm(prompt string, action func() (string, error)) {
+	u.mode = modeConfirm
+	u.confirmation = &uiConfirmation{prompt: prompt, action: action}
+}
+
+func clampIndex(i, n int) int {
+	if i >= n {
+		i = n - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected []string
+	}{
+		{"q", []string{"q"}},
+		{"\033[A\033[B", []string{keyUp, keyDown}},
+		{"\033", []string{keyEsc}},
+		{"\r\t\x03", []string{keyEnter, keyTab, keyCtrlC}},
+		{"\033[6~j", []string{keyPageDown, "j"}},
+		// Unknown escape sequences are skipped.
+		{"\033[1;5Cd", []string{"d"}},
+	}
+	for _, tc := range testCases {
+		if keys := parseKeys([]byte(tc.input)); !reflect.DeepEqual(keys, tc.expected) {
+			t.Errorf("input %q: expected %q, but got %q", tc.input, tc.expected, keys)
+		}
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if s := sparkline([]float64{0, 50, 100}); s != "▁▅█" {
+		t.Errorf("expected %q, but got %q", "▁▅█", s)
+	}
+	if s := sparkline([]float64{0, 0}); s != "▁▁" {
+		t.Errorf("expected a flat sparkline, but got %q", s)
+	}
+
+	var samples []float64
+	for i := 0; i < statsHistorySize+5; i++ {
+		samples = appendSample(samples, float64(i))
+	}
+	if len(samples) != statsHistorySize || lastSample(samples) != float64(statsHistorySize+4) {
+		t.Errorf("expected the last %d samples, but got %v", statsHistorySize, samples)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	line := attrReverse + "héllo world" + attrReset
+	if s := truncate(line, 5); s != attrReverse+"héllo"+attrReset {
+		t.Errorf("expected the escape sequences to be kept, but got %q", s)
+	}
+	if s := truncate("short", 10); s != "short" {
+		t.Errorf("expected %q, but got %q", "short", s)
+	}
+}
@@ -0,0 +1,469 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	dockerterm "github.com/docker/docker/pkg/term"
+	"github.com/docker/go-units"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// statsHistorySize is the number of samples shown by the sparklines.
+const statsHistorySize = 20
+
+// Terminal attributes used by the ui.
+const (
+	attrBold    = "\033[1m"
+	attrReverse = "\033[7m"
+	attrReset   = "\033[0m"
+)
+
+// Names of the special keys.
+const (
+	keyUp       = "up"
+	keyDown     = "down"
+	keyPageUp   = "pgup"
+	keyPageDown = "pgdn"
+	keyHome     = "home"
+	keyEnd      = "end"
+	keyEnter    = "enter"
+	keyTab      = "tab"
+	keyEsc      = "esc"
+	keyCtrlC    = "ctrl-c"
+)
+
+var keySequences = []struct {
+	seq string
+	key string
+}{
+	{"\033[A", keyUp},
+	{"\033OA", keyUp},
+	{"\033[B", keyDown},
+	{"\033OB", keyDown},
+	{"\033[5~", keyPageUp},
+	{"\033[6~", keyPageDown},
+	{"\033[H", keyHome},
+	{"\033[1~", keyHome},
+	{"\033[F", keyEnd},
+	{"\033[4~", keyEnd},
+}
+
+// sparkTicks are the characters of a sparkline from the lowest to the
+// highest value.
+var sparkTicks = []rune("▁▂▃▄▅▆▇█")
+
+// statsHistory holds the recent CPU and memory usage of a container.
+type statsHistory struct {
+	cpu    []float64
+	memory []float64
+}
+
+func (h *statsHistory) addCPU(v float64) {
+	h.cpu = appendSample(h.cpu, v)
+}
+
+func (h *statsHistory) addMemory(v float64) {
+	h.memory = appendSample(h.memory, v)
+}
+
+func appendSample(samples []float64, v float64) []float64 {
+	samples = append(samples, v)
+	if len(samples) > statsHistorySize {
+		samples = samples[len(samples)-statsHistorySize:]
+	}
+	return samples
+}
+
+func lastSample(samples []float64) float64 {
+	if len(samples) == 0 {
+		return 0
+	}
+	return samples[len(samples)-1]
+}
+
+// cpuPercent returns the CPU usage percentage of a container between two
+// samples.
+func cpuPercent(old, s *pb.ContainerStats) float64 {
+	cpu := s.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+	oldCPU := old.GetCpu().GetUsageCoreNanoSeconds().GetValue()
+	duration := s.GetCpu().GetTimestamp() - old.GetCpu().GetTimestamp()
+	if duration <= 0 || cpu < oldCPU {
+		return 0
+	}
+	return float64(cpu-oldCPU) / float64(duration) * 100
+}
+
+// sparkline renders samples as a line of block characters, scaled to the
+// largest sample.
+func sparkline(samples []float64) string {
+	var max float64
+	for _, v := range samples {
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range samples {
+		idx := 0
+		if max > 0 {
+			idx = int(v/max*float64(len(sparkTicks)-1) + 0.5)
+		}
+		b.WriteRune(sparkTicks[idx])
+	}
+	return b.String()
+}
+
+// parseKeys splits the input of the terminal into key presses. Special keys
+// are returned by their names and other keys as they are.
+func parseKeys(input []byte) []string {
+	var keys []string
+	for len(input) > 0 {
+		matched := false
+		for _, s := range keySequences {
+			if bytes.HasPrefix(input, []byte(s.seq)) {
+				keys = append(keys, s.key)
+				input = input[len(s.seq):]
+				matched = true
+				break
+			}
+		}
+		if matched {
+			continue
+		}
+		switch c := input[0]; {
+		case c == '\033' && len(input) > 1 && input[1] == '[':
+			// Skip unknown escape sequences up to their final byte.
+			end := 2
+			for end < len(input) && (input[end] < 0x40 || input[end] > 0x7e) {
+				end++
+			}
+			input = input[minInt(end+1, len(input)):]
+			continue
+		case c == '\033':
+			keys = append(keys, keyEsc)
+		case c == '\r' || c == '\n':
+			keys = append(keys, keyEnter)
+		case c == '\t':
+			keys = append(keys, keyTab)
+		case c == 3:
+			keys = append(keys, keyCtrlC)
+		case c >= 0x20 && c < 0x7f:
+			keys = append(keys, string(c))
+		}
+		input = input[1:]
+	}
+	return keys
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// truncate cuts s to width visible characters. Escape sequences do not
+// count towards the width and are kept.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	visible := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			end := i + 1
+			for end < len(s) && (s[end] < 0x40 || s[end] > 0x7e || s[end] == '[') {
+				end++
+			}
+			end = minInt(end+1, len(s))
+			b.WriteString(s[i:end])
+			i = end
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if visible < width {
+			b.WriteRune(r)
+			visible++
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// sanitize makes a line of logs or inspect output safe to print.
+func sanitize(line string) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, line)
+}
+
+// formatTable aligns the cells of rows and returns the resulting lines.
+func formatTable(rows [][]string) []string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func imageName(image *pb.Image) string {
+	if len(image.RepoTags) > 0 {
+		return image.RepoTags[0]
+	}
+	if len(image.RepoDigests) > 0 {
+		return image.RepoDigests[0]
+	}
+	return getTruncatedID(image.Id, "sha256:")
+}
+
+func (u *ui) size() (int, int) {
+	ws, err := dockerterm.GetWinsize(u.fd)
+	if err != nil || ws.Width == 0 || ws.Height == 0 {
+		return 80, 24
+	}
+	return int(ws.Width), int(ws.Height)
+}
+
+// bodyHeight returns the number of lines between the title line and the
+// status and help lines.
+func (u *ui) bodyHeight() int {
+	_, height := u.size()
+	if height < 5 {
+		return 2
+	}
+	return height - 3
+}
+
+// draw renders the ui on the screen.
+func (u *ui) draw() {
+	width, _ := u.size()
+	body := u.bodyHeight()
+
+	lines := []string{u.titleLine()}
+	if u.mode == modeInspect || u.mode == modeLogs {
+		lines = append(lines, u.detailLines(body)...)
+	} else {
+		lines = append(lines, u.listLines(body, width)...)
+	}
+	for len(lines) < body+1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, u.statusLine(), u.helpLine())
+
+	var buf bytes.Buffer
+	buf.WriteString("\033[H")
+	for i, line := range lines {
+		if i > 0 {
+			buf.WriteString("\r\n")
+		}
+		buf.WriteString(truncate(line, width))
+		buf.WriteString("\033[K")
+	}
+	u.out.Write(buf.Bytes())
+}
+
+func (u *ui) titleLine() string {
+	title := attrBold + "crictl ui" + attrReset
+	if u.runtime != "" {
+		title += " - " + u.runtime
+	}
+	if u.mode == modeInspect || u.mode == modeLogs {
+		return title + " - " + u.detailTitle
+	}
+	for pane := panePods; pane < numPanes; pane++ {
+		label := fmt.Sprintf(" %d:%s (%d) ", pane+1, pane, u.paneLen(pane))
+		if pane == u.pane {
+			label = attrReverse + label + attrReset
+		}
+		title += "  " + label
+	}
+	return title
+}
+
+func (u *ui) statusLine() string {
+	if u.mode == modeConfirm {
+		return attrBold + u.confirmation.prompt + "? (y/N)" + attrReset
+	}
+	return u.message
+}
+
+func (u *ui) helpLine() string {
+	switch u.mode {
+	case modeInspect, modeLogs:
+		return "up/down/pgup/pgdn scroll  esc back  ctrl-c quit"
+	case modeConfirm:
+		return "y confirm  any other key cancel"
+	}
+	return "tab pane  up/down select  enter inspect  l logs  e exec  s stop  d remove  r refresh  q quit"
+}
+
+func (u *ui) detailLines(body int) []string {
+	offset := u.detailOffset
+	if maxOffset := len(u.detail) - body; offset < 0 || offset > maxOffset {
+		offset = maxOffset
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	var lines []string
+	for i := offset; i < len(u.detail) && i < offset+body; i++ {
+		lines = append(lines, sanitize(u.detail[i]))
+	}
+	return lines
+}
+
+// listLines renders the table of the current pane, scrolled to the selected
+// row.
+func (u *ui) listLines(body, width int) []string {
+	table := formatTable(u.paneRows(u.pane))
+	header, rows := table[0], table[1:]
+	if u.paneLen(u.pane) == 0 {
+		rows = nil
+	}
+
+	visible := body - 1
+	selected := u.selected[u.pane]
+	offset := u.offset[u.pane]
+	if selected < offset {
+		offset = selected
+	}
+	if selected >= offset+visible {
+		offset = selected - visible + 1
+	}
+	u.offset[u.pane] = offset
+
+	lines := []string{attrBold + header + attrReset}
+	for i := offset; i < len(rows) && i < offset+visible; i++ {
+		line := rows[i]
+		if i == selected {
+			if pad := width - utf8.RuneCountInString(line); pad > 0 {
+				line += strings.Repeat(" ", pad)
+			}
+			line = attrReverse + line + attrReset
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// paneRows returns the header and the rows of a pane.
+func (u *ui) paneRows(pane uiPane) [][]string {
+	switch pane {
+	case panePods:
+		return u.podRows()
+	case paneContainers:
+		return u.containerRows()
+	default:
+		return u.imageRows()
+	}
+}
+
+func (u *ui) podRows() [][]string {
+	type podUsage struct {
+		running, total int
+		cpu, memory    float64
+	}
+	usage := make(map[string]*podUsage)
+	for _, c := range u.containers {
+		pu, ok := usage[c.PodSandboxId]
+		if !ok {
+			pu = &podUsage{}
+			usage[c.PodSandboxId] = pu
+		}
+		pu.total++
+		if c.State == pb.ContainerState_CONTAINER_RUNNING {
+			pu.running++
+		}
+		if h, ok := u.history[c.Id]; ok {
+			pu.cpu += lastSample(h.cpu)
+			pu.memory += lastSample(h.memory)
+		}
+	}
+
+	rows := [][]string{{columnPodID, columnName, columnNamespace, columnState, columnContainers, columnCPU, columnMemory}}
+	for _, pod := range u.pods {
+		pu, ok := usage[pod.Id]
+		if !ok {
+			pu = &podUsage{}
+		}
+		rows = append(rows, []string{
+			getTruncatedID(pod.Id, ""),
+			pod.Metadata.GetName(),
+			pod.Metadata.GetNamespace(),
+			convertPodState(pod.State),
+			fmt.Sprintf("%d/%d", pu.running, pu.total),
+			fmt.Sprintf("%.2f", pu.cpu),
+			units.HumanSize(pu.memory),
+		})
+	}
+	return rows
+}
+
+func (u *ui) containerRows() [][]string {
+	podNames := make(map[string]string, len(u.pods))
+	for _, pod := range u.pods {
+		podNames[pod.Id] = pod.Metadata.GetName()
+	}
+
+	rows := [][]string{{columnContainer, columnName, columnState, columnPod, columnCPU, "CPU HISTORY", columnMemory, "MEM HISTORY"}}
+	for _, c := range u.containers {
+		h, ok := u.history[c.Id]
+		if !ok {
+			h = &statsHistory{}
+		}
+		rows = append(rows, []string{
+			getTruncatedID(c.Id, ""),
+			c.Metadata.GetName(),
+			convertContainerState(c.State),
+			podNames[c.PodSandboxId],
+			fmt.Sprintf("%.2f", lastSample(h.cpu)),
+			sparkline(h.cpu),
+			units.HumanSize(lastSample(h.memory)),
+			sparkline(h.memory),
+		})
+	}
+	return rows
+}
+
+func (u *ui) imageRows() [][]string {
+	inUse := make(map[string]int)
+	for _, c := range u.containers {
+		inUse[c.ImageRef]++
+	}
+
+	rows := [][]string{{columnImageID, columnImage, columnSize, columnContainers}}
+	for _, image := range u.images {
+		rows = append(rows, []string{
+			getTruncatedID(image.Id, "sha256:"),
+			imageName(image),
+			units.HumanSize(float64(image.GetSize_())),
+			fmt.Sprintf("%d", inUse[image.Id]),
+		})
+	}
+	return rows
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -35,9 +36,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
 	"google.golang.org/grpc"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
 	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
-	"sigs.k8s.io/yaml"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
 )
 
 const (
@@ -86,10 +91,14 @@ type listOptions struct {
 	verbose bool
 	// labels are selectors for the sandbox
 	labels map[string]string
+	// labelSelector holds the set based label requirements
+	labelSelector labels.Selector
 	// quiet is for listing just container/sandbox/image IDs
 	quiet bool
 	// output format
 	output string
+	// template string for the go-template, jsonpath and custom-columns output
+	template string
 	// all containers
 	all bool
 	// latest container
@@ -100,6 +109,12 @@ type listOptions struct {
 	noTrunc bool
 	// image used by the container
 	image string
+	// sortBy is a well-known key or JSONPath to sort the list by
+	sortBy string
+	// reverse the sort order
+	reverse bool
+	// watch for changes
+	watch bool
 }
 
 type execOptions struct {
@@ -113,6 +128,12 @@ type execOptions struct {
 	stdin bool
 	// Command to exec
 	cmd []string
+	// transport of the stream, one of auto, websocket or spdy
+	transport string
+	// tlsConfig of the connections to the streaming server
+	tlsConfig *tls.Config
+	// record is the cast file the session is recorded to if set
+	record string
 }
 type attachOptions struct {
 	// id of container
@@ -121,6 +142,14 @@ type attachOptions struct {
 	tty bool
 	// Whether pass Stdin to container
 	stdin bool
+	// transport of the stream, one of auto, websocket or spdy
+	transport string
+	// tlsConfig of the connections to the streaming server
+	tlsConfig *tls.Config
+	// detachKeys leave the stream without closing stdin if set
+	detachKeys []byte
+	// record is the cast file the session is recorded to if set
+	record string
 }
 
 type portforwardOptions struct {
@@ -128,6 +157,34 @@ type portforwardOptions struct {
 	id string
 	// ports to forward
 	ports []string
+	// addresses to listen on
+	addresses []string
+	// socks is the address of the SOCKS5 server forwarding to any port if set
+	socks string
+	// transport of the stream, one of auto, websocket or spdy
+	transport string
+	// tlsConfig of the connections to the streaming server
+	tlsConfig *tls.Config
+}
+
+// sortFlags returns the --sort-by and --reverse flags of a list command
+// supporting the well-known keys.
+func sortFlags(keys output.SortKeys) []cli.Flag {
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "sort-by",
+			Usage: fmt.Sprintf("Sort by one of %s or a JSONPath field, e.g. .metadata.attempt", strings.Join(names, "|")),
+		},
+		&cli.BoolFlag{
+			Name:  "reverse",
+			Usage: "Reverse the order of --sort-by",
+		},
+	}
 }
 
 func getSortedKeys(m map[string]string) []string {
@@ -215,86 +272,75 @@ func protobufObjectToJSON(obj proto.Message) (string, error) {
 	return marshaledJSON, nil
 }
 
-func outputProtobufObjAsJSON(obj proto.Message) error {
-	marshaledJSON, err := protobufObjectToJSON(obj)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(marshaledJSON)
-	return nil
-}
-
-func outputProtobufObjAsYAML(obj proto.Message) error {
-	marshaledJSON, err := protobufObjectToJSON(obj)
-	if err != nil {
-		return err
-	}
-	marshaledYAML, err := yaml.JSONToYAML([]byte(marshaledJSON))
-	if err != nil {
-		return err
-	}
-
-	fmt.Println(string(marshaledYAML))
-	return nil
-}
-
-func outputStatusInfo(status string, info map[string]string, format string, tmplStr string) error {
-	// Sort all keys
-	keys := []string{}
-	for k := range info {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	jsonInfo := "{" + "\"status\":" + status + ","
-	for _, k := range keys {
-		var res interface{}
-		// We attempt to convert key into JSON if possible else use it directly
-		if err := json.Unmarshal([]byte(info[k]), &res); err != nil {
-			jsonInfo += "\"" + k + "\"" + ":" + "\"" + info[k] + "\","
-		} else {
-			jsonInfo += "\"" + k + "\"" + ":" + info[k] + ","
+// marshalStatusInfo merges the status and the verbose info of an inspect
+// response into a single JSON document. Info values which are valid JSON
+// are embedded as is, all other values are embedded as strings.
+func marshalStatusInfo(status string, info map[string]string) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"status":`)
+	buf.WriteString(status)
+	for _, k := range getSortedKeys(info) {
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		value := []byte(info[k])
+		if !json.Valid(value) {
+			if value, err = json.Marshal(info[k]); err != nil {
+				return nil, err
+			}
 		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
 	}
-	jsonInfo = jsonInfo[:len(jsonInfo)-1]
-	jsonInfo += "}"
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
 
-	switch format {
-	case "yaml":
-		yamlInfo, err := yaml.JSONToYAML([]byte(jsonInfo))
+// parseLabelStringSlice parses the values of --label flags. Every value is a
+// Kubernetes label selector, such as `key=value`, `key!=value`,
+// `key in (a,b)`, `key notin (a,b)`, `key` or `!key`. As runtimes allow any
+// label value, `key=value` is also accepted if the value contains characters
+// which label selectors do not allow, such as `=`, as long as the part before
+// the first `=` is a valid key.
+// The equality requirements are returned as a map, which is passed to the
+// CRI LabelSelector filter, whereas the remaining requirements are returned
+// as a selector, which has to be matched on the client.
+func parseLabelStringSlice(ss []string) (map[string]string, labels.Selector, error) {
+	equality := make(map[string]string)
+	selector := labels.NewSelector()
+	for _, s := range ss {
+		parsed, err := labels.Parse(s)
 		if err != nil {
-			return err
-		}
-		fmt.Println(string(yamlInfo))
-	case "json":
-		var output bytes.Buffer
-		if err := json.Indent(&output, []byte(jsonInfo), "", "  "); err != nil {
-			return err
+			idx := strings.Index(s, "=")
+			if idx < 0 || len(validation.IsQualifiedName(s[:idx])) > 0 {
+				return nil, nil, errors.Wrapf(err, "incorrectly specified label %q", s)
+			}
+			equality[s[:idx]] = s[idx+1:]
+			continue
 		}
-		fmt.Println(output.String())
-	case "go-template":
-		output, err := tmplExecuteRawJSON(tmplStr, jsonInfo)
-		if err != nil {
-			return err
+		requirements, _ := parsed.Requirements()
+		for _, r := range requirements {
+			values := r.Values()
+			if (r.Operator() == selection.Equals || r.Operator() == selection.DoubleEquals) && values.Len() == 1 {
+				equality[r.Key()] = values.List()[0]
+				continue
+			}
+			selector = selector.Add(r)
 		}
-		fmt.Println(output)
-	default:
-		fmt.Printf("Don't support %q format\n", format)
 	}
-	return nil
+	return equality, selector, nil
 }
 
-func parseLabelStringSlice(ss []string) (map[string]string, error) {
-	labels := make(map[string]string)
-	for _, s := range ss {
-		pair := strings.Split(s, "=")
-		if len(pair) != 2 {
-			return nil, fmt.Errorf("incorrectly specified label: %v", s)
-		}
-		labels[pair[0]] = pair[1]
+// matchesLabels returns true if the labels match the client side label
+// selector. A nil selector matches everything.
+func matchesLabels(selector labels.Selector, l map[string]string) bool {
+	if selector == nil {
+		return true
 	}
-	return labels, nil
+	return selector.Matches(labels.Set(l))
 }
 
 // marshalMapInOrder marshalls a map into json in the order of the original
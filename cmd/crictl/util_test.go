@@ -17,6 +17,8 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 )
 
@@ -68,3 +70,103 @@ func TestNameFilterByRegex(t *testing.T) {
 		})
 	}
 }
+
+func TestMarshalStatusInfo(t *testing.T) {
+	info := map[string]string{
+		"info":   `{"pid":1}`,
+		"quoted": `say "hi"`,
+		"empty":  "",
+	}
+	raw, err := marshalStatusInfo(`{"id":"abc"}`, info)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(raw, &res); err != nil {
+		t.Fatalf("expected valid JSON, but got %q: %v", raw, err)
+	}
+	expected := map[string]interface{}{
+		"status": map[string]interface{}{"id": "abc"},
+		"info":   map[string]interface{}{"pid": float64(1)},
+		"quoted": `say "hi"`,
+		"empty":  "",
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("expected %v, but got %v", expected, res)
+	}
+}
+
+func TestParseLabelStringSlice(t *testing.T) {
+	testCases := []struct {
+		desc             string
+		labels           []string
+		expectedEquality map[string]string
+		matches          map[string]string
+		notMatches       map[string]string
+		expectErr        bool
+	}{
+		{
+			desc:             "equality is passed to the runtime",
+			labels:           []string{"app=nginx", "tier==frontend"},
+			expectedEquality: map[string]string{"app": "nginx", "tier": "frontend"},
+			matches:          map[string]string{},
+		},
+		{
+			desc:             "value containing '='",
+			labels:           []string{"args=a=b"},
+			expectedEquality: map[string]string{"args": "a=b"},
+			matches:          map[string]string{},
+		},
+		{
+			desc:             "set based requirements are matched on the client",
+			labels:           []string{"app in (nginx,redis),tier", "env notin (prod)", "!canary", "owner!=me"},
+			expectedEquality: map[string]string{},
+			matches:          map[string]string{"app": "redis", "tier": "backend", "env": "dev", "owner": "you"},
+			notMatches:       map[string]string{"app": "redis", "tier": "backend", "canary": "true"},
+		},
+		{
+			desc:             "commas separate requirements",
+			labels:           []string{"app=x,y"},
+			expectedEquality: map[string]string{"app": "x"},
+			matches:          map[string]string{"app": "x", "y": ""},
+			notMatches:       map[string]string{"app": "x"},
+		},
+		{
+			desc:      "value containing '=' after an operator",
+			labels:    []string{"args!=a=b"},
+			expectErr: true,
+		},
+		{
+			desc:      "invalid selector without '='",
+			labels:    []string{"app in (nginx"},
+			expectErr: true,
+		},
+		{
+			desc:      "missing key",
+			labels:    []string{"=value"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		equality, selector, err := parseLabelStringSlice(tc.labels)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected an error", tc.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.desc, err)
+			continue
+		}
+		if !reflect.DeepEqual(equality, tc.expectedEquality) {
+			t.Errorf("%s: expected equality %v, but got %v", tc.desc, tc.expectedEquality, equality)
+		}
+		if tc.matches != nil && !matchesLabels(selector, tc.matches) {
+			t.Errorf("%s: expected %v to match %q", tc.desc, tc.matches, selector)
+		}
+		if tc.notMatches != nil && matchesLabels(selector, tc.notMatches) {
+			t.Errorf("%s: expected %v not to match %q", tc.desc, tc.notMatches, selector)
+		}
+	}
+}
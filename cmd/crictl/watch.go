@@ -0,0 +1,206 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"time"
+
+	dockerterm "github.com/docker/docker/pkg/term"
+	"github.com/pkg/errors"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+// watchInterval is the interval in which watched objects are listed again.
+const watchInterval = time.Second
+
+// Terminal colors of the watched rows. All of them have the same length, so
+// that the table columns stay aligned.
+const (
+	colorDefault  = "\033[39m"
+	colorAdded    = "\033[32m"
+	colorModified = "\033[33m"
+	colorDeleted  = "\033[31m"
+	colorReset    = "\033[0m"
+)
+
+// watchItem is a single watched object.
+type watchItem struct {
+	// id identifies the object between two listings.
+	id string
+	// state is compared between two listings to detect modified objects.
+	state string
+	// row is the table row of the object.
+	row []string
+	// object is streamed in the json output format.
+	object interface{}
+}
+
+// watchChange is a watched object along with the event type of its change
+// since the previous listing, or an empty type if it is unchanged.
+type watchChange struct {
+	eventType string
+	item      watchItem
+}
+
+// watchListFunc lists the watched objects and returns them along with the
+// header of their table.
+type watchListFunc func() (header []string, items []watchItem, err error)
+
+// diffWatchItems compares two listings of watched objects. Deleted objects
+// are returned after the current ones.
+func diffWatchItems(previous, current []watchItem) []watchChange {
+	previousByID := make(map[string]watchItem, len(previous))
+	for _, item := range previous {
+		previousByID[item.id] = item
+	}
+	currentIDs := make(map[string]bool, len(current))
+
+	changes := make([]watchChange, 0, len(current))
+	for _, item := range current {
+		currentIDs[item.id] = true
+		change := watchChange{item: item}
+		if old, ok := previousByID[item.id]; !ok {
+			change.eventType = output.EventAdded
+		} else if old.state != item.state {
+			change.eventType = output.EventModified
+		}
+		changes = append(changes, change)
+	}
+	for _, item := range previous {
+		if !currentIDs[item.id] {
+			changes = append(changes, watchChange{eventType: output.EventDeleted, item: item})
+		}
+	}
+	return changes
+}
+
+// watchObjects lists the watched objects every watchInterval until the user
+// hits CtrlC. The table and wide formats redraw the table with the added,
+// modified and deleted rows highlighted, whereas the json format streams
+// the changes as JSON lines.
+func watchObjects(printer *output.Printer, list watchListFunc) error {
+	switch printer.Format() {
+	case output.FormatTable, output.FormatWide, output.FormatJSON:
+	default:
+		return errors.Errorf("watch mode does not support the %q output format", printer.Format())
+	}
+
+	// Colors and clearing the screen would garble the output if it is not
+	// a terminal.
+	terminal := dockerterm.IsTerminal(os.Stdout.Fd())
+	errCh := make(chan error, 1)
+	go func() {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		var previous []watchItem
+		for listed := false; ; listed = true {
+			header, items, err := list()
+			if err != nil {
+				errCh <- err
+				return
+			}
+			changes := diffWatchItems(previous, items)
+			if printer.Format() == output.FormatJSON {
+				err = printWatchEvents(changes)
+			} else {
+				err = printWatchTable(header, changes, listed, terminal)
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+			previous = items
+			<-ticker.C
+		}
+	}()
+
+	// listen for CtrlC or error
+	select {
+	case <-SetupInterruptSignalHandler():
+		return nil
+	case err := <-errCh:
+		return err
+	}
+}
+
+func printWatchEvents(changes []watchChange) error {
+	for _, change := range changes {
+		if change.eventType == "" {
+			continue
+		}
+		if err := output.PrintEvent(os.Stdout, change.eventType, change.item.object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printWatchTable redraws the table of the watched objects on a terminal,
+// and prints it after the previous one otherwise.
+func printWatchTable(header []string, changes []watchChange, listed, terminal bool) error {
+	display := newTableDisplay(20, 1, 3, ' ', 0)
+	if terminal {
+		display.ClearScreen()
+	} else if listed {
+		os.Stdout.WriteString("\n")
+	}
+	for _, row := range watchTableRows(header, changes, listed, terminal) {
+		display.AddRow(row)
+	}
+	return display.Flush()
+}
+
+// watchTableRows returns the rows of the table of the watched objects, in
+// color if color is set. Changes are highlighted if highlight is set.
+func watchTableRows(header []string, changes []watchChange, highlight, color bool) [][]string {
+	if !color {
+		rows := [][]string{header}
+		for _, change := range changes {
+			rows = append(rows, change.item.row)
+		}
+		return rows
+	}
+	rows := [][]string{colorRow(header, colorDefault)}
+	for _, change := range changes {
+		rowColor := colorDefault
+		if highlight {
+			switch change.eventType {
+			case output.EventAdded:
+				rowColor = colorAdded
+			case output.EventModified:
+				rowColor = colorModified
+			case output.EventDeleted:
+				rowColor = colorDeleted
+			}
+		}
+		rows = append(rows, colorRow(change.item.row, rowColor))
+	}
+	return rows
+}
+
+// colorRow returns a copy of row printed in color.
+func colorRow(row []string, color string) []string {
+	if len(row) == 0 {
+		return row
+	}
+	colored := append([]string(nil), row...)
+	colored[0] = color + colored[0]
+	colored[len(colored)-1] += colorReset
+	return colored
+}
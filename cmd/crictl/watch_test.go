@@ -0,0 +1,93 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kubernetes-sigs/cri-tools/pkg/output"
+)
+
+func TestDiffWatchItems(t *testing.T) {
+	previous := []watchItem{
+		{id: "a", state: "Running"},
+		{id: "b", state: "Running"},
+		{id: "c", state: "Running"},
+	}
+	current := []watchItem{
+		{id: "d", state: "Created"},
+		{id: "a", state: "Running"},
+		{id: "c", state: "Exited"},
+	}
+
+	var got []string
+	for _, change := range diffWatchItems(previous, current) {
+		got = append(got, change.item.id+":"+change.eventType)
+	}
+	expected := []string{
+		"d:" + output.EventAdded,
+		"a:",
+		"c:" + output.EventModified,
+		"b:" + output.EventDeleted,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected changes %v, but got %v", expected, got)
+	}
+
+	for _, change := range diffWatchItems(nil, current) {
+		if change.eventType != output.EventAdded {
+			t.Errorf("expected %q to be added on the first listing, but got %q", change.item.id, change.eventType)
+		}
+	}
+}
+
+func TestColorRow(t *testing.T) {
+	row := []string{"a", "b"}
+	colored := colorRow(row, colorAdded)
+	if expected := []string{colorAdded + "a", "b" + colorReset}; !reflect.DeepEqual(colored, expected) {
+		t.Errorf("expected %q, but got %q", expected, colored)
+	}
+	if row[0] != "a" {
+		t.Errorf("expected the original row to be unchanged, but got %q", row)
+	}
+}
+
+func TestWatchTableRows(t *testing.T) {
+	header := []string{"ID", "STATE"}
+	changes := []watchChange{
+		{eventType: output.EventAdded, item: watchItem{row: []string{"a", "Running"}}},
+		{item: watchItem{row: []string{"b", "Running"}}},
+	}
+
+	rows := watchTableRows(header, changes, true, true)
+	expected := [][]string{
+		colorRow(header, colorDefault),
+		colorRow([]string{"a", "Running"}, colorAdded),
+		colorRow([]string{"b", "Running"}, colorDefault),
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, but got %q", expected, rows)
+	}
+
+	// Without colors, the rows are printed as they are.
+	rows = watchTableRows(header, changes, false, false)
+	expected = [][]string{header, {"a", "Running"}, {"b", "Running"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %q, but got %q", expected, rows)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestGetPodsWideInfo(t *testing.T) {
+	client := &fakeRuntimeClient{
+		containers: []*pb.Container{
+			{Id: "c1", PodSandboxId: "p1", State: pb.ContainerState_CONTAINER_RUNNING},
+			{Id: "c2", PodSandboxId: "p1", State: pb.ContainerState_CONTAINER_EXITED},
+			{Id: "c3", PodSandboxId: "p2", State: pb.ContainerState_CONTAINER_RUNNING},
+			{Id: "c4", PodSandboxId: "other", State: pb.ContainerState_CONTAINER_RUNNING},
+		},
+		podStatus: map[string]*pb.PodSandboxStatusResponse{
+			"p1": {Status: &pb.PodSandboxStatus{Network: &pb.PodSandboxNetworkStatus{
+				Ip:            "10.0.0.1",
+				AdditionalIps: []*pb.PodIP{{Ip: "fd00::1"}, {Ip: "fd00::2"}},
+			}}},
+			"p2": {Status: &pb.PodSandboxStatus{Linux: &pb.LinuxPodSandboxStatus{Namespaces: &pb.Namespace{
+				Options: &pb.NamespaceOption{Network: pb.NamespaceMode_NODE},
+			}}}},
+		},
+	}
+	infos := getPodsWideInfo(client, []*pb.PodSandbox{{Id: "p1"}, {Id: "p2"}, {Id: "p3"}})
+
+	for _, tc := range []struct {
+		id       string
+		expected podWideInfo
+	}{
+		{id: "p1", expected: podWideInfo{ip: "10.0.0.1", additionalIPs: "fd00::1,fd00::2", running: 1, total: 2}},
+		{id: "p2", expected: podWideInfo{ip: "-", additionalIPs: "-", hostNetwork: true, running: 1, total: 1}},
+		// The status of p3 cannot be requested.
+		{id: "p3", expected: podWideInfo{ip: "-", additionalIPs: "-"}},
+	} {
+		if info := infos[tc.id]; info == nil || *info != tc.expected {
+			t.Errorf("%s: expected %+v, but got %+v", tc.id, tc.expected, info)
+		}
+	}
+}
+
+func TestGetContainersWideInfo(t *testing.T) {
+	client := &fakeRuntimeClient{
+		sandboxes: []*pb.PodSandbox{
+			{Id: "p2", Metadata: &pb.PodSandboxMetadata{Name: "db", Namespace: "kube-system"}},
+		},
+		statuses: map[string]*pb.ContainerStatusResponse{
+			"c2": {Status: &pb.ContainerStatus{ExitCode: 137}},
+		},
+	}
+	containers := []*pb.Container{
+		{
+			Id:           "c1",
+			PodSandboxId: "p1",
+			State:        pb.ContainerState_CONTAINER_RUNNING,
+			Metadata:     &pb.ContainerMetadata{Attempt: 2},
+			Labels:       map[string]string{"io.kubernetes.pod.name": "web", "io.kubernetes.pod.namespace": "default"},
+			Annotations:  map[string]string{restartCountAnnotation: "5"},
+		},
+		{
+			Id:           "c2",
+			PodSandboxId: "p2",
+			State:        pb.ContainerState_CONTAINER_EXITED,
+			Metadata:     &pb.ContainerMetadata{Attempt: 1},
+		},
+		// The status of c3 cannot be requested.
+		{
+			Id:           "c3",
+			PodSandboxId: "p3",
+			State:        pb.ContainerState_CONTAINER_EXITED,
+			Metadata:     &pb.ContainerMetadata{},
+		},
+	}
+	infos := getContainersWideInfo(client, containers)
+
+	for _, tc := range []struct {
+		id       string
+		expected containerWideInfo
+	}{
+		{id: "c1", expected: containerWideInfo{podName: "web", podNamespace: "default", exitCode: "-", restarts: "5"}},
+		{id: "c2", expected: containerWideInfo{podName: "db", podNamespace: "kube-system", exitCode: "137", restarts: "1"}},
+		{id: "c3", expected: containerWideInfo{exitCode: "-", restarts: "0"}},
+	} {
+		if info := infos[tc.id]; info == nil || *info != tc.expected {
+			t.Errorf("%s: expected %+v, but got %+v", tc.id, tc.expected, info)
+		}
+	}
+}
+
+func TestWideInfoParallelism(t *testing.T) {
+	client := &fakeRuntimeClient{delay: time.Millisecond}
+	var (
+		pods       []*pb.PodSandbox
+		containers []*pb.Container
+	)
+	for i := 0; i < 3*wideInfoParallelism; i++ {
+		pods = append(pods, &pb.PodSandbox{Id: fmt.Sprintf("p%d", i)})
+		containers = append(containers, &pb.Container{
+			Id:       fmt.Sprintf("c%d", i),
+			State:    pb.ContainerState_CONTAINER_EXITED,
+			Metadata: &pb.ContainerMetadata{},
+			Labels:   map[string]string{"io.kubernetes.pod.name": "web"},
+		})
+	}
+
+	getPodsWideInfo(client, pods)
+	getContainersWideInfo(client, containers)
+	if client.maxInFlight > wideInfoParallelism {
+		t.Errorf("expected at most %d concurrent status requests, but got %d", wideInfoParallelism, client.maxInFlight)
+	}
+}
@@ -26,6 +26,7 @@ require (
 	k8s.io/cri-api v0.21.3
 	k8s.io/kubectl v0.21.3
 	k8s.io/kubernetes v0.21.4
+	k8s.io/utils v0.0.0-20201110183641-67b214c5f920
 	sigs.k8s.io/yaml v1.2.0
 )
 
@@ -0,0 +1,521 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cgroups reads the resource usage of a cgroup from the cgroup
+// filesystem. Both the cgroup v1 and the unified cgroup v2 hierarchy are
+// supported.
+package cgroups
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRoot is the mount point of the cgroup filesystem.
+const DefaultRoot = "/sys/fs/cgroup"
+
+// unlimited is the smallest value of a cgroup v1 limit which means no limit.
+const unlimited = 1 << 62
+
+// Stats is the resource usage of a cgroup. Sections whose controller is not
+// available are nil.
+type Stats struct {
+	// Version of the cgroup hierarchy, 1 or 2.
+	Version  int            `json:"version"`
+	Memory   *MemoryStats   `json:"memory,omitempty"`
+	CPU      *CPUStats      `json:"cpu,omitempty"`
+	Pids     *PidsStats     `json:"pids,omitempty"`
+	IO       *IOStats       `json:"io,omitempty"`
+	Pressure *PressureStats `json:"pressure,omitempty"`
+}
+
+// MemoryStats is the memory usage of a cgroup. A zero limit means no limit.
+type MemoryStats struct {
+	UsageBytes uint64 `json:"usageBytes"`
+	LimitBytes uint64 `json:"limitBytes"`
+	RSSBytes   uint64 `json:"rssBytes"`
+	CacheBytes uint64 `json:"cacheBytes"`
+}
+
+// CPUStats is the CPU throttling of a cgroup.
+type CPUStats struct {
+	Periods              uint64 `json:"periods"`
+	ThrottledPeriods     uint64 `json:"throttledPeriods"`
+	ThrottledNanoSeconds uint64 `json:"throttledNanoSeconds"`
+}
+
+// PidsStats is the number of tasks of a cgroup. A zero limit means no limit.
+type PidsStats struct {
+	Current uint64 `json:"current"`
+	Limit   uint64 `json:"limit"`
+}
+
+// IOStats is the block IO of a cgroup, summed up over all devices.
+type IOStats struct {
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+}
+
+// PressureStats is the pressure stall information of a cgroup.
+type PressureStats struct {
+	CPU    *Pressure `json:"cpu,omitempty"`
+	Memory *Pressure `json:"memory,omitempty"`
+	IO     *Pressure `json:"io,omitempty"`
+}
+
+// Pressure is the share of time in which some or all tasks of a cgroup were
+// stalled on a resource.
+type Pressure struct {
+	Some *PressureData `json:"some,omitempty"`
+	Full *PressureData `json:"full,omitempty"`
+}
+
+// PressureData holds the stall percentages over the last 10, 60 and 300
+// seconds and the total stall time.
+type PressureData struct {
+	Avg10             float64 `json:"avg10"`
+	Avg60             float64 `json:"avg60"`
+	Avg300            float64 `json:"avg300"`
+	TotalMicroSeconds uint64  `json:"totalMicroSeconds"`
+}
+
+// IsUnified returns whether the cgroup filesystem mounted at root is a
+// cgroup v2 hierarchy.
+func IsUnified(root string) bool {
+	_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+	return err == nil
+}
+
+// ExpandPath converts the cgroupsPath of an OCI runtime spec into a path
+// relative to the cgroup mount point. Paths in the systemd
+// "slice:prefix:name" form are expanded to the location systemd creates the
+// scope at.
+func ExpandPath(cgroupsPath string) (string, error) {
+	if cgroupsPath == "" {
+		return "", errors.New("empty cgroups path")
+	}
+	parts := strings.Split(cgroupsPath, ":")
+	if strings.HasPrefix(cgroupsPath, "/") || len(parts) != 3 {
+		return path.Clean("/" + cgroupsPath), nil
+	}
+
+	slice, prefix, name := parts[0], parts[1], parts[2]
+	if slice == "" {
+		slice = "system.slice"
+	}
+	slicePath, err := expandSlice(slice)
+	if err != nil {
+		return "", err
+	}
+	unit := name
+	if !strings.HasSuffix(name, ".slice") {
+		if prefix != "" {
+			unit = prefix + "-" + name
+		}
+		unit += ".scope"
+	}
+	return path.Join(slicePath, unit), nil
+}
+
+// expandSlice returns the path of a systemd slice, whose parents are given
+// by the dash separated prefixes of its name.
+func expandSlice(slice string) (string, error) {
+	name := strings.TrimSuffix(slice, ".slice")
+	if name == slice || strings.Contains(name, "/") {
+		return "", errors.Errorf("invalid slice name %q", slice)
+	}
+	if name == "-" {
+		return "/", nil
+	}
+	p, prefix := "/", ""
+	for _, component := range strings.Split(name, "-") {
+		if component == "" {
+			return "", errors.Errorf("invalid slice name %q", slice)
+		}
+		p = path.Join(p, prefix+component+".slice")
+		prefix += component + "-"
+	}
+	return p, nil
+}
+
+// ParseProcCgroup returns the cgroup path of a process from the content of
+// its /proc/<pid>/cgroup file. The path of the memory controller is used on
+// cgroup v1 and hybrid hosts and the unified path on cgroup v2 hosts.
+func ParseProcCgroup(r io.Reader) (string, error) {
+	var unified, memory string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			unified = parts[2]
+		case hasController(parts[1], "memory"):
+			memory = parts[2]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if memory != "" {
+		return memory, nil
+	}
+	if unified != "" {
+		return unified, nil
+	}
+	return "", errors.New("no cgroup found")
+}
+
+func hasController(controllers, controller string) bool {
+	for _, c := range strings.Split(controllers, ",") {
+		if c == controller {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads the stats of the cgroup at path below the cgroup filesystem
+// mounted at root.
+func Read(root, cgroupPath string) (*Stats, error) {
+	if IsUnified(root) {
+		return readV2(filepath.Join(root, cgroupPath))
+	}
+	return readV1(root, cgroupPath)
+}
+
+func readV2(dir string) (*Stats, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, errors.Wrap(err, "cgroup not found")
+	}
+	stats := &Stats{Version: 2}
+
+	if memStat, err := readKeyValues(filepath.Join(dir, "memory.stat")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		stats.Memory = &MemoryStats{RSSBytes: memStat["anon"], CacheBytes: memStat["file"]}
+		if stats.Memory.UsageBytes, err = readUint(filepath.Join(dir, "memory.current")); err != nil {
+			return nil, err
+		}
+		if stats.Memory.LimitBytes, err = readUint(filepath.Join(dir, "memory.max")); err != nil {
+			return nil, err
+		}
+	}
+
+	if cpuStat, err := readKeyValues(filepath.Join(dir, "cpu.stat")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if _, ok := cpuStat["nr_periods"]; ok {
+		// The throttling statistics are only available with the cpu
+		// controller enabled.
+		stats.CPU = &CPUStats{
+			Periods:              cpuStat["nr_periods"],
+			ThrottledPeriods:     cpuStat["nr_throttled"],
+			ThrottledNanoSeconds: cpuStat["throttled_usec"] * 1000,
+		}
+	}
+
+	var err error
+	if stats.Pids, err = readPids(dir); err != nil {
+		return nil, err
+	}
+
+	if stats.IO, err = readIOStat(filepath.Join(dir, "io.stat")); err != nil {
+		return nil, err
+	}
+
+	if stats.Pressure, err = readPressureStats(dir, dir, dir); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func readV1(root, cgroupPath string) (*Stats, error) {
+	memoryDir := filepath.Join(root, "memory", cgroupPath)
+	cpuDir := filepath.Join(root, "cpu", cgroupPath)
+	pidsDir := filepath.Join(root, "pids", cgroupPath)
+	blkioDir := filepath.Join(root, "blkio", cgroupPath)
+	found := false
+	for _, dir := range []string{memoryDir, cpuDir, pidsDir, blkioDir} {
+		if _, err := os.Stat(dir); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("cgroup %s not found below %s", cgroupPath, root)
+	}
+	stats := &Stats{Version: 1}
+
+	if memStat, err := readKeyValues(filepath.Join(memoryDir, "memory.stat")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		stats.Memory = &MemoryStats{RSSBytes: memStat["total_rss"], CacheBytes: memStat["total_cache"]}
+		if stats.Memory.UsageBytes, err = readUint(filepath.Join(memoryDir, "memory.usage_in_bytes")); err != nil {
+			return nil, err
+		}
+		if stats.Memory.LimitBytes, err = readUint(filepath.Join(memoryDir, "memory.limit_in_bytes")); err != nil {
+			return nil, err
+		}
+	}
+
+	if cpuStat, err := readKeyValues(filepath.Join(cpuDir, "cpu.stat")); err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else {
+		stats.CPU = &CPUStats{
+			Periods:              cpuStat["nr_periods"],
+			ThrottledPeriods:     cpuStat["nr_throttled"],
+			ThrottledNanoSeconds: cpuStat["throttled_time"],
+		}
+	}
+
+	var err error
+	if stats.Pids, err = readPids(pidsDir); err != nil {
+		return nil, err
+	}
+
+	stats.IO, err = readBlkioStat(filepath.Join(blkioDir, "blkio.throttle.io_service_bytes_recursive"))
+	if err == nil && stats.IO == nil {
+		stats.IO, err = readBlkioStat(filepath.Join(blkioDir, "blkio.throttle.io_service_bytes"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Pressure stall information is only available for cgroup v1 if the
+	// kernel was booted with psi_v1=1.
+	if stats.Pressure, err = readPressureStats(cpuDir, memoryDir, blkioDir); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func readPids(dir string) (*PidsStats, error) {
+	current, err := readUint(filepath.Join(dir, "pids.current"))
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	limit, err := readUint(filepath.Join(dir, "pids.max"))
+	if err != nil && !os.IsNotExist(errors.Cause(err)) {
+		return nil, err
+	}
+	return &PidsStats{Current: current, Limit: limit}, nil
+}
+
+// readIOStat sums up the read and written bytes of a cgroup v2 io.stat
+// file, whose lines look like "8:0 rbytes=1 wbytes=2 rios=3 wios=4".
+func readIOStat(file string) (*IOStats, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	stats := &IOStats{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, err := strconv.ParseUint(kv[1], 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "parse %s", file)
+			}
+			switch kv[0] {
+			case "rbytes":
+				stats.ReadBytes += value
+			case "wbytes":
+				stats.WriteBytes += value
+			}
+		}
+	}
+	return stats, nil
+}
+
+// readBlkioStat sums up the read and written bytes of a cgroup v1 blkio
+// file, whose lines look like "8:0 Read 1". It returns nil if the file does
+// not exist or holds no devices.
+func readBlkioStat(file string) (*IOStats, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var stats *IOStats
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", file)
+		}
+		if stats == nil {
+			stats = &IOStats{}
+		}
+		switch fields[1] {
+		case "Read":
+			stats.ReadBytes += value
+		case "Write":
+			stats.WriteBytes += value
+		}
+	}
+	return stats, nil
+}
+
+func readPressureStats(cpuDir, memoryDir, ioDir string) (*PressureStats, error) {
+	stats := &PressureStats{}
+	for _, p := range []struct {
+		file     string
+		pressure **Pressure
+	}{
+		{filepath.Join(cpuDir, "cpu.pressure"), &stats.CPU},
+		{filepath.Join(memoryDir, "memory.pressure"), &stats.Memory},
+		{filepath.Join(ioDir, "io.pressure"), &stats.IO},
+	} {
+		pressure, err := readPressure(p.file)
+		if err != nil {
+			return nil, err
+		}
+		*p.pressure = pressure
+	}
+	if stats.CPU == nil && stats.Memory == nil && stats.IO == nil {
+		return nil, nil
+	}
+	return stats, nil
+}
+
+// readPressure reads a PSI file, whose lines look like
+// "some avg10=0.00 avg60=0.00 avg300=0.00 total=0".
+func readPressure(file string) (*Pressure, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	pressure := &Pressure{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		d := &PressureData{}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "avg10", "avg60", "avg300":
+				value, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil {
+					return nil, errors.Wrapf(err, "parse %s", file)
+				}
+				switch kv[0] {
+				case "avg10":
+					d.Avg10 = value
+				case "avg60":
+					d.Avg60 = value
+				default:
+					d.Avg300 = value
+				}
+			case "total":
+				if d.TotalMicroSeconds, err = strconv.ParseUint(kv[1], 10, 64); err != nil {
+					return nil, errors.Wrapf(err, "parse %s", file)
+				}
+			}
+		}
+		switch fields[0] {
+		case "some":
+			pressure.Some = d
+		case "full":
+			pressure.Full = d
+		}
+	}
+	return pressure, nil
+}
+
+// readKeyValues reads a file of "key value" lines like memory.stat.
+func readKeyValues(file string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s", file)
+		}
+		values[fields[0]] = value
+	}
+	return values, nil
+}
+
+// readUint reads a file holding a single number. Limits of "max" and
+// cgroup v1 limits meaning no limit are returned as 0.
+func readUint(file string) (uint64, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, errors.Wrapf(err, "read %s", file)
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parse %s", file)
+	}
+	if value >= unlimited {
+		return 0, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeTree creates the files of a fake cgroup filesystem below root.
+func writeTree(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const testPressure = `some avg10=1.50 avg60=0.75 avg300=0.25 total=1000
+full avg10=0.50 avg60=0.00 avg300=0.00 total=200
+`
+
+func TestReadV2(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTree(t, root, map[string]string{
+		"cgroup.controllers":          "cpu io memory pids\n",
+		"kubepods/c1/memory.stat":     "anon 4096\nfile 8192\nkernel_stack 0\n",
+		"kubepods/c1/memory.current":  "16384\n",
+		"kubepods/c1/memory.max":      "max\n",
+		"kubepods/c1/cpu.stat":        "usage_usec 100\nnr_periods 10\nnr_throttled 2\nthrottled_usec 30\n",
+		"kubepods/c1/pids.current":    "3\n",
+		"kubepods/c1/pids.max":        "100\n",
+		"kubepods/c1/io.stat":         "8:0 rbytes=10 wbytes=20 rios=1 wios=2\n8:16 rbytes=1 wbytes=2 rios=1 wios=1\n",
+		"kubepods/c1/cpu.pressure":    testPressure,
+		"kubepods/c1/memory.pressure": testPressure,
+	})
+
+	stats, err := Read(root, "/kubepods/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Stats{
+		Version: 2,
+		Memory:  &MemoryStats{UsageBytes: 16384, RSSBytes: 4096, CacheBytes: 8192},
+		CPU:     &CPUStats{Periods: 10, ThrottledPeriods: 2, ThrottledNanoSeconds: 30000},
+		Pids:    &PidsStats{Current: 3, Limit: 100},
+		IO:      &IOStats{ReadBytes: 11, WriteBytes: 22},
+		Pressure: &PressureStats{
+			CPU: &Pressure{
+				Some: &PressureData{Avg10: 1.5, Avg60: 0.75, Avg300: 0.25, TotalMicroSeconds: 1000},
+				Full: &PressureData{Avg10: 0.5, TotalMicroSeconds: 200},
+			},
+			Memory: &Pressure{
+				Some: &PressureData{Avg10: 1.5, Avg60: 0.75, Avg300: 0.25, TotalMicroSeconds: 1000},
+				Full: &PressureData{Avg10: 0.5, TotalMicroSeconds: 200},
+			},
+		},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, but got %+v", expected, stats)
+	}
+
+	if _, err := Read(root, "/kubepods/missing"); err == nil {
+		t.Error("expected an error for a missing cgroup")
+	}
+}
+
+func TestReadV1(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	writeTree(t, root, map[string]string{
+		"memory/kubepods/c1/memory.stat":                    "cache 100\nrss 200\ntotal_cache 1000\ntotal_rss 2000\n",
+		"memory/kubepods/c1/memory.usage_in_bytes":          "4000\n",
+		"memory/kubepods/c1/memory.limit_in_bytes":          "9223372036854771712\n",
+		"cpu/kubepods/c1/cpu.stat":                          "nr_periods 5\nnr_throttled 1\nthrottled_time 123456\n",
+		"blkio/kubepods/c1/blkio.throttle.io_service_bytes": "8:0 Read 10\n8:0 Write 20\n8:0 Sync 30\n8:0 Async 0\n8:0 Total 30\nTotal 30\n",
+	})
+
+	stats, err := Read(root, "/kubepods/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Stats{
+		Version: 1,
+		Memory:  &MemoryStats{UsageBytes: 4000, RSSBytes: 2000, CacheBytes: 1000},
+		CPU:     &CPUStats{Periods: 5, ThrottledPeriods: 1, ThrottledNanoSeconds: 123456},
+		IO:      &IOStats{ReadBytes: 10, WriteBytes: 20},
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("expected %+v, but got %+v", expected, stats)
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	for _, tc := range []struct {
+		cgroupsPath string
+		expected    string
+	}{
+		{"/kubepods/burstable/pod123/c1", "/kubepods/burstable/pod123/c1"},
+		{"kubepods/c1", "/kubepods/c1"},
+		{"kubepods-burstable-pod123.slice:cri-containerd:c1", "/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod123.slice/cri-containerd-c1.scope"},
+		{"kubepods-pod123.slice:crio:c1", "/kubepods.slice/kubepods-pod123.slice/crio-c1.scope"},
+		{":docker:c1", "/system.slice/docker-c1.scope"},
+		{"-.slice::c1", "/c1.scope"},
+	} {
+		got, err := ExpandPath(tc.cgroupsPath)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tc.cgroupsPath, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("expected %q for %q, but got %q", tc.expected, tc.cgroupsPath, got)
+		}
+	}
+	if _, err := ExpandPath("kubepods--x.slice:crio:c1"); err == nil {
+		t.Error("expected an error for an invalid slice")
+	}
+}
+
+func TestParseProcCgroup(t *testing.T) {
+	v1 := "12:pids:/kubepods/c1\n4:cpu,cpuacct:/kubepods/c1\n3:memory:/kubepods/c1\n0::/\n"
+	if got, err := ParseProcCgroup(strings.NewReader(v1)); err != nil || got != "/kubepods/c1" {
+		t.Errorf("expected %q, but got %q, %v", "/kubepods/c1", got, err)
+	}
+	v2 := "0::/kubepods.slice/cri-containerd-c1.scope\n"
+	if got, err := ParseProcCgroup(strings.NewReader(v2)); err != nil || got != "/kubepods.slice/cri-containerd-c1.scope" {
+		t.Errorf("expected v2 path, but got %q, %v", got, err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// StreamingTLSOptions are the TLS options of the connections to the
+// streaming server of the runtime, which serves exec, attach and
+// port-forward requests.
+type StreamingTLSOptions struct {
+	// CAFile is the CA bundle verifying the server, the system roots are
+	// used if empty.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and its key.
+	CertFile string
+	KeyFile  string
+	// ServerNames are the names the server certificate has to be valid for
+	// one of, instead of the host of the URL.
+	ServerNames []string
+	// InsecureSkipVerify skips the verification of the server certificate.
+	InsecureSkipVerify bool
+}
+
+// TLSConfig returns the TLS config of the options.
+func (o *StreamingTLSOptions) TLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if o.InsecureSkipVerify {
+		if o.CAFile != "" || len(o.ServerNames) > 0 {
+			return nil, errors.New("the streaming CA and server names cannot be combined with skipping the TLS verification")
+		}
+		config.InsecureSkipVerify = true
+		return config, nil
+	}
+	if o.CAFile != "" {
+		data, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read streaming CA")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificates found in %s", o.CAFile)
+		}
+	}
+	switch len(o.ServerNames) {
+	case 0:
+	case 1:
+		config.ServerName = o.ServerNames[0]
+	default:
+		// The standard verification checks a single name, the chain is
+		// verified for every name instead.
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = verifyServerNames(config.RootCAs, o.ServerNames)
+	}
+	return config, nil
+}
+
+// verifyServerNames returns a function verifying that the server certificate
+// chains up to the roots and is valid for one of the names.
+func verifyServerNames(roots *x509.CertPool, names []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		var certs []*x509.Certificate
+		for _, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return errors.Wrap(err, "parse server certificate")
+			}
+			certs = append(certs, cert)
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		var err error
+		for _, name := range names {
+			opts.DNSName = name
+			if _, err = certs[0].Verify(opts); err == nil {
+				return nil
+			}
+		}
+		return errors.Wrapf(err, "server certificate is not valid for any of %v", names)
+	}
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package common
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newCertificate returns a certificate for the names signed by the parent,
+// or a self-signed CA if the parent is nil.
+func newCertificate(t *testing.T, parent *tls.Certificate, names ...string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "cri-tools test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     names,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestStreamingTLSConfig(t *testing.T) {
+	ca := newCertificate(t, nil)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{newCertificate(t, &ca, "streaming.example.com")}}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		desc      string
+		opts      StreamingTLSOptions
+		expectErr bool
+	}{
+		{
+			desc:      "system roots",
+			expectErr: true,
+		},
+		{
+			desc:      "CA for the host of the URL",
+			opts:      StreamingTLSOptions{CAFile: caFile},
+			expectErr: true,
+		},
+		{
+			desc: "CA and server name",
+			opts: StreamingTLSOptions{CAFile: caFile, ServerNames: []string{"streaming.example.com"}},
+		},
+		{
+			desc:      "CA and wrong server name",
+			opts:      StreamingTLSOptions{CAFile: caFile, ServerNames: []string{"other.example.com"}},
+			expectErr: true,
+		},
+		{
+			desc: "CA and server names",
+			opts: StreamingTLSOptions{CAFile: caFile, ServerNames: []string{"other.example.com", "streaming.example.com"}},
+		},
+		{
+			desc:      "CA and wrong server names",
+			opts:      StreamingTLSOptions{CAFile: caFile, ServerNames: []string{"other.example.com", "another.example.com"}},
+			expectErr: true,
+		},
+		{
+			desc:      "server names without CA",
+			opts:      StreamingTLSOptions{ServerNames: []string{"other.example.com", "streaming.example.com"}},
+			expectErr: true,
+		},
+		{
+			desc: "insecure",
+			opts: StreamingTLSOptions{InsecureSkipVerify: true},
+		},
+	} {
+		config, err := tc.opts.TLSConfig()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), config)
+		if err == nil {
+			conn.Close()
+		}
+		if tc.expectErr && err == nil {
+			t.Errorf("%s: expected the verification to fail", tc.desc)
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.desc, err)
+		}
+	}
+}
+
+func TestStreamingTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	empty := filepath.Join(dir, "empty.crt")
+	if err := ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range []StreamingTLSOptions{
+		{CAFile: filepath.Join(dir, "missing.crt")},
+		{CAFile: empty},
+		{CertFile: filepath.Join(dir, "missing.crt")},
+		{InsecureSkipVerify: true, CAFile: empty},
+		{InsecureSkipVerify: true, ServerNames: []string{"streaming.example.com"}},
+	} {
+		if _, err := opts.TLSConfig(); err == nil {
+			t.Errorf("expected an error for %+v", opts)
+		}
+	}
+}
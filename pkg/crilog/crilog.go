@@ -0,0 +1,306 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package crilog reads container log files written in the CRI logging
+// format or in the Docker JSON format.
+package crilog
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+// pollInterval is the interval in which a followed log file is checked for
+// new data.
+var pollInterval = 250 * time.Millisecond
+
+// Entry is a line of a container log.
+type Entry struct {
+	// Time at which the runtime received the line.
+	Time time.Time
+	// Stream the line was written to.
+	Stream pb.LogStreamType
+	// Tags of the line, e.g. "F" or "P".
+	Tags string
+	// Partial is set for lines the runtime split up. The remainder of the
+	// line follows in the next entries of the same stream.
+	Partial bool
+	// Log is the content of the line without the trailing newline.
+	Log []byte
+}
+
+// ParseLine parses a line of a log file without its trailing newline.
+// Lines starting with "{" are parsed in the Docker JSON format, all other
+// lines in the CRI format, e.g.
+//
+//	2016-10-06T00:17:09.669794202Z stdout F log content
+func ParseLine(line []byte) (*Entry, error) {
+	if len(line) > 0 && line[0] == '{' {
+		return parseDockerJSONLine(line)
+	}
+	return parseCRILine(line)
+}
+
+func parseCRILine(line []byte) (*Entry, error) {
+	fields := bytes.SplitN(line, []byte{' '}, 4)
+	if len(fields) < 3 {
+		return nil, errors.Errorf("invalid CRI log line %q", line)
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(fields[0]))
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse timestamp of log line %q", line)
+	}
+	stream := pb.LogStreamType(fields[1])
+	if stream != pb.Stdout && stream != pb.Stderr {
+		return nil, errors.Errorf("unexpected stream type %q", stream)
+	}
+	e := &Entry{Time: t, Stream: stream, Tags: string(fields[2])}
+	e.Partial = pb.LogTag(bytes.SplitN(fields[2], []byte(pb.LogTagDelimiter), 2)[0]) == pb.LogTagPartial
+	if len(fields) == 4 {
+		e.Log = fields[3]
+	}
+	return e, nil
+}
+
+// dockerJSONLine is a line of a log file in the Docker JSON format, e.g.
+//
+//	{"log":"log content\n","stream":"stdout","time":"2016-10-20T18:39:20.57606443Z"}
+type dockerJSONLine struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func parseDockerJSONLine(line []byte) (*Entry, error) {
+	var l dockerJSONLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return nil, errors.Wrapf(err, "parse JSON log line %q", line)
+	}
+	e := &Entry{Time: l.Time, Stream: pb.LogStreamType(l.Stream), Tags: string(pb.LogTagFull), Log: []byte(l.Log)}
+	// Lines without a trailing newline were split up by docker.
+	if n := len(e.Log); n > 0 && e.Log[n-1] == '\n' {
+		e.Log = e.Log[:n-1]
+	} else {
+		e.Partial = true
+		e.Tags = string(pb.LogTagPartial)
+	}
+	return e, nil
+}
+
+// Reader reads the entries of a log file.
+type Reader struct {
+	r    *bufio.Reader
+	line []byte
+}
+
+// NewReader returns a reader of the log file data r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry. It returns io.EOF at the end of the data,
+// and Next may be called again once more data has been appended. An
+// incomplete last line is kept until it has been completed. Errors of
+// unparsable lines are returned along with a nil entry, and reading can
+// continue with the next line.
+func (r *Reader) Next() (*Entry, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	return ParseLine(line)
+}
+
+// readLine returns the next complete line without its trailing newline.
+func (r *Reader) readLine() ([]byte, error) {
+	for {
+		chunk, err := r.r.ReadSlice('\n')
+		r.line = append(r.line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := r.line[:len(r.line)-1]
+		r.line = nil
+		return line, nil
+	}
+}
+
+// Joiner joins the partial entries of a log into full lines.
+type Joiner struct {
+	partial map[pb.LogStreamType]*Entry
+}
+
+// Add adds an entry and returns the full line it completes, or nil if the
+// entry is partial. The joined line keeps the time of its first part.
+func (j *Joiner) Add(e *Entry) *Entry {
+	if j.partial == nil {
+		j.partial = make(map[pb.LogStreamType]*Entry)
+	}
+	p, ok := j.partial[e.Stream]
+	if !ok {
+		if e.Partial {
+			j.partial[e.Stream] = &Entry{Time: e.Time, Stream: e.Stream, Log: append([]byte{}, e.Log...)}
+			return nil
+		}
+		return e
+	}
+	p.Log = append(p.Log, e.Log...)
+	if e.Partial {
+		return nil
+	}
+	delete(j.partial, e.Stream)
+	p.Tags = e.Tags
+	return p
+}
+
+// Follow reads the log file at path from the start and calls fn for every
+// entry, or with the error of every unparsable line. At the end of the file
+// it calls eof and stops if eof returns false. Otherwise it waits for more
+// data, switching to the new file at path once the runtime rotated the log,
+// until ctx is done.
+func Follow(ctx context.Context, path string, fn func(*Entry, error) error, eof func() bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+	r := NewReader(f)
+	for {
+		line, err := r.readLine()
+		if err == nil {
+			if err := fn(ParseLine(line)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+
+		rotated, err := isRotated(f, path)
+		if err != nil {
+			return err
+		}
+		if rotated {
+			next, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			f.Close()
+			f, r = next, NewReader(next)
+			continue
+		}
+		if !eof() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// isRotated returns whether path has been replaced by a new file after f was
+// opened. A missing file at path, which the runtime is about to create, is
+// not reported as rotated yet.
+func isRotated(f *os.File, path string) (bool, error) {
+	current, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return !os.SameFile(current, fi), nil
+}
+
+// RotatedFiles returns the rotated siblings of the log file at path, oldest
+// first. The kubelet rotates logs by appending the time of the rotation to
+// their name, e.g. 0.log.20210301-120000, and compresses older ones into .gz
+// files. Temporary files of a running compression are skipped.
+func RotatedFiles(path string) ([]string, error) {
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var files []string
+	for _, fi := range infos {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		files = append(files, filepath.Join(filepath.Dir(path), name))
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return strings.TrimSuffix(files[i], ".gz") < strings.TrimSuffix(files[j], ".gz")
+	})
+	return files, nil
+}
+
+// ReadFile reads a log file, which is decompressed if its name ends with
+// .gz, and calls fn for every entry or with the error of every unparsable
+// line.
+func ReadFile(path string, fn func(*Entry, error) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var data io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "decompress %s", path)
+		}
+		defer gz.Close()
+		data = gz
+	}
+	r := NewReader(data)
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(ParseLine(line)); err != nil {
+			return err
+		}
+	}
+}
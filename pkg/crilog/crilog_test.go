@@ -0,0 +1,216 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crilog
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestParseLine(t *testing.T) {
+	ts := time.Date(2016, 10, 6, 0, 17, 9, 669794202, time.UTC)
+	for _, tc := range []struct {
+		line     string
+		expected *Entry
+	}{
+		{
+			line:     "2016-10-06T00:17:09.669794202Z stdout F log content",
+			expected: &Entry{Time: ts, Stream: pb.Stdout, Tags: "F", Log: []byte("log content")},
+		},
+		{
+			line:     "2016-10-06T00:17:09.669794202Z stderr P:x partial ",
+			expected: &Entry{Time: ts, Stream: pb.Stderr, Tags: "P:x", Partial: true, Log: []byte("partial ")},
+		},
+		{
+			line:     "2016-10-06T00:17:09.669794202Z stdout F",
+			expected: &Entry{Time: ts, Stream: pb.Stdout, Tags: "F"},
+		},
+		{
+			line:     `{"log":"json content\n","stream":"stdout","time":"2016-10-06T00:17:09.669794202Z"}`,
+			expected: &Entry{Time: ts, Stream: pb.Stdout, Tags: "F", Log: []byte("json content")},
+		},
+		{
+			line:     `{"log":"json partial","stream":"stderr","time":"2016-10-06T00:17:09.669794202Z"}`,
+			expected: &Entry{Time: ts, Stream: pb.Stderr, Tags: "P", Partial: true, Log: []byte("json partial")},
+		},
+	} {
+		e, err := ParseLine([]byte(tc.line))
+		if err != nil {
+			t.Errorf("unexpected error for %q: %v", tc.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(e, tc.expected) {
+			t.Errorf("expected %+v for %q, but got %+v", tc.expected, tc.line, e)
+		}
+	}
+
+	for _, line := range []string{"", "invalid", "2016-10-06T00:17:09Z stdin F x", "{invalid"} {
+		if _, err := ParseLine([]byte(line)); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestReaderAndJoiner(t *testing.T) {
+	data := strings.Join([]string{
+		"2016-10-06T00:17:09.000000001Z stdout P a",
+		"2016-10-06T00:17:09.000000002Z stderr F err",
+		"invalid",
+		"2016-10-06T00:17:09.000000003Z stdout P b",
+		"2016-10-06T00:17:09.000000004Z stdout F c",
+		"2016-10-06T00:17:09.000000005Z stdout F incomplete",
+	}, "\n")
+	r := NewReader(strings.NewReader(data))
+	var j Joiner
+	var lines []string
+	errs := 0
+	for {
+		e, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			errs++
+			continue
+		}
+		if e = j.Add(e); e != nil {
+			lines = append(lines, string(e.Stream)+":"+string(e.Log)+"@"+e.Time.Format("05.000000000"))
+		}
+	}
+	expected := []string{"stderr:err@09.000000002", "stdout:abc@09.000000001"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected %q, but got %q", expected, lines)
+	}
+	if errs != 1 {
+		t.Errorf("expected 1 error, but got %d", errs)
+	}
+}
+
+func TestFollowRotation(t *testing.T) {
+	pollInterval = time.Millisecond
+	dir, err := ioutil.TempDir("", "crilog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "0.log")
+	line := func(msg string) string { return "2016-10-06T00:17:09.669794202Z stdout F " + msg + "\n" }
+	if err := ioutil.WriteFile(path, []byte(line("one")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var got []string
+	rotated := false
+	err = Follow(ctx, path, func(e *Entry, err error) error {
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			return nil
+		}
+		got = append(got, string(e.Log))
+		return nil
+	}, func() bool {
+		if !rotated {
+			// Rotate the log like the kubelet: rename it, append the last
+			// line of the old file and create a new one.
+			rotated = true
+			if err := os.Rename(path, path+".20161006-001709"); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path+".20161006-001709", os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(line("two"))
+			f.Close()
+			if err := ioutil.WriteFile(path, []byte(line("three")), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return true
+		}
+		return len(got) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"one", "two", "three"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, but got %q", expected, got)
+	}
+}
+
+func TestRotatedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crilog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	line := func(msg string) string { return "2016-10-06T00:17:09.669794202Z stdout F " + msg + "\n" }
+	for name, content := range map[string]string{
+		"0.log":                     line("current"),
+		"0.log.20210301-120000":     line("newer"),
+		"0.log.20210301-110000.tmp": line("compressing"),
+		"1.log":                     line("other attempt"),
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, "0.log.20210301-110000.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte(line("older")))
+	gz.Close()
+	f.Close()
+
+	files, err := RotatedFiles(filepath.Join(dir, "0.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "0.log.20210301-110000.gz"), filepath.Join(dir, "0.log.20210301-120000")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("expected %q, but got %q", expected, files)
+	}
+
+	var got []string
+	for _, file := range files {
+		if err := ReadFile(file, func(e *Entry, err error) error {
+			if err != nil {
+				return err
+			}
+			got = append(got, string(e.Log))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if expected := []string{"older", "newer"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, but got %q", expected, got)
+	}
+}
@@ -21,8 +21,10 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/kubernetes-sigs/cri-tools/pkg/common"
 	"github.com/onsi/ginkgo/config"
 )
 
@@ -40,6 +42,9 @@ type TestContextType struct {
 	RuntimeServiceTimeout time.Duration
 	RuntimeHandler        string
 
+	// Streaming server TLS configuration.
+	StreamingTLS common.StreamingTLSOptions
+
 	// Benchmark setting.
 	Number int
 
@@ -81,6 +86,11 @@ func RegisterFlags() {
 	flag.StringVar(&TestContext.RuntimeServiceAddr, "runtime-endpoint", svcaddr, "Runtime service socket for client to connect.")
 	flag.DurationVar(&TestContext.RuntimeServiceTimeout, "runtime-service-timeout", 300*time.Second, "Timeout when trying to connect to a runtime service.")
 	flag.StringVar(&TestContext.RuntimeHandler, "runtime-handler", "", "Runtime handler to use in the test.")
+	flag.StringVar(&TestContext.StreamingTLS.CAFile, "streaming-ca", "", "CA bundle verifying the streaming server of the runtime. Default is empty, which uses the system roots.")
+	flag.StringVar(&TestContext.StreamingTLS.CertFile, "streaming-cert", "", "Client certificate for the streaming server.")
+	flag.StringVar(&TestContext.StreamingTLS.KeyFile, "streaming-key", "", "Key of the client certificate for the streaming server.")
+	flag.Var((*stringSlice)(&TestContext.StreamingTLS.ServerNames), "streaming-server-name", "Name the certificate of the streaming server has to be valid for, instead of the host of its URL. Can be repeated or comma separated to allow several names.")
+	flag.BoolVar(&TestContext.StreamingTLS.InsecureSkipVerify, "insecure-skip-tls-verify", false, "Do not verify the certificate of the streaming server.")
 	flag.IntVar(&TestContext.Number, "number", 5, "Number of PodSandbox/container in listing benchmark test.")
 
 	if runtime.GOOS == "windows" {
@@ -90,3 +100,15 @@ func RegisterFlags() {
 	}
 	flag.StringVar(&TestContext.RegistryPrefix, "registry-prefix", DefaultRegistryPrefix, "A possible registry prefix added to all images, like 'localhost:5000/'")
 }
+
+// stringSlice is a flag collecting repeated or comma separated values.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, strings.Split(value, ",")...)
+	return nil
+}
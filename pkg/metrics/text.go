@@ -0,0 +1,123 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics writes metrics in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the HTTP content type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// Family is a set of samples of the same metric.
+type Family struct {
+	// Name of the metric.
+	Name string
+	// Help describes the metric.
+	Help string
+	// Type of the metric, Counter or Gauge.
+	Type string
+	// Samples of the metric.
+	Samples []Sample
+}
+
+// Sample is a single value of a metric.
+type Sample struct {
+	// Labels are pairs of label names and values.
+	Labels []string
+	// Value of the sample.
+	Value float64
+}
+
+// NewFamily creates an empty family of samples.
+func NewFamily(name, help, metricType string) *Family {
+	return &Family{Name: name, Help: help, Type: metricType}
+}
+
+// Add adds a sample to the family. labels are pairs of label names and
+// values.
+func (f *Family) Add(value float64, labels ...string) {
+	f.Samples = append(f.Samples, Sample{Labels: labels, Value: value})
+}
+
+// Write writes families in the text exposition format. Families without
+// samples are skipped.
+func Write(w io.Writer, families []*Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, escape(f.Help, false))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			if len(s.Labels)%2 != 0 {
+				return fmt.Errorf("metric %s has an odd number of label names and values", f.Name)
+			}
+			bw.WriteString(f.Name)
+			for i := 0; i < len(s.Labels); i += 2 {
+				if i == 0 {
+					bw.WriteByte('{')
+				} else {
+					bw.WriteByte(',')
+				}
+				fmt.Fprintf(bw, `%s="%s"`, s.Labels[i], escape(s.Labels[i+1], true))
+			}
+			if len(s.Labels) > 0 {
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+// escape escapes backslashes and line feeds, and double quotes in label
+// values.
+func escape(s string, quotes bool) string {
+	r := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	if quotes {
+		r = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+	}
+	return r.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	up := NewFamily("cri_up", "Whether the runtime\nresponded.", Gauge)
+	up.Add(1)
+	cpu := NewFamily("cri_container_cpu_usage_seconds_total", "Cumulative CPU time.", Counter)
+	cpu.Add(1.5, "container", "nginx", "pod", `we"b\1`)
+	cpu.Add(math.Inf(1), "container", "redis")
+	empty := NewFamily("cri_empty", "Skipped.", Gauge)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, []*Family{up, cpu, empty}); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP cri_up Whether the runtime\nresponded.
+# TYPE cri_up gauge
+cri_up 1
+# HELP cri_container_cpu_usage_seconds_total Cumulative CPU time.
+# TYPE cri_container_cpu_usage_seconds_total counter
+cri_container_cpu_usage_seconds_total{container="nginx",pod="we\"b\\1"} 1.5
+cri_container_cpu_usage_seconds_total{container="redis"} +Inf
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, buf.String())
+	}
+
+	odd := NewFamily("cri_odd", "Odd labels.", Gauge)
+	odd.Add(1, "container")
+	if err := Write(&buf, []*Family{odd}); err == nil {
+		t.Errorf("expected an error for an odd number of labels")
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const noneValue = "<none>"
+
+var jsonPathRegexp = regexp.MustCompile(`^\{\.?([^{}]+)\}$|^\.?([^{}]+)$`)
+
+// relaxedJSONPathExpression turns the shorthand forms `name1.name2`,
+// `.name1.name2` and `{name1.name2}` into the JSONPath `{.name1.name2}`.
+// Expressions which already are templates, like `{range .items[*]}{.id}{end}`,
+// are returned unchanged.
+func relaxedJSONPathExpression(expr string) (string, error) {
+	if strings.Count(expr, "{") > 1 {
+		return expr, nil
+	}
+	submatches := jsonPathRegexp.FindStringSubmatch(expr)
+	if submatches == nil {
+		return "", errors.Errorf("unexpected path %q, expected a 'name1.name2' or '.name1.name2' or '{name1.name2}' or '{.name1.name2}'", expr)
+	}
+	field := submatches[1]
+	if field == "" {
+		field = submatches[2]
+	}
+	return fmt.Sprintf("{.%s}", field), nil
+}
+
+// column is a single column of the custom-columns format.
+type column struct {
+	header   string
+	jsonPath *jsonpath.JSONPath
+}
+
+// parseCustomColumns parses a `HEADER:.path[,HEADER:.path...]` specification.
+func parseCustomColumns(spec string) ([]column, error) {
+	if spec == "" {
+		return nil, errors.New("custom-columns output requires a column specification, e.g. custom-columns=ID:.id")
+	}
+	var columns []column
+	for _, part := range strings.Split(spec, ",") {
+		colSpec := strings.SplitN(part, ":", 2)
+		if len(colSpec) != 2 || colSpec[0] == "" || colSpec[1] == "" {
+			return nil, errors.Errorf("unexpected custom-columns spec %q, expected <header>:<json-path-expr>", part)
+		}
+		expr, err := relaxedJSONPathExpression(colSpec[1])
+		if err != nil {
+			return nil, err
+		}
+		jp := jsonpath.New(colSpec[0]).AllowMissingKeys(true)
+		if err := jp.Parse(expr); err != nil {
+			return nil, errors.Wrapf(err, "parse custom column %q", colSpec[0])
+		}
+		columns = append(columns, column{header: colSpec[0], jsonPath: jp})
+	}
+	return columns, nil
+}
+
+// printCustomColumns renders one table row per item.
+func printCustomColumns(w io.Writer, columns []column, items []Item) error {
+	tw := tabwriter.NewWriter(w, 20, 1, 3, ' ', 0)
+	headers := make([]string, 0, len(columns))
+	for _, c := range columns {
+		headers = append(headers, c.header)
+	}
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+
+	for _, item := range items {
+		data, err := ToUnstructured(item.Object)
+		if err != nil {
+			return err
+		}
+		row := make([]string, 0, len(columns))
+		for _, c := range columns {
+			value, err := findValue(c.jsonPath, data)
+			if err != nil {
+				return errors.Wrapf(err, "evaluate column %q", c.header)
+			}
+			row = append(row, value)
+		}
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// findValue evaluates jp against data and joins multiple results with
+// commas. Missing values are rendered as <none>.
+func findValue(jp *jsonpath.JSONPath, data interface{}) (string, error) {
+	results, err := jp.FindResults(data)
+	if err != nil {
+		return "", err
+	}
+	var values []string
+	for _, result := range results {
+		for _, r := range result {
+			values = append(values, fmt.Sprint(r.Interface()))
+		}
+	}
+	if len(values) == 0 {
+		return noneValue, nil
+	}
+	return strings.Join(values, ","), nil
+}
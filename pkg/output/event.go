@@ -0,0 +1,51 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Types of the events streamed by watch mode.
+const (
+	EventAdded    = "ADDED"
+	EventModified = "MODIFIED"
+	EventDeleted  = "DELETED"
+)
+
+// Event describes a change of a watched object.
+type Event struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+// PrintEvent writes an event of the given type for obj as a single line of
+// JSON, so that watch mode produces a JSON lines stream.
+func PrintEvent(w io.Writer, eventType string, obj interface{}) error {
+	data, err := marshalCompact(obj)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(Event{Type: eventType, Object: data})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(line))
+	return err
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package output renders CRI objects in the output formats shared by all
+// crictl list and inspect commands.
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/jsonpath"
+	"sigs.k8s.io/yaml"
+)
+
+// Supported output formats.
+const (
+	FormatJSON          = "json"
+	FormatYAML          = "yaml"
+	FormatGoTemplate    = "go-template"
+	FormatJSONPath      = "jsonpath"
+	FormatCustomColumns = "custom-columns"
+	FormatTable         = "table"
+	FormatWide          = "wide"
+	FormatName          = "name"
+)
+
+// Usage is the help text of the --output flag of commands which support all
+// formats.
+const Usage = "Output format, One of: json|yaml|go-template|jsonpath|custom-columns=NAME:.path[,...]|table|wide|name"
+
+// Item is a single element of a list. It is rendered on its own row by the
+// custom-columns and name formats.
+type Item struct {
+	// Name identifies the item in the name format.
+	Name string
+	// Object is the item itself.
+	Object interface{}
+}
+
+// Printer renders objects in one of the supported output formats.
+type Printer struct {
+	format   string
+	tmplStr  string
+	jsonPath *jsonpath.JSONPath
+	columns  []column
+}
+
+// NewPrinter creates a printer from the value of an --output flag. The
+// go-template, jsonpath and custom-columns formats take their argument
+// either in the `format=argument` form or, for backwards compatibility,
+// from tmplStr. An empty format selects the table format.
+func NewPrinter(format, tmplStr string) (*Printer, error) {
+	if idx := strings.Index(format, "="); idx >= 0 {
+		format, tmplStr = format[:idx], format[idx+1:]
+	}
+	p := &Printer{format: format, tmplStr: tmplStr}
+	switch format {
+	case "":
+		p.format = FormatTable
+	case FormatJSON, FormatYAML, FormatTable, FormatWide, FormatName:
+	case FormatGoTemplate:
+		if tmplStr == "" {
+			return nil, errors.New("go-template output requires a template")
+		}
+		if _, err := newTemplate(tmplStr); err != nil {
+			return nil, err
+		}
+	case FormatJSONPath:
+		if tmplStr == "" {
+			return nil, errors.New("jsonpath output requires an expression")
+		}
+		expr, err := relaxedJSONPathExpression(tmplStr)
+		if err != nil {
+			return nil, err
+		}
+		p.jsonPath = jsonpath.New("output")
+		if err := p.jsonPath.Parse(expr); err != nil {
+			return nil, errors.Wrapf(err, "parse jsonpath %q", tmplStr)
+		}
+	case FormatCustomColumns:
+		columns, err := parseCustomColumns(tmplStr)
+		if err != nil {
+			return nil, err
+		}
+		p.columns = columns
+	default:
+		return nil, errors.Errorf("unsupported output format %q", format)
+	}
+	return p, nil
+}
+
+// Format returns the name of the output format without its argument.
+func (p *Printer) Format() string {
+	return p.format
+}
+
+// Structured reports whether the printer renders objects on its own. The
+// table and wide formats are command specific and must be rendered by the
+// caller.
+func (p *Printer) Structured() bool {
+	return p.format != FormatTable && p.format != FormatWide
+}
+
+// PrintItems renders the results of an inspect command. A single item is
+// rendered as an object and several items as an array of objects.
+func (p *Printer) PrintItems(w io.Writer, items []Item) error {
+	if len(items) == 1 {
+		return p.PrintList(w, items[0].Object, items)
+	}
+	objs := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		objs = append(objs, item.Object)
+	}
+	return p.PrintList(w, objs, items)
+}
+
+// PrintList renders list, which is usually a list response. The json, yaml,
+// go-template and jsonpath formats render list as a whole, whereas the
+// custom-columns and name formats render one row per item.
+func (p *Printer) PrintList(w io.Writer, list interface{}, items []Item) error {
+	switch p.format {
+	case FormatName:
+		for _, item := range items {
+			if item.Name == "" {
+				return errors.New("name output is not supported for this object")
+			}
+			fmt.Fprintln(w, item.Name)
+		}
+		return nil
+	case FormatCustomColumns:
+		return printCustomColumns(w, p.columns, items)
+	}
+
+	data, err := Marshal(list)
+	if err != nil {
+		return err
+	}
+	switch p.format {
+	case FormatJSON:
+		_, err = fmt.Fprintln(w, string(data))
+	case FormatYAML:
+		var y []byte
+		if y, err = yaml.JSONToYAML(data); err == nil {
+			_, err = fmt.Fprintln(w, string(y))
+		}
+	case FormatGoTemplate:
+		var out string
+		if out, err = tmplExecuteRawJSON(p.tmplStr, string(data)); err == nil {
+			_, err = fmt.Fprintln(w, out)
+		}
+	case FormatJSONPath:
+		var raw interface{}
+		if raw, err = decodeRawJSON(data); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err = p.jsonPath.Execute(&buf, raw); err == nil {
+			_, err = fmt.Fprintln(w, buf.String())
+		}
+	default:
+		err = errors.Errorf("output format %q must be rendered by the command", p.format)
+	}
+	return err
+}
+
+// Marshal converts obj into indented JSON. Protobuf messages are marshaled
+// with their JSON field names and default values, json.RawMessage is used
+// as is and slices of either are marshaled into a JSON array.
+func Marshal(obj interface{}) ([]byte, error) {
+	data, err := marshalCompact(obj)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, data, "", "  "); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalCompact(obj interface{}) ([]byte, error) {
+	switch o := obj.(type) {
+	case proto.Message:
+		var buf bytes.Buffer
+		marshaler := jsonpb.Marshaler{EmitDefaults: true}
+		if err := marshaler.Marshal(&buf, o); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case json.RawMessage:
+		return o, nil
+	case []interface{}:
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, elem := range o {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			data, err := marshalCompact(elem)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(data)
+		}
+		buf.WriteByte(']')
+		return buf.Bytes(), nil
+	default:
+		return json.Marshal(obj)
+	}
+}
+
+// ToUnstructured converts obj into generic JSON data, as consumed by
+// templates and JSONPath expressions.
+func ToUnstructured(obj interface{}) (interface{}, error) {
+	data, err := marshalCompact(obj)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRawJSON(data)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestPrinter(t *testing.T) {
+	list := &pb.ListContainersResponse{
+		Containers: []*pb.Container{
+			{Id: "c1", Metadata: &pb.ContainerMetadata{Name: "nginx"}},
+			{Id: "c2", Metadata: &pb.ContainerMetadata{Name: "redis", Attempt: 2}},
+		},
+	}
+	items := []Item{
+		{Name: "c1", Object: list.Containers[0]},
+		{Name: "c2", Object: list.Containers[1]},
+	}
+
+	testcases := []struct {
+		output   string
+		tmplStr  string
+		expected string
+	}{
+		{
+			output:   "name",
+			expected: "c1\nc2\n",
+		},
+		{
+			output:   "jsonpath={.containers[*].id}",
+			expected: "c1 c2\n",
+		},
+		{
+			output:   "jsonpath",
+			tmplStr:  "{range .containers[*]}{.metadata.name}{\"\\n\"}{end}",
+			expected: "nginx\nredis\n\n",
+		},
+		{
+			output:   "go-template={{range .containers}}{{.id}} {{end}}",
+			expected: "c1 c2 \n",
+		},
+		{
+			output:   "custom-columns=ID:.id,NAME:{.metadata.name},LABELS:.labels.app",
+			expected: "ID                  NAME                LABELS\nc1                  nginx               <none>\nc2                  redis               <none>\n",
+		},
+	}
+
+	for _, tc := range testcases {
+		printer, err := NewPrinter(tc.output, tc.tmplStr)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tc.output, err)
+		}
+		var buf bytes.Buffer
+		if err := printer.PrintList(&buf, list, items); err != nil {
+			t.Fatalf("unexpected error printing %q: %v", tc.output, err)
+		}
+		if buf.String() != tc.expected {
+			t.Errorf("output %q: expected %q, but got %q", tc.output, tc.expected, buf.String())
+		}
+	}
+}
+
+func TestPrintItemsJSON(t *testing.T) {
+	printer, err := NewPrinter(FormatJSON, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := []Item{
+		{Name: "a", Object: json.RawMessage(`{"status":{"id":"a"},"info":"x"}`)},
+		{Name: "b", Object: &pb.Image{Id: "b"}},
+	}
+
+	var buf bytes.Buffer
+	if err := printer.PrintItems(&buf, items[:1]); err != nil {
+		t.Fatal(err)
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &obj); err != nil {
+		t.Errorf("expected a single JSON object, but got %q: %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err := printer.PrintItems(&buf, items); err != nil {
+		t.Fatal(err)
+	}
+	var arr []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &arr); err != nil {
+		t.Fatalf("expected a JSON array, but got %q: %v", buf.String(), err)
+	}
+	if len(arr) != 2 || arr[1]["id"] != "b" {
+		t.Errorf("unexpected JSON array %v", arr)
+	}
+}
+
+func TestNewPrinterErrors(t *testing.T) {
+	for _, output := range []string{
+		"xml",
+		"go-template",
+		"go-template={{.id",
+		"jsonpath",
+		"custom-columns",
+		"custom-columns=ID",
+		"custom-columns=ID:{{.id}}",
+	} {
+		if _, err := NewPrinter(output, ""); err == nil {
+			t.Errorf("expected an error for output %q", output)
+		}
+	}
+
+	p, err := NewPrinter("", "")
+	if err != nil || p.Format() != FormatTable || p.Structured() {
+		t.Errorf("expected an empty output to select the table format")
+	}
+	if p, err := NewPrinter(FormatWide, ""); err != nil || p.Structured() {
+		t.Errorf("expected the wide format to be rendered by the command")
+	}
+}
+
+func TestPrintEvent(t *testing.T) {
+	var buf bytes.Buffer
+	if err := PrintEvent(&buf, EventAdded, &pb.Container{Id: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := PrintEvent(&buf, EventDeleted, &pb.Container{Id: "c2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected two JSON lines, but got %q", buf.String())
+	}
+	var event struct {
+		Type   string
+		Object struct{ ID string }
+	}
+	if err := json.Unmarshal(lines[1], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventDeleted || event.Object.ID != "c2" {
+		t.Errorf("unexpected event %s", lines[1])
+	}
+}
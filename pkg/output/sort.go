@@ -0,0 +1,175 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// SortKeys maps the well-known --sort-by keys of a command to the JSONPath
+// expressions they stand for.
+type SortKeys map[string]string
+
+// Sorter orders list items by a well-known key or a JSONPath expression.
+// A nil Sorter keeps the original order.
+type Sorter struct {
+	key      string
+	jsonPath *jsonpath.JSONPath
+	reverse  bool
+}
+
+// NewSorter creates a sorter for the value of a --sort-by flag. sortBy is
+// looked up in keys first and is otherwise used as a JSONPath expression.
+// An empty sortBy returns a nil Sorter.
+func NewSorter(sortBy string, reverse bool, keys SortKeys) (*Sorter, error) {
+	if sortBy == "" {
+		return nil, nil
+	}
+	expr := sortBy
+	if path, ok := keys[sortBy]; ok {
+		expr = path
+	}
+	expr, err := relaxedJSONPathExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	jp := jsonpath.New("sort-by").AllowMissingKeys(true)
+	if err := jp.Parse(expr); err != nil {
+		return nil, errors.Wrapf(err, "parse sort-by %q", sortBy)
+	}
+	return &Sorter{key: sortBy, jsonPath: jp, reverse: reverse}, nil
+}
+
+// Value returns the value obj is sorted by, or nil if it has none.
+func (s *Sorter) Value(obj interface{}) (interface{}, error) {
+	data, err := ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	results, err := s.jsonPath.FindResults(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		for _, r := range result {
+			return r.Interface(), nil
+		}
+	}
+	return nil, nil
+}
+
+// Sort orders slice, which must be a slice of objects understood by
+// Marshal, in place.
+func (s *Sorter) Sort(slice interface{}) error {
+	if s == nil {
+		return nil
+	}
+	v := reflect.ValueOf(slice)
+	values := make([]interface{}, v.Len())
+	for i := range values {
+		value, err := s.Value(v.Index(i).Interface())
+		if err != nil {
+			return errors.Wrapf(err, "evaluate sort-by %q", s.key)
+		}
+		values[i] = value
+	}
+	s.sortValues(slice, values)
+	return nil
+}
+
+func (s *Sorter) sortValues(slice interface{}, values []interface{}) {
+	sort.Stable(&valueSorter{
+		values:  values,
+		swap:    reflect.Swapper(slice),
+		reverse: s.reverse,
+	})
+}
+
+type valueSorter struct {
+	values  []interface{}
+	swap    func(i, j int)
+	reverse bool
+}
+
+func (v *valueSorter) Len() int { return len(v.values) }
+
+func (v *valueSorter) Swap(i, j int) {
+	v.values[i], v.values[j] = v.values[j], v.values[i]
+	v.swap(i, j)
+}
+
+func (v *valueSorter) Less(i, j int) bool {
+	if v.reverse {
+		return compareValues(v.values[j], v.values[i]) < 0
+	}
+	return compareValues(v.values[i], v.values[j]) < 0
+}
+
+// compareValues compares two sort values. Missing values come first,
+// numbers (including the quoted 64 bit integers of the protobuf JSON
+// encoding) are compared numerically and everything else as strings.
+// Integers are compared as such, as large ones lose precision as floats.
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	as, bs := fmt.Sprint(a), fmt.Sprint(b)
+	if ai, err := strconv.ParseInt(as, 10, 64); err == nil {
+		if bi, err := strconv.ParseInt(bs, 10, 64); err == nil {
+			return compareOrdered(ai < bi, ai > bi)
+		}
+	}
+	if ai, err := strconv.ParseUint(as, 10, 64); err == nil {
+		if bi, err := strconv.ParseUint(bs, 10, 64); err == nil {
+			return compareOrdered(ai < bi, ai > bi)
+		}
+	}
+	af, aErr := strconv.ParseFloat(as, 64)
+	bf, bErr := strconv.ParseFloat(bs, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareOrdered(af < bf, af > bf)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return compareOrdered(as < bs, as > bs)
+}
+
+// compareOrdered returns the result of a comparison whose operands are less
+// or greater than each other.
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package output
+
+import (
+	"reflect"
+	"testing"
+
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+)
+
+func TestSorter(t *testing.T) {
+	keys := SortKeys{
+		"created": ".createdAt",
+		"name":    ".metadata.name",
+	}
+	containers := func() []*pb.Container {
+		return []*pb.Container{
+			{Id: "a", CreatedAt: 100, Metadata: &pb.ContainerMetadata{Name: "redis"}},
+			{Id: "b", CreatedAt: 20, Metadata: &pb.ContainerMetadata{Name: "nginx"}},
+			{Id: "c", CreatedAt: 3, Metadata: &pb.ContainerMetadata{Name: "etcd"}, Labels: map[string]string{"app": "x"}},
+		}
+	}
+	ids := func(cs []*pb.Container) []string {
+		var res []string
+		for _, c := range cs {
+			res = append(res, c.Id)
+		}
+		return res
+	}
+
+	testcases := []struct {
+		sortBy   string
+		reverse  bool
+		expected []string
+	}{
+		{sortBy: "", expected: []string{"a", "b", "c"}},
+		{sortBy: "name", expected: []string{"c", "b", "a"}},
+		{sortBy: "name", reverse: true, expected: []string{"a", "b", "c"}},
+		// 64 bit integers are quoted, but must be compared as numbers.
+		{sortBy: "created", expected: []string{"c", "b", "a"}},
+		{sortBy: "{.metadata.name}", expected: []string{"c", "b", "a"}},
+		// Items without the field come first.
+		{sortBy: ".labels.app", expected: []string{"a", "b", "c"}},
+		{sortBy: ".labels.app", reverse: true, expected: []string{"c", "a", "b"}},
+	}
+	for _, tc := range testcases {
+		sorter, err := NewSorter(tc.sortBy, tc.reverse, keys)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tc.sortBy, err)
+		}
+		cs := containers()
+		if err := sorter.Sort(cs); err != nil {
+			t.Fatalf("unexpected error sorting by %q: %v", tc.sortBy, err)
+		}
+		if got := ids(cs); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("sort by %q (reverse=%v): expected %v, but got %v", tc.sortBy, tc.reverse, tc.expected, got)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     interface{}
+		expected int
+	}{
+		{nil, "a", -1},
+		{"b", "a", 1},
+		{"10", "9", 1},
+		{1.5, "1.25", 1},
+		{"1", "a", -1},
+		// Integers which are equal as floats.
+		{"9007199254740993", "9007199254740992", 1},
+		{"18446744073709551615", "18446744073709551614", 1},
+		{"-9007199254740993", "-9007199254740992", -1},
+	} {
+		if result := compareValues(tc.a, tc.b); result != tc.expected {
+			t.Errorf("compare %v and %v: expected %d, but got %d", tc.a, tc.b, tc.expected, result)
+		}
+	}
+}
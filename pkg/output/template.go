@@ -14,7 +14,7 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-package main
+package output
 
 import (
 	"bytes"
@@ -43,27 +43,44 @@ func jsonBuiltinTmplFunc(v interface{}) string {
 	return o.String()
 }
 
-// tmplExecuteRawJSON executes the template with interface{} with decoded by
-// rawJSON string.
-func tmplExecuteRawJSON(tmplStr string, rawJSON string) (string, error) {
-	dec := json.NewDecoder(
-		bytes.NewReader([]byte(rawJSON)),
-	)
+// decodeRawJSON decodes rawJSON into generic data. Numbers are kept as
+// json.Number so that large integers are not rounded.
+func decodeRawJSON(rawJSON []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(rawJSON))
 	dec.UseNumber()
 
 	var raw interface{}
 	if err := dec.Decode(&raw); err != nil {
-		return "", errors.Wrapf(err, "failed to decode json")
+		return nil, errors.Wrapf(err, "failed to decode json")
 	}
+	return raw, nil
+}
 
-	var o = new(bytes.Buffer)
+// newTemplate parses tmplStr as a golang template with the builtin functions.
+func newTemplate(tmplStr string) (*template.Template, error) {
 	tmpl, err := template.New("tmplExecuteRawJSON").Funcs(builtinTmplFuncs()).Parse(tmplStr)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to generate go-template")
+		return nil, errors.Wrapf(err, "failed to generate go-template")
 	}
 
 	// return error if key doesn't exist
-	tmpl = tmpl.Option("missingkey=error")
+	return tmpl.Option("missingkey=error"), nil
+}
+
+// tmplExecuteRawJSON executes the template with interface{} with decoded by
+// rawJSON string.
+func tmplExecuteRawJSON(tmplStr string, rawJSON string) (string, error) {
+	raw, err := decodeRawJSON([]byte(rawJSON))
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := newTemplate(tmplStr)
+	if err != nil {
+		return "", err
+	}
+
+	var o = new(bytes.Buffer)
 	if err := tmpl.Execute(o, raw); err != nil {
 		return "", errors.Wrapf(err, "failed to template data")
 	}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package socks5 implements the server side of the SOCKS5 protocol of RFC
+// 1928 for CONNECT requests of clients which do not authenticate.
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	version = 5
+
+	methodNoAuth       = 0
+	methodNoAcceptable = 0xff
+
+	commandConnect = 1
+
+	addressIPv4   = 1
+	addressDomain = 3
+	addressIPv6   = 4
+)
+
+// Reply codes of RFC 1928.
+const (
+	Succeeded byte = iota
+	GeneralFailure
+	NotAllowed
+	NetworkUnreachable
+	HostUnreachable
+	ConnectionRefused
+	TTLExpired
+	CommandNotSupported
+	AddressNotSupported
+)
+
+// ReadRequest negotiates the method of a client without authentication and
+// reads its request. It returns the host, an IP address or domain name, and
+// port of a CONNECT request, which has to be replied to with WriteReply.
+// Other requests are replied to with CommandNotSupported and returned as an
+// error.
+func ReadRequest(rw io.ReadWriter) (string, uint16, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(rw, header); err != nil {
+		return "", 0, errors.Wrap(err, "read method selection")
+	}
+	if header[0] != version {
+		return "", 0, errors.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(rw, methods); err != nil {
+		return "", 0, errors.Wrap(err, "read method selection")
+	}
+	if bytes.IndexByte(methods, methodNoAuth) < 0 {
+		_, _ = rw.Write([]byte{version, methodNoAcceptable})
+		return "", 0, errors.New("the client requires authentication")
+	}
+	if _, err := rw.Write([]byte{version, methodNoAuth}); err != nil {
+		return "", 0, err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(rw, request); err != nil {
+		return "", 0, errors.Wrap(err, "read request")
+	}
+	if request[0] != version {
+		return "", 0, errors.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	var addr []byte
+	switch request[3] {
+	case addressIPv4:
+		addr = make([]byte, net.IPv4len)
+	case addressIPv6:
+		addr = make([]byte, net.IPv6len)
+	case addressDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(rw, n); err != nil {
+			return "", 0, errors.Wrap(err, "read request")
+		}
+		addr = make([]byte, n[0])
+	default:
+		_ = WriteReply(rw, AddressNotSupported)
+		return "", 0, errors.Errorf("unsupported address type %d", request[3])
+	}
+	if _, err := io.ReadFull(rw, addr); err != nil {
+		return "", 0, errors.Wrap(err, "read request")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(rw, port); err != nil {
+		return "", 0, errors.Wrap(err, "read request")
+	}
+	if request[1] != commandConnect {
+		_ = WriteReply(rw, CommandNotSupported)
+		return "", 0, errors.Errorf("unsupported command %d", request[1])
+	}
+	host := string(addr)
+	if request[3] != addressDomain {
+		host = net.IP(addr).String()
+	}
+	return host, binary.BigEndian.Uint16(port), nil
+}
+
+// WriteReply replies to a request with the code. The bound address is left
+// unspecified, as clients rarely need it.
+func WriteReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{version, code, 0, addressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package socks5
+
+import (
+	"bytes"
+	"testing"
+)
+
+// conn reads the input of a client and records the replies.
+type conn struct {
+	*bytes.Reader
+	bytes.Buffer
+}
+
+func (c *conn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
+func newConn(input ...byte) *conn {
+	return &conn{Reader: bytes.NewReader(input)}
+}
+
+func TestReadRequest(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		input []byte
+		host  string
+		port  uint16
+	}{
+		{
+			desc:  "IPv4",
+			input: []byte{5, 1, 0, 5, 1, 0, 1, 127, 0, 0, 1, 0x1f, 0x90},
+			host:  "127.0.0.1",
+			port:  8080,
+		},
+		{
+			desc:  "IPv6",
+			input: []byte{5, 2, 2, 0, 5, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 80},
+			host:  "::1",
+			port:  80,
+		},
+		{
+			desc:  "domain",
+			input: append([]byte{5, 1, 0, 5, 1, 0, 3, 9}, append([]byte("localhost"), 0x01, 0xbb)...),
+			host:  "localhost",
+			port:  443,
+		},
+	} {
+		c := newConn(tc.input...)
+		host, port, err := ReadRequest(c)
+		if err != nil {
+			t.Errorf("%s: %v", tc.desc, err)
+			continue
+		}
+		if host != tc.host || port != tc.port {
+			t.Errorf("%s: expected %s:%d, but got %s:%d", tc.desc, tc.host, tc.port, host, port)
+		}
+		if !bytes.Equal(c.Bytes(), []byte{5, 0}) {
+			t.Errorf("%s: unexpected replies %v", tc.desc, c.Bytes())
+		}
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+	for _, tc := range []struct {
+		desc    string
+		input   []byte
+		replies []byte
+	}{
+		{
+			desc:  "SOCKS4",
+			input: []byte{4, 1, 0, 80, 127, 0, 0, 1, 0},
+		},
+		{
+			desc:    "authentication",
+			input:   []byte{5, 1, 2},
+			replies: []byte{5, 0xff},
+		},
+		{
+			desc:    "BIND",
+			input:   []byte{5, 1, 0, 5, 2, 0, 1, 127, 0, 0, 1, 0, 80},
+			replies: []byte{5, 0, 5, CommandNotSupported, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			desc:    "address type",
+			input:   []byte{5, 1, 0, 5, 1, 0, 2},
+			replies: []byte{5, 0, 5, AddressNotSupported, 0, 1, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			desc:    "truncated",
+			input:   []byte{5, 1, 0, 5, 1, 0, 1, 127, 0},
+			replies: []byte{5, 0},
+		},
+	} {
+		c := newConn(tc.input...)
+		if _, _, err := ReadRequest(c); err == nil {
+			t.Errorf("%s: expected an error", tc.desc)
+		}
+		if !bytes.Equal(c.Bytes(), tc.replies) {
+			t.Errorf("%s: expected replies %v, but got %v", tc.desc, tc.replies, c.Bytes())
+		}
+	}
+}
@@ -28,7 +28,7 @@ import (
 	"time"
 
 	"github.com/kubernetes-sigs/cri-tools/pkg/framework"
-	"k8s.io/client-go/rest"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	"k8s.io/client-go/tools/portforward"
 	remoteclient "k8s.io/client-go/tools/remotecommand"
 	"k8s.io/client-go/transport/spdy"
@@ -179,10 +179,9 @@ func checkExec(c internalapi.RuntimeService, execServerURL, stdout string, stdou
 		wg.Wait()
 	}()
 
-	// Only http is supported now.
-	// TODO: support streaming APIs via tls.
 	url := parseURL(c, execServerURL)
-	e, err := remoteclient.NewSPDYExecutor(&rest.Config{TLSClientConfig: rest.TLSClientConfig{Insecure: true}}, "POST", url)
+	upgrader := newSPDYRoundTripper()
+	e, err := remoteclient.NewSPDYExecutorForTransports(upgrader, upgrader, "POST", url)
 	framework.ExpectNoError(err, "failed to create executor for %q", execServerURL)
 
 	streamOptions := remoteclient.StreamOptions{
@@ -206,6 +205,14 @@ func checkExec(c internalapi.RuntimeService, execServerURL, stdout string, stdou
 	framework.Logf("Check exec url %q succeed", execServerURL)
 }
 
+// newSPDYRoundTripper returns the round tripper upgrading the connections to
+// the streaming server, verified with the streaming TLS flags.
+func newSPDYRoundTripper() *spdystream.SpdyRoundTripper {
+	tlsConfig, err := framework.TestContext.StreamingTLS.TLSConfig()
+	framework.ExpectNoError(err, "failed to load the streaming TLS configuration")
+	return spdystream.NewRoundTripper(tlsConfig, true, false)
+}
+
 func parseURL(c internalapi.RuntimeService, serverURL string) *url.URL {
 	url, err := url.Parse(serverURL)
 	framework.ExpectNoError(err, "failed to parse url:  %q", serverURL)
@@ -286,10 +293,9 @@ func checkAttach(c internalapi.RuntimeService, attachServerURL string) {
 		}, 3*time.Second, time.Second).Should(Equal(attachEchoHelloOutput), "The stdout of attach should not contain other things")
 	}()
 
-	// Only http is supported now.
-	// TODO: support streaming APIs via tls.
 	url := parseURL(c, attachServerURL)
-	e, err := remoteclient.NewSPDYExecutor(&rest.Config{TLSClientConfig: rest.TLSClientConfig{Insecure: true}}, "POST", url)
+	upgrader := newSPDYRoundTripper()
+	e, err := remoteclient.NewSPDYExecutorForTransports(upgrader, upgrader, "POST", url)
 	framework.ExpectNoError(err, "failed to create executor for %q", attachServerURL)
 
 	err = e.Stream(remoteclient.StreamOptions{
@@ -321,10 +327,9 @@ func checkPortForward(c internalapi.RuntimeService, portForwardSeverURL string,
 	readyChan := make(chan struct{})
 	defer close(stopChan)
 
-	transport, upgrader, err := spdy.RoundTripperFor(&rest.Config{TLSClientConfig: rest.TLSClientConfig{Insecure: true}})
-	framework.ExpectNoError(err, "failed to create spdy round tripper")
+	upgrader := newSPDYRoundTripper()
 	url := parseURL(c, portForwardSeverURL)
-	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", url)
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: upgrader}, "POST", url)
 	pf, err := portforward.New(dialer, []string{fmt.Sprintf("%d:%d", hostPort, containerPort)}, stopChan, readyChan, os.Stdout, os.Stderr)
 	framework.ExpectNoError(err, "failed to create port forward for %q", portForwardSeverURL)
 
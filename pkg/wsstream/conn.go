@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wsstream implements the client side of the WebSocket channel
+// protocols of the Kubernetes streaming server, which container runtimes use
+// to serve exec, attach and port-forward requests. Every message of a
+// connection starts with the number of the channel the rest belongs to.
+package wsstream
+
+import (
+	"crypto/tls"
+	"net/url"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// V4ChannelProtocol is the binary channel protocol with a resize channel
+	// and the exit status encoded as JSON.
+	V4ChannelProtocol = "v4.channel.k8s.io"
+	// V5ChannelProtocol extends V4ChannelProtocol by the close channel, which
+	// allows to signal the end of stdin.
+	V5ChannelProtocol = "v5.channel.k8s.io"
+
+	stdinChannel  = 0
+	stdoutChannel = 1
+	stderrChannel = 2
+	errorChannel  = 3
+	resizeChannel = 4
+	closeChannel  = 255
+)
+
+// conn is a client WebSocket connection of a channel protocol.
+type conn struct {
+	ws *websocket.Conn
+	// protocol is the negotiated protocol, empty if the server did not
+	// select one.
+	protocol string
+}
+
+// dial opens a WebSocket connection to the http or https URL u, offering
+// the protocols in the order of preference.
+func dial(u *url.URL, tlsConfig *tls.Config, protocols ...string) (*conn, error) {
+	location := *u
+	origin := url.URL{Scheme: u.Scheme, Host: u.Host}
+	switch u.Scheme {
+	case "http":
+		location.Scheme = "ws"
+	case "https":
+		location.Scheme = "wss"
+	default:
+		return nil, errors.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Protocol = protocols
+	config.TlsConfig = tlsConfig
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{ws: ws}
+	// The protocols are only narrowed down to the selected one if the server
+	// selected any.
+	if p := ws.Config().Protocol; len(p) == 1 {
+		c.protocol = p[0]
+	}
+	return c, nil
+}
+
+// write sends data on the channel. It is safe to call concurrently.
+func (c *conn) write(channel byte, data []byte) error {
+	return websocket.Message.Send(c.ws, append([]byte{channel}, data...))
+}
+
+// read returns the next message. It returns io.EOF once the server closed
+// the connection.
+func (c *conn) read() (byte, []byte, error) {
+	for {
+		var msg []byte
+		if err := websocket.Message.Receive(c.ws, &msg); err != nil {
+			return 0, nil, err
+		}
+		if len(msg) > 0 {
+			return msg[0], msg[1:], nil
+		}
+	}
+}
+
+func (c *conn) close() error {
+	return c.ws.Close()
+}
+
+// IsUpgradeFailure returns whether err is caused by the server not upgrading
+// the connection to the WebSocket protocol, in which case another transport
+// can be tried with a new URL.
+func IsUpgradeFailure(err error) bool {
+	var dialErr *websocket.DialError
+	if !errors.As(err, &dialErr) {
+		return false
+	}
+	var protocolErr *websocket.ProtocolError
+	return errors.As(dialErr.Err, &protocolErr)
+}
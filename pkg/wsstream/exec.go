@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wsstream
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	remotecommandconsts "k8s.io/apimachinery/pkg/util/remotecommand"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// executor streams exec and attach sessions over WebSocket connections.
+type executor struct {
+	tlsConfig *tls.Config
+	url       *url.URL
+	protocols []string
+}
+
+// NewExecutor returns an executor for the exec or attach URL of a streaming
+// server, which negotiates one of the protocols, by default the v5 or v4
+// channel protocol. Its errors are the ones of the SPDY executor of
+// client-go, e.g. an exec.CodeExitError for non-zero exit codes.
+//
+// The v4 protocol cannot signal the end of stdin, so commands reading stdin
+// until its end never finish.
+func NewExecutor(tlsConfig *tls.Config, u *url.URL, protocols ...string) remotecommand.Executor {
+	if len(protocols) == 0 {
+		protocols = []string{V5ChannelProtocol, V4ChannelProtocol}
+	}
+	return &executor{tlsConfig: tlsConfig, url: u, protocols: protocols}
+}
+
+// Stream opens the connection and copies the streams until the server closes
+// it.
+func (e *executor) Stream(opts remotecommand.StreamOptions) error {
+	c, err := dial(e.url, e.tlsConfig, e.protocols...)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+
+	if opts.Stdin != nil {
+		go c.copyStdin(opts.Stdin)
+	}
+	if opts.TerminalSizeQueue != nil {
+		go c.sendResizes(opts.TerminalSizeQueue)
+	}
+
+	var status []byte
+	for {
+		channel, data, err := c.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "read from stream")
+		}
+		var w io.Writer
+		switch channel {
+		case stdoutChannel:
+			w = opts.Stdout
+		case stderrChannel:
+			w = opts.Stderr
+		case errorChannel:
+			status = append(status, data...)
+		}
+		if w != nil {
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return decodeStatus(c.protocol, status)
+}
+
+// copyStdin sends stdin until it ends, which is signaled to the server with
+// the v5 protocol.
+func (c *conn) copyStdin(stdin io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := stdin.Read(buf)
+		if n > 0 {
+			if c.write(stdinChannel, buf[:n]) != nil {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF && c.protocol == V5ChannelProtocol {
+				c.write(closeChannel, []byte{stdinChannel})
+			}
+			return
+		}
+	}
+}
+
+// sendResizes sends the terminal sizes of the queue until it is done.
+func (c *conn) sendResizes(queue remotecommand.TerminalSizeQueue) {
+	for size := queue.Next(); size != nil; size = queue.Next() {
+		data, err := json.Marshal(size)
+		if err != nil {
+			return
+		}
+		if c.write(resizeChannel, data) != nil {
+			return
+		}
+	}
+}
+
+// decodeStatus returns the error of the status written to the error channel.
+func decodeStatus(protocol string, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if protocol != V4ChannelProtocol && protocol != V5ChannelProtocol {
+		// Older protocols write the error message as is.
+		return fmt.Errorf("error executing remote command: %s", data)
+	}
+	status := metav1.Status{}
+	if err := json.Unmarshal(data, &status); err != nil {
+		return fmt.Errorf("error stream protocol error: %v in %q", err, string(data))
+	}
+	switch status.Status {
+	case metav1.StatusSuccess:
+		return nil
+	case metav1.StatusFailure:
+		if status.Reason != remotecommandconsts.NonZeroExitCodeReason {
+			return errors.New(status.Message)
+		}
+		if status.Details == nil {
+			return errors.New("error stream protocol error: details must be set")
+		}
+		for _, cause := range status.Details.Causes {
+			if cause.Type != remotecommandconsts.ExitCodeCauseType {
+				continue
+			}
+			rc, err := strconv.ParseUint(cause.Message, 10, 8)
+			if err != nil {
+				return fmt.Errorf("error stream protocol error: invalid exit code value %q", cause.Message)
+			}
+			return exec.CodeExitError{
+				Err:  fmt.Errorf("command terminated with exit code %d", rc),
+				Code: int(rc),
+			}
+		}
+		return fmt.Errorf("error stream protocol error: no %s cause given", remotecommandconsts.ExitCodeCauseType)
+	default:
+		return errors.New("error stream protocol error: unknown error")
+	}
+}
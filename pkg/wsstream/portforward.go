@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wsstream
+
+import (
+	"crypto/tls"
+	"io"
+	"net/url"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// portHeaderLength is the length of the port number every channel of a
+	// port-forward connection starts with.
+	portHeaderLength = 2
+
+	portDataChannel  = 0
+	portErrorChannel = 1
+)
+
+// PortConn is the connection of a port-forward request of a single port.
+type PortConn struct {
+	c    *conn
+	port uint16
+}
+
+// DialPort opens the connection of the port-forward request u of the single
+// port. The streaming server serves a single connection per port and URL, so
+// every local connection needs a request of its own.
+func DialPort(tlsConfig *tls.Config, u *url.URL, port uint16) (*PortConn, error) {
+	c, err := dial(u, tlsConfig, V4ChannelProtocol)
+	if err != nil {
+		return nil, err
+	}
+	return &PortConn{c: c, port: port}, nil
+}
+
+// ForwardPort dials the port-forward request u and forwards local to the
+// port, see DialPort and Forward. local is closed once ForwardPort returns.
+func ForwardPort(tlsConfig *tls.Config, u *url.URL, port uint16, local io.ReadWriteCloser) error {
+	p, err := DialPort(tlsConfig, u, port)
+	if err != nil {
+		local.Close()
+		return err
+	}
+	return p.Forward(local)
+}
+
+// Close closes the connection without forwarding anything.
+func (p *PortConn) Close() error {
+	return p.c.close()
+}
+
+// Forward copies between the local connection and the port of the pod until
+// either side closes. Both connections are closed once Forward returns.
+func (p *PortConn) Forward(local io.ReadWriteCloser) error {
+	c, port := p.c, p.port
+
+	var (
+		once      sync.Once
+		localDone = make(chan struct{})
+	)
+	closeAll := func() {
+		once.Do(func() {
+			close(localDone)
+			c.close()
+			local.Close()
+		})
+	}
+	defer closeAll()
+
+	go func() {
+		defer closeAll()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := local.Read(buf)
+			if n > 0 {
+				if c.write(portDataChannel, buf[:n]) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Both channels start with the port, which is skipped.
+	skip := map[byte]int{portDataChannel: portHeaderLength, portErrorChannel: portHeaderLength}
+	var remoteErr []byte
+	for {
+		channel, data, err := c.read()
+		if err != nil {
+			select {
+			case <-localDone:
+				// The local connection was closed.
+				err = nil
+			default:
+				if err == io.EOF {
+					err = nil
+				}
+			}
+			if err == nil && len(remoteErr) > 0 {
+				err = errors.Errorf("forwarding port %d: %s", port, remoteErr)
+			}
+			return err
+		}
+		if n := skip[channel]; n > 0 {
+			if n > len(data) {
+				n = len(data)
+			}
+			skip[channel] -= n
+			data = data[n:]
+		}
+		switch channel {
+		case portDataChannel:
+			if _, err := local.Write(data); err != nil {
+				return nil
+			}
+		case portErrorChannel:
+			remoteErr = append(remoteErr, data...)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2021 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wsstream
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+	"k8s.io/client-go/tools/remotecommand"
+	clientexec "k8s.io/client-go/util/exec"
+	pb "k8s.io/cri-api/pkg/apis/runtime/v1alpha2"
+	"k8s.io/kubernetes/pkg/kubelet/cri/streaming"
+	utilexec "k8s.io/utils/exec"
+)
+
+// fakeRuntime runs the commands "echo ARGS...", "read", which echoes the
+// first line of stdin, "size", which prints the first terminal size, and
+// "exit CODE". Attach prints "attached" and port-forward echoes the data
+// prefixed with the port.
+type fakeRuntime struct{}
+
+func (fakeRuntime) Exec(containerID string, cmd []string, in io.Reader, out, errOut io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	switch cmd[0] {
+	case "echo":
+		fmt.Fprintln(out, strings.Join(cmd[1:], " "))
+		fmt.Fprintln(errOut, "to stderr")
+	case "read":
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, line)
+	case "size":
+		size := <-resize
+		fmt.Fprintf(out, "%dx%d\n", size.Width, size.Height)
+	case "exit":
+		var code int
+		fmt.Sscan(cmd[1], &code)
+		return utilexec.CodeExitError{Err: fmt.Errorf("exit %d", code), Code: code}
+	}
+	return nil
+}
+
+func (fakeRuntime) Attach(containerID string, in io.Reader, out, err io.WriteCloser, tty bool, resize <-chan remotecommand.TerminalSize) error {
+	fmt.Fprintln(out, "attached")
+	return nil
+}
+
+func (fakeRuntime) PortForward(podSandboxID string, port int32, stream io.ReadWriteCloser) error {
+	defer stream.Close()
+	line, err := bufio.NewReader(stream).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stream, "%d: %s", port, line)
+	return err
+}
+
+// newStreamingServer returns a streaming server of the kubelet, as used by
+// the runtimes, serving fakeRuntime.
+func newStreamingServer(t *testing.T) (streaming.Server, func()) {
+	ts := httptest.NewUnstartedServer(nil)
+	config := streaming.DefaultConfig
+	config.BaseURL = &url.URL{Scheme: "http", Host: ts.Listener.Addr().String()}
+	server, err := streaming.NewServer(config, fakeRuntime{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Config.Handler = server
+	ts.Start()
+	return server, ts.Close
+}
+
+func execURL(t *testing.T, server streaming.Server, tty, stdin bool, cmd ...string) *url.URL {
+	r, err := server.GetExec(&pb.ExecRequest{ContainerId: "c1", Cmd: cmd, Tty: tty, Stdin: stdin, Stdout: true, Stderr: !tty})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(r.Url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// fixedSizeQueue returns a single terminal size.
+type fixedSizeQueue struct {
+	sizes []remotecommand.TerminalSize
+}
+
+func (q *fixedSizeQueue) Next() *remotecommand.TerminalSize {
+	if len(q.sizes) == 0 {
+		select {}
+	}
+	size := q.sizes[0]
+	q.sizes = q.sizes[1:]
+	return &size
+}
+
+func TestExecutor(t *testing.T) {
+	server, stop := newStreamingServer(t)
+	defer stop()
+
+	var stdout, stderr bytes.Buffer
+	err := NewExecutor(nil, execURL(t, server, false, false, "echo", "hello", "world")).Stream(remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "hello world\n" || stderr.String() != "to stderr\n" {
+		t.Errorf("unexpected stdout %q and stderr %q", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	err = NewExecutor(nil, execURL(t, server, false, true, "read")).Stream(remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("input\n"),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "input\n" {
+		t.Errorf("expected stdin to be echoed, but got %q", stdout.String())
+	}
+
+	stdout.Reset()
+	err = NewExecutor(nil, execURL(t, server, true, true, "size")).Stream(remotecommand.StreamOptions{
+		Stdin:             strings.NewReader(""),
+		Stdout:            &stdout,
+		Tty:               true,
+		TerminalSizeQueue: &fixedSizeQueue{sizes: []remotecommand.TerminalSize{{Width: 80, Height: 24}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "80x24\n" {
+		t.Errorf("expected the terminal size, but got %q", stdout.String())
+	}
+
+	err = NewExecutor(nil, execURL(t, server, false, false, "exit", "3")).Stream(remotecommand.StreamOptions{Stdout: &stdout, Stderr: &stderr})
+	if exitErr, ok := err.(clientexec.CodeExitError); !ok || exitErr.Code != 3 {
+		t.Errorf("expected exit code 3, but got %v", err)
+	}
+
+	r, err := server.GetAttach(&pb.AttachRequest{ContainerId: "c1", Stdout: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(r.Url)
+	stdout.Reset()
+	if err := NewExecutor(nil, u).Stream(remotecommand.StreamOptions{Stdout: &stdout}); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "attached\n" {
+		t.Errorf("unexpected attach output %q", stdout.String())
+	}
+
+	// The token of a URL can only be used once.
+	u = execURL(t, server, false, false, "echo")
+	if err := NewExecutor(nil, u).Stream(remotecommand.StreamOptions{Stdout: &stdout}); err != nil {
+		t.Fatal(err)
+	}
+	err = NewExecutor(nil, u).Stream(remotecommand.StreamOptions{Stdout: &stdout})
+	if !IsUpgradeFailure(err) {
+		t.Errorf("expected an upgrade failure, but got %v", err)
+	}
+}
+
+func TestExecutorV5(t *testing.T) {
+	// The kubelet streaming server only speaks v4, so the close signal of v5
+	// is checked with a server of its own, which echoes stdin once it ends.
+	ts := httptest.NewServer(websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) error {
+			config.Protocol = []string{V5ChannelProtocol}
+			return nil
+		},
+		Handler: func(ws *websocket.Conn) {
+			var stdin []byte
+			for {
+				var msg []byte
+				if err := websocket.Message.Receive(ws, &msg); err != nil {
+					return
+				}
+				if msg[0] == closeChannel && msg[1] == stdinChannel {
+					break
+				}
+				stdin = append(stdin, msg[1:]...)
+			}
+			websocket.Message.Send(ws, append([]byte{stdoutChannel}, stdin...))
+			websocket.Message.Send(ws, append([]byte{errorChannel}, `{"status":"Success"}`...))
+			ws.Close()
+		},
+	})
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	var stdout bytes.Buffer
+	err := NewExecutor(nil, u).Stream(remotecommand.StreamOptions{
+		Stdin:  strings.NewReader("all of stdin"),
+		Stdout: &stdout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "all of stdin" {
+		t.Errorf("unexpected stdout %q", stdout.String())
+	}
+}
+
+func TestForwardPort(t *testing.T) {
+	server, stop := newStreamingServer(t)
+	defer stop()
+
+	for i := 0; i < 2; i++ {
+		r, err := server.GetPortForward(&pb.PortForwardRequest{PodSandboxId: "p1", Port: []int32{8080}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, _ := url.Parse(r.Url)
+		local, remote := net.Pipe()
+		errC := make(chan error, 1)
+		go func() { errC <- ForwardPort(nil, u, 8080, remote) }()
+
+		fmt.Fprintf(local, "request %d\n", i)
+		line, err := bufio.NewReader(local).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("8080: request %d\n", i); line != expected {
+			t.Errorf("expected %q, but got %q", expected, line)
+		}
+		local.Close()
+		if err := <-errC; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestForwardPortUpgradeFailure(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "no upgrade", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	local, remote := net.Pipe()
+	if err := ForwardPort(nil, u, 8080, remote); !IsUpgradeFailure(err) {
+		t.Errorf("expected an upgrade failure, but got %v", err)
+	}
+	// The local connection is closed, so its peer sees the end.
+	if _, err := local.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected the local connection to be closed, but got %v", err)
+	}
+}